@@ -80,8 +80,9 @@ func main() {
 		cancel()
 	}()
 
+	// on reconnect resume from the last message seen so nothing is lost or repeated
 	for {
-		err = client.ConnectToChat(ctx, token, chats[chatName])
+		err = client.ConnectToChat(ctx, token, chats[chatName], client.LastSeq())
 		if err != nil {
 			fmt.Println(err.Error())
 
@@ -14,6 +14,8 @@ import (
 	"net/url"
 	"os"
 	"sort"
+	"strconv"
+	"sync/atomic"
 )
 
 var ErrCtxDone = errors.New("context Done, closing WebSocket connection")
@@ -22,6 +24,7 @@ type Client struct {
 	fullAddress string
 	nickname    string
 	password    string
+	lastSeq     atomic.Int64 // sequence number of the last message received, used to resume after reconnect
 }
 
 type Config struct {
@@ -97,17 +100,45 @@ func (c *Client) Login() (string, error) {
 	return tokens.AccessToken, nil
 }
 
+// MapChats maps the names of all rooms to their IDs, reading the room list page by page.
 func (c *Client) MapChats(token string) (map[string]string, error) {
 	client := &http.Client{}
+	chats := make(map[string]string) // name -> id
 
-	req, err := http.NewRequest("GET", "http://"+c.fullAddress+"/chat/rooms", nil)
-	if err != nil {
-		return nil, fmt.Errorf("error http.NewRequest: %w", err)
-	}
+	after := ""
+	for {
+		u := url.URL{Scheme: "http", Host: c.fullAddress, Path: "/chat/rooms"}
+		if after != "" {
+			u.RawQuery = url.Values{"after": {after}}.Encode()
+		}
+
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("error http.NewRequest: %w", err)
+		}
+
+		req.Header.Add("Authorization", "Bearer "+token)
+		req.Header.Add("Content-Type", "application/json")
+
+		page, err := c.getRoomsPage(client, req)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range page.Rooms {
+			room := &page.Rooms[i]
+			chats[room.Name] = room.ID
+		}
+
+		if !page.HasMore || page.NextCursor == "" {
+			return chats, nil
+		}
 
-	req.Header.Add("Authorization", "Bearer "+token)
-	req.Header.Add("Content-Type", "application/json")
+		after = page.NextCursor
+	}
+}
 
+func (c *Client) getRoomsPage(client *http.Client, req *http.Request) (*RoomsPageResp, error) {
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("error set connection with chat: %w", err)
@@ -123,24 +154,27 @@ func (c *Client) MapChats(token string) (map[string]string, error) {
 		return nil, fmt.Errorf("error io.ReadAll: %w", err)
 	}
 
-	var rooms []RoomResp
-	err = json.Unmarshal(payload, &rooms)
+	var page RoomsPageResp
+	err = json.Unmarshal(payload, &page)
 	if err != nil {
 		return nil, fmt.Errorf("can not unmarshal payload: %w", err)
 	}
 
-	chats := make(map[string]string) // name -> id
-
-	for i := range rooms {
-		room := &rooms[i]
-		chats[room.Name] = room.ID
-	}
+	return &page, nil
+}
 
-	return chats, nil
+// LastSeq returns the sequence number of the last message received in the chat.
+func (c *Client) LastSeq() int64 {
+	return c.lastSeq.Load()
 }
 
-func (c *Client) ConnectToChat(ctxParent context.Context, token, chatID string) error {
+// ConnectToChat joins the chat. A positive since asks the server to replay
+// only the messages sent after it instead of the latest history.
+func (c *Client) ConnectToChat(ctxParent context.Context, token, chatID string, since int64) error {
 	u := url.URL{Scheme: "ws", Host: c.fullAddress, Path: "/chat/rooms/" + chatID}
+	if since > 0 {
+		u.RawQuery = url.Values{"since": {strconv.FormatInt(since, 10)}}.Encode()
+	}
 	fmt.Printf("connecting to %s", u.String())
 
 	headers := make(http.Header)
@@ -160,7 +194,7 @@ func (c *Client) ConnectToChat(ctxParent context.Context, token, chatID string)
 			case <-ctx.Done():
 				return ErrCtxDone
 			default:
-				if err = ReadConn(conn); err != nil {
+				if err = c.ReadConn(conn); err != nil {
 					return err
 				}
 			}
@@ -183,34 +217,132 @@ func (c *Client) ConnectToChat(ctxParent context.Context, token, chatID string)
 	return eg.Wait()
 }
 
-func ReadConn(conn *websocket.Conn) error {
+func (c *Client) ReadConn(conn *websocket.Conn) error {
 	_, payload, err := conn.ReadMessage()
 	if err != nil {
 		return fmt.Errorf("ошибка при чтении из WebSocket: %w", err)
 	}
 
-	var messages []Message
-	err = json.Unmarshal(payload, &messages)
+	var frame Envelope
+	err = json.Unmarshal(payload, &frame)
 	if err != nil {
+		return nil
+	}
+
+	switch frame.Type {
+	case frameHistory:
+		var history HistoryPayload
+		err = json.Unmarshal(frame.Payload, &history)
+		if err != nil {
+			return nil
+		}
+
+		if history.HasMore {
+			fmt.Println("... часть пропущенных сообщений не показана")
+		}
+
+		messages := history.Messages
+		sort.Slice(messages, func(i, j int) bool {
+			return messages[j].TimeCreated.After(messages[i].TimeCreated)
+		})
+
+		for i := range messages {
+			c.printMessage(&messages[i])
+		}
+
+	case frameMessage, frameSystem:
 		var msg Message
-		err = json.Unmarshal(payload, &msg)
+		err = json.Unmarshal(frame.Payload, &msg)
 		if err != nil {
 			return nil
 		}
 
-		fmt.Printf("(%s) %s: %s\n", msg.TimeCreated, msg.Username, msg.Content)
+		c.printMessage(&msg)
+
+	case frameEdit:
+		var msg Message
+		err = json.Unmarshal(frame.Payload, &msg)
+		if err != nil {
+			return nil
+		}
+
+		fmt.Printf("(%s) %s изменил сообщение: %s\n", msg.EditedAt, msg.Username, msg.Content)
+
+	case frameDelete:
+		var msg Message
+		err = json.Unmarshal(frame.Payload, &msg)
+		if err != nil {
+			return nil
+		}
+
+		fmt.Printf("(%s) сообщение %s от %s удалено\n", msg.DeletedAt, msg.ID, msg.Username)
+
+	case frameReaction:
+		var reaction ReactionPayload
+		err = json.Unmarshal(frame.Payload, &reaction)
+		if err != nil {
+			return nil
+		}
+
+		if reaction.Action == "add" {
+			fmt.Printf("пользователь %s поставил %s на сообщение %s\n", reaction.UserID, reaction.Emoji, reaction.MessageID)
+		} else {
+			fmt.Printf("пользователь %s убрал %s с сообщения %s\n", reaction.UserID, reaction.Emoji, reaction.MessageID)
+		}
+
+	case frameMention:
+		var msg Message
+		err = json.Unmarshal(frame.Payload, &msg)
+		if err != nil {
+			return nil
+		}
+
+		fmt.Printf("(%s) %s упомянул вас в комнате %s: %s\n", msg.TimeCreated, msg.Username, msg.RoomID, msg.Content)
+
+	case frameGap:
+		var gap GapPayload
+		err = json.Unmarshal(frame.Payload, &gap)
+		if err != nil {
+			return nil
+		}
+
+		// nothing after the gap is printed yet, so reconnecting with the last seq replays exactly what was dropped
+		return fmt.Errorf("сервер пропустил %d событий, переподключаемся", gap.Dropped)
+
+	case frameError:
+		var frameErr ErrorPayload
+		err = json.Unmarshal(frame.Payload, &frameErr)
+		if err != nil {
+			return nil
+		}
+
+		fmt.Printf("ошибка сервера (%s): %s\n", frameErr.Code, frameErr.Message)
 	}
 
-	sort.Slice(messages, func(i, j int) bool {
-		return messages[j].TimeCreated.After(messages[i].TimeCreated)
-	})
+	return nil
+}
 
-	for i := range messages {
-		msg := &messages[i]
+func (c *Client) printMessage(msg *Message) {
+	switch {
+	case msg.Kind == kindSystem && msg.Event == memberJoined:
+		fmt.Printf("(%s) %s вошел в комнату\n", msg.TimeCreated, msg.Username)
+	case msg.Kind == kindSystem:
+		fmt.Printf("(%s) %s вышел из комнаты\n", msg.TimeCreated, msg.Username)
+	case msg.ReplyTo != "":
+		fmt.Printf("(%s) %s в ответ на %s: %s\n", msg.TimeCreated, msg.Username, msg.ReplyTo, msg.Content)
+	case msg.ReplyCount > 0:
+		fmt.Printf("(%s) %s: %s [ответов: %d]\n", msg.TimeCreated, msg.Username, msg.Content, msg.ReplyCount)
+	default:
 		fmt.Printf("(%s) %s: %s\n", msg.TimeCreated, msg.Username, msg.Content)
 	}
 
-	return nil
+	for _, attachment := range msg.Attachments {
+		fmt.Printf("\tвложение %s (%d байт): %s\n", attachment.Name, attachment.Size, attachment.URL)
+	}
+
+	if msg.Seq > c.lastSeq.Load() {
+		c.lastSeq.Store(msg.Seq)
+	}
 }
 
 func WriteConn(conn *websocket.Conn) error {
@@ -220,7 +352,16 @@ func WriteConn(conn *websocket.Conn) error {
 		return fmt.Errorf("ошибка при чтении ввода stdin: %w", err)
 	}
 
-	err = conn.WriteMessage(websocket.TextMessage, []byte(message))
+	payload, err := json.Marshal(SendMessagePayload{Content: message})
+	if err != nil {
+		return fmt.Errorf("can not marshal SendMessagePayload: %w", err)
+	}
+
+	err = conn.WriteJSON(Envelope{
+		Version: protocolVersion,
+		Type:    frameMessage,
+		Payload: payload,
+	})
 	if err != nil {
 		return fmt.Errorf("ошибка при записи сообщение в WebSocket: %w", err)
 	}
@@ -1,13 +1,76 @@
 package chat
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
+
+const protocolVersion = 1
+
+const (
+	frameMessage  = "message"
+	frameSystem   = "system"
+	frameEdit     = "edit"
+	frameDelete   = "delete"
+	frameReaction = "reaction"
+	frameMention  = "mention"
+	frameHistory  = "history"
+	frameError    = "error"
+	frameGap      = "gap"
+)
+
+type Envelope struct {
+	Version int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
 
 type Message struct {
-	Content     string    `json:"content"`
-	RoomID      string    `json:"roomId"`
-	Username    string    `json:"username"`
-	UserID      string    `json:"user_id"`
-	TimeCreated time.Time `json:"time_created"`
+	ID          string       `json:"id"`
+	Seq         int64        `json:"seq"`
+	Kind        string       `json:"kind"`
+	Event       string       `json:"event,omitempty"`
+	Content     string       `json:"content"`
+	RoomID      string       `json:"room_id"`
+	Username    string       `json:"nickname"`
+	UserID      string       `json:"user_id"`
+	TimeCreated time.Time    `json:"time_created"`
+	EditedAt    *time.Time   `json:"edited_at,omitempty"`
+	DeletedAt   *time.Time   `json:"deleted_at,omitempty"`
+	ReplyTo     string       `json:"reply_to,omitempty"`
+	ReplyCount  int          `json:"reply_count,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+const (
+	kindSystem   = "system"
+	memberJoined = "member.joined"
+)
+
+type Attachment struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	URL  string `json:"url"`
+}
+
+type SendMessagePayload struct {
+	Content string `json:"content"`
+}
+
+type HistoryPayload struct {
+	Messages []Message `json:"messages"`
+	HasMore  bool      `json:"has_more"`
+}
+
+type GapPayload struct {
+	Dropped int `json:"dropped"`
+}
+
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
 type RoomResp struct {
@@ -16,6 +79,12 @@ type RoomResp struct {
 	Name        string    `json:"name"`
 }
 
+type RoomsPageResp struct {
+	Rooms      []RoomResp `json:"rooms"`
+	NextCursor string     `json:"next_cursor"`
+	HasMore    bool       `json:"has_more"`
+}
+
 type tokenResponse struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
@@ -25,3 +94,10 @@ type AuthReq struct {
 	Nickname string `json:"nickname"`
 	Password string `json:"password"`
 }
+
+type ReactionPayload struct {
+	MessageID string `json:"message_id"`
+	Emoji     string `json:"emoji"`
+	Action    string `json:"action"`
+	UserID    string `json:"user_id,omitempty"`
+}
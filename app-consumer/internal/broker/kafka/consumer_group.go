@@ -54,7 +54,7 @@ func pingKafka(brokerList []string, topic string) error {
 	return nil
 }
 
-func (cg *ConsumerGroup) Consume(ctx context.Context, handler domain.MessageHandler) error {
+func (cg *ConsumerGroup) Consume(ctx context.Context, handler domain.EventHandler) error {
 	consumer := NewConsumer(handler)
 	res := make(chan error)
 
@@ -4,6 +4,7 @@ import (
 	"app-consumer/internal/broker/kafka"
 	"app-consumer/internal/config"
 	"app-consumer/internal/services/worker"
+	"app-consumer/internal/storage/blob"
 	"app-consumer/internal/storage/pg"
 	"app-consumer/internal/storage/redis"
 	"app-consumer/pkg/logger/slogpretty"
@@ -40,7 +41,12 @@ func InitComponents(cfg *config.Config, logger *slog.Logger) (*Components, error
 		return nil, err
 	}
 
-	workerService := worker.New(logger, kafkaConsumerGroup, postgres, rds)
+	blobs, err := blob.New(&cfg.Blob)
+	if err != nil {
+		return nil, err
+	}
+
+	workerService := worker.New(logger, kafkaConsumerGroup, postgres, rds, blobs)
 
 	return &Components{
 		Postgres:           postgres,
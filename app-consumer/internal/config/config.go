@@ -6,6 +6,7 @@ import (
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/joho/godotenv"
 	"os"
+	"time"
 )
 
 type Config struct {
@@ -13,6 +14,7 @@ type Config struct {
 	Postgres PostgresConfig
 	Redis    RedisConfig
 	Kafka    KafkaConfig
+	Blob     BlobConfig
 }
 
 type PostgresConfig struct {
@@ -22,6 +24,11 @@ type PostgresConfig struct {
 type RedisConfig struct {
 	Addrs    []string `yaml:"addrs" env-required:"true"`
 	Password string   `env:"REDIS_PASSWORD" env-required:"true"`
+	// ListMaxLen is how many latest messages of a room are kept in the cached history list
+	ListMaxLen int64 `yaml:"list_max_len" env-default:"1000"`
+	// CachedMarkTTL is how long the list remembers that a message was added to it, so an event
+	// handled again within it does not cache the message twice
+	CachedMarkTTL time.Duration `yaml:"cached_mark_ttl" env-default:"24h"`
 }
 
 type KafkaConfig struct {
@@ -30,6 +37,23 @@ type KafkaConfig struct {
 	ConsumerGroup string   `yaml:"consumer_group" env-required:"true"`
 }
 
+// BlobConfig chooses where uploaded files are kept, the same way app-websocket does:
+// the local driver keeps them in Dir, shared with app-websocket, the s3 driver in a bucket.
+type BlobConfig struct {
+	Driver string   `yaml:"driver" env-default:"local"`
+	Dir    string   `yaml:"dir" env-default:"/var/lib/rooms/blobs"`
+	S3     S3Config `yaml:"s3"`
+}
+
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region" env-default:"us-east-1"`
+	Bucket    string `yaml:"bucket"`
+	PathStyle bool   `yaml:"path_style" env-default:"true"` // bucket in the path rather than in the host name, as MinIO expects
+	AccessKey string `env:"S3_ACCESS_KEY"`
+	SecretKey string `env:"S3_SECRET_KEY"`
+}
+
 func LoadConfig() (*Config, error) {
 	envPath, configPath := fetchPaths()
 
@@ -2,12 +2,80 @@ package domain
 
 import "time"
 
+// MessageKind tells messages users write from the ones the server writes about the room.
+type MessageKind string
+
+const (
+	MessageKindUser   MessageKind = "user"
+	MessageKindSystem MessageKind = "system"
+)
+
+// SystemEvent is what a system message tells about.
+type SystemEvent string
+
 type Message struct {
+	ID          string
+	Seq         int64
+	Kind        MessageKind
+	Event       SystemEvent // set on system messages, which have no content
 	Content     string
 	Nickname    string
 	TimeCreated time.Time
 	RoomID      string
 	UserID      string
+	EditedAt    *time.Time
+	DeletedBy   string
+	DeletedAt   *time.Time
+	ReplyTo     string         // message this one answers
+	ThreadID    string         // root message of the thread, empty for root messages
+	ReplyCount  int            // replies in the thread, kept on the root message
+	LastReplyAt *time.Time     // time of the latest reply, kept on the root message
+	Reactions   map[string]int // number of users per emoji
+	Mentions    []string       // IDs of the users mentioned with @nickname
+	PinnedBy    string         // moderator who pinned the message to the top of the room
+	PinnedAt    *time.Time     // nil for messages that are not pinned
+	Attachments []Attachment   // files sent with the message
+}
+
+// IsSystem reports whether the server wrote the message about the room.
+func (m *Message) IsSystem() bool {
+	return m.Kind == MessageKindSystem
+}
+
+// Attachment is a file uploaded to a room and sent with a message. The content lives in
+// the blob store under BlobKey, the thumbnail of an image under ThumbnailKey.
+type Attachment struct {
+	ID           string
+	RoomID       string
+	MessageID    string
+	UploadedBy   string
+	Name         string
+	ContentType  string
+	Size         int64
+	BlobKey      string
+	ThumbnailKey string
+	Width        int // size of the image in pixels, zero for other files
+	Height       int
+	TimeCreated  time.Time
+}
+
+// Reaction is an emoji put on a message by a user; a user puts each emoji once.
+type Reaction struct {
+	MessageID   string
+	UserID      string
+	Emoji       string
+	TimeCreated time.Time
+	ThreadID    string // thread of the message, lets thread followers receive the change
+}
+
+// ReadReceipt is the last message of the room the user has read.
+type ReadReceipt struct {
+	RoomID    string
+	UserID    string
+	Nickname  string
+	MessageID string
+	Seq       int64
+	TimeRead  time.Time
 }
 
 type User struct {
@@ -32,4 +100,28 @@ type Room struct {
 	TimeCreated time.Time
 }
 
-type MessageHandler func(msg Message) error
+type EventType string
+
+const (
+	EventMessageCreated  EventType = "message.created"
+	EventMessageEdited   EventType = "message.edited"
+	EventMessageDeleted  EventType = "message.deleted"
+	EventMessagePinned   EventType = "message.pinned"
+	EventMessageUnpinned EventType = "message.unpinned"
+	EventReactionAdded   EventType = "reaction.added"
+	EventReactionRemoved EventType = "reaction.removed"
+	EventReadUpdated     EventType = "read.updated"
+	EventRoomDeleted     EventType = "room.deleted"
+)
+
+// Event is what travels through the broker. Message is set for message events,
+// Reaction for reaction events and Read for read events. Room events need only RoomID here.
+type Event struct {
+	Type     EventType
+	RoomID   string
+	Message  *Message
+	Reaction *Reaction
+	Read     *ReadReceipt
+}
+
+type EventHandler func(event Event) error
@@ -7,4 +7,6 @@ var (
 	ErrNicknameAlreadyExist = errors.New("nickname already exist")
 	ErrUserNotFound         = errors.New("user not found by refresh token")
 	ErrRoomNotFound         = errors.New("room not found")
+	ErrMessageNotFound      = errors.New("message not found")
+	ErrAttachmentNotFound   = errors.New("attachment not found")
 )
@@ -0,0 +1,106 @@
+package worker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+const (
+	thumbnailSize    = 320        // longest side of a thumbnail in pixels
+	thumbnailQuality = 80         // JPEG quality of thumbnails
+	maxImagePixels   = 25_000_000 // larger images are not decoded, they would take too much memory
+)
+
+// thumbnailTypes are the image types the standard library decodes.
+var thumbnailTypes = map[string]struct{}{
+	"image/png":  {},
+	"image/jpeg": {},
+	"image/gif":  {},
+}
+
+var errImageTooLarge = errors.New("image is too large to decode")
+
+// makeThumbnail decodes the image and returns its size and a JPEG thumbnail of it that fits into
+// thumbnailSize. Smaller images keep their size; transparent parts are put on white.
+func makeThumbnail(r io.Reader) ([]byte, int, int, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(buf))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxImagePixels {
+		return nil, 0, 0, fmt.Errorf("%w: %dx%d", errImageTooLarge, config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	var out bytes.Buffer
+	err = jpeg.Encode(&out, downscale(img, thumbnailSize), &jpeg.Options{Quality: thumbnailQuality})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	return out.Bytes(), config.Width, config.Height, nil
+}
+
+// downscale shrinks the image to fit into a square of the given side, averaging the pixels
+// each thumbnail pixel covers.
+func downscale(img image.Image, side int) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	dstWidth, dstHeight := width, height
+	if width > side || height > side {
+		if width >= height {
+			dstWidth, dstHeight = side, max(1, height*side/width)
+		} else {
+			dstWidth, dstHeight = max(1, width*side/height), side
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		y0 := bounds.Min.Y + y*height/dstHeight
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*height/dstHeight)
+
+		for x := 0; x < dstWidth; x++ {
+			x0 := bounds.Min.X + x*width/dstWidth
+			x1 := max(x0+1, bounds.Min.X+(x+1)*width/dstWidth)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			// the colors are premultiplied, so white shows through in proportion to the transparency
+			white := 0xffff*n - a
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r + white) / n >> 8),
+				G: uint8((g + white) / n >> 8),
+				B: uint8((b + white) / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+
+	return dst
+}
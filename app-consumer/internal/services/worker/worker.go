@@ -2,24 +2,51 @@ package worker
 
 import (
 	"app-consumer/internal/domain"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
 	"math"
 	"math/rand"
 	"time"
 )
 
 type PersistentStorage interface {
-	PushMessage(ctx context.Context, msg *domain.Message) error
+	PushMessage(ctx context.Context, msg *domain.Message) (bool, error)
+	IsStored(ctx context.Context, msg *domain.Message) (bool, error)
+	GetReplyStats(ctx context.Context, rootID string) (int, *time.Time, error)
+	EditMessage(ctx context.Context, msg *domain.Message) (bool, error)
+	DeleteMessage(ctx context.Context, msg *domain.Message) (bool, error)
+	PinMessage(ctx context.Context, msg *domain.Message) (bool, error)
+	UnpinMessage(ctx context.Context, msg *domain.Message) (bool, error)
+	AddReaction(ctx context.Context, reaction *domain.Reaction) (bool, error)
+	RemoveReaction(ctx context.Context, reaction *domain.Reaction) (bool, error)
+	GetReactionCounts(ctx context.Context, messageID string) (map[string]int, error)
+	SaveReadReceipt(ctx context.Context, receipt *domain.ReadReceipt) (bool, error)
+	DeleteAttachments(ctx context.Context, messageID string) ([]string, error)
+	SetThumbnail(ctx context.Context, attachment *domain.Attachment) error
+	PurgeRoom(ctx context.Context, roomID string) ([]string, error)
 }
 
 type CacheStorage interface {
 	AddToList(ctx context.Context, msg *domain.Message) error
+	UpdateInList(ctx context.Context, roomID, messageID string, update func(msg *domain.Message) bool) error
+	SetReadReceipt(ctx context.Context, receipt *domain.ReadReceipt) error
+	DeleteRoom(ctx context.Context, roomID string) error
+}
+
+// BlobStorage keeps the files of attachments, shared with app-websocket.
+type BlobStorage interface {
+	Put(ctx context.Context, key, contentType string, r io.Reader, size int64) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
 }
 
 type Consumer interface {
-	Consume(ctx context.Context, handler domain.MessageHandler) error
+	Consume(ctx context.Context, handler domain.EventHandler) error
 }
 
 type Worker struct {
@@ -27,14 +54,16 @@ type Worker struct {
 	consumer          Consumer
 	persistentStorage PersistentStorage
 	cache             CacheStorage
+	blobs             BlobStorage
 }
 
-func New(logger *slog.Logger, consumer Consumer, persistentStorage PersistentStorage, cache CacheStorage) *Worker {
+func New(logger *slog.Logger, consumer Consumer, persistentStorage PersistentStorage, cache CacheStorage, blobs BlobStorage) *Worker {
 	return &Worker{
 		logger:            logger,
 		consumer:          consumer,
 		persistentStorage: persistentStorage,
 		cache:             cache,
+		blobs:             blobs,
 	}
 }
 
@@ -45,15 +74,10 @@ func (w *Worker) Run(ctx context.Context) error {
 		w.logger.Info("Worker is started")
 
 		for {
-			err := w.consumer.Consume(ctx, func(msg domain.Message) error {
-				w.logger.Info("Consume message", slog.Any("msg", msg))
-
-				err := w.persistentStorage.PushMessage(ctx, &msg)
-				if err != nil {
-					return fmt.Errorf("services.worker.Run: %w", err)
-				}
+			err := w.consumer.Consume(ctx, func(event domain.Event) error {
+				w.logger.Info("Consume event", slog.Any("event", event))
 
-				err = w.cache.AddToList(ctx, &msg)
+				err := w.handleEvent(ctx, &event)
 				if err != nil {
 					return fmt.Errorf("services.worker.Run: %w", err)
 				}
@@ -74,6 +98,322 @@ func (w *Worker) Run(ctx context.Context) error {
 	return ctx.Err()
 }
 
+func (w *Worker) handleEvent(ctx context.Context, event *domain.Event) error {
+	switch event.Type {
+	case domain.EventMessageCreated:
+		return w.saveMessage(ctx, event.Message)
+	case domain.EventMessageEdited:
+		return w.editMessage(ctx, event.Message)
+	case domain.EventMessageDeleted:
+		return w.deleteMessage(ctx, event.Message)
+	case domain.EventMessagePinned, domain.EventMessageUnpinned:
+		return w.changePin(ctx, event)
+	case domain.EventReactionAdded, domain.EventReactionRemoved:
+		return w.changeReaction(ctx, event)
+	case domain.EventReadUpdated:
+		return w.saveReadReceipt(ctx, event.Read)
+	case domain.EventRoomDeleted:
+		return w.purgeRoom(ctx, event.RoomID)
+	default:
+		w.logger.Debug("Skip event of unknown type", slog.String("type", string(event.Type)))
+		return nil
+	}
+}
+
+func (w *Worker) saveMessage(ctx context.Context, msg *domain.Message) error {
+	inserted, err := w.persistentStorage.PushMessage(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	if !inserted {
+		stored, err := w.persistentStorage.IsStored(ctx, msg)
+		if err != nil {
+			return err
+		}
+
+		if !stored {
+			w.logger.Info("Skip message of deleted room", slog.String("id", msg.ID))
+			return nil
+		}
+
+		// an earlier attempt stored the message but may have failed before caching it, so the rest is repeated
+		w.logger.Info("Message is stored already, caching it again", slog.String("id", msg.ID))
+	}
+
+	err = w.cache.AddToList(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	if msg.ThreadID != "" {
+		// the cached root takes the counters Postgres keeps, so a repeated event does not count twice
+		count, lastReplyAt, err := w.persistentStorage.GetReplyStats(ctx, msg.ThreadID)
+		if err != nil {
+			return err
+		}
+
+		err = w.cache.UpdateInList(ctx, msg.RoomID, msg.ThreadID, func(root *domain.Message) bool {
+			root.ReplyCount = count
+			root.LastReplyAt = lastReplyAt
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range msg.Attachments {
+		if _, ok := thumbnailTypes[msg.Attachments[i].ContentType]; ok {
+			err = w.saveThumbnail(ctx, msg, &msg.Attachments[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// saveThumbnail makes the thumbnail of an image attachment. An image that can not be decoded
+// is left without a thumbnail: clients show the image itself then.
+func (w *Worker) saveThumbnail(ctx context.Context, msg *domain.Message, attachment *domain.Attachment) error {
+	content, err := w.blobs.Get(ctx, attachment.BlobKey)
+	if err != nil {
+		if errors.Is(err, domain.ErrAttachmentNotFound) {
+			w.logger.Info("Skip thumbnail of missing file", slog.String("id", attachment.ID))
+			return nil
+		}
+
+		return err
+	}
+	defer content.Close()
+
+	thumbnail, width, height, err := makeThumbnail(content)
+	if err != nil {
+		w.logger.Info("Skip thumbnail of undecodable image", slog.String("id", attachment.ID), slog.String("error", err.Error()))
+		return nil
+	}
+
+	attachment.ThumbnailKey = attachment.BlobKey + ".thumb.jpg"
+	attachment.Width = width
+	attachment.Height = height
+
+	err = w.blobs.Put(ctx, attachment.ThumbnailKey, "image/jpeg", bytes.NewReader(thumbnail), int64(len(thumbnail)))
+	if err != nil {
+		return err
+	}
+
+	err = w.persistentStorage.SetThumbnail(ctx, attachment)
+	if err != nil {
+		return err
+	}
+
+	return w.cache.UpdateInList(ctx, msg.RoomID, msg.ID, func(cached *domain.Message) bool {
+		for i := range cached.Attachments {
+			if cached.Attachments[i].ID == attachment.ID {
+				cached.Attachments[i].ThumbnailKey = attachment.ThumbnailKey
+				cached.Attachments[i].Width = width
+				cached.Attachments[i].Height = height
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+// editMessage applies an edit unless a newer one was applied already,
+// which keeps redelivered or reordered edit events harmless.
+func (w *Worker) editMessage(ctx context.Context, msg *domain.Message) error {
+	if msg.EditedAt == nil {
+		w.logger.Info("Skip edit without time", slog.String("id", msg.ID))
+		return nil
+	}
+
+	applied, err := w.persistentStorage.EditMessage(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	if !applied {
+		w.logger.Info("Skip outdated edit", slog.String("id", msg.ID))
+	}
+
+	return w.cache.UpdateInList(ctx, msg.RoomID, msg.ID, func(cached *domain.Message) bool {
+		if cached.DeletedAt != nil || cached.EditedAt != nil && !cached.EditedAt.Before(*msg.EditedAt) {
+			return false
+		}
+
+		cached.Content = msg.Content
+		cached.EditedAt = msg.EditedAt
+		return true
+	})
+}
+
+// deleteMessage tombstones the message; deleting twice is a no-op.
+func (w *Worker) deleteMessage(ctx context.Context, msg *domain.Message) error {
+	if msg.DeletedAt == nil {
+		w.logger.Info("Skip delete without time", slog.String("id", msg.ID))
+		return nil
+	}
+
+	applied, err := w.persistentStorage.DeleteMessage(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	if !applied {
+		w.logger.Info("Skip repeated delete", slog.String("id", msg.ID))
+	}
+
+	// a repeated delete still removes the files a failed attempt may have left
+	keys, err := w.persistentStorage.DeleteAttachments(ctx, msg.ID)
+	if err != nil {
+		return err
+	}
+
+	w.deleteBlobs(ctx, keys)
+
+	return w.cache.UpdateInList(ctx, msg.RoomID, msg.ID, func(cached *domain.Message) bool {
+		if cached.DeletedAt != nil {
+			return false
+		}
+
+		cached.Content = ""
+		cached.DeletedBy = msg.DeletedBy
+		cached.DeletedAt = msg.DeletedAt
+		cached.Reactions = nil
+		cached.Mentions = nil
+		cached.PinnedBy = ""
+		cached.PinnedAt = nil
+		cached.Attachments = nil
+		return true
+	})
+}
+
+// changePin pins or unpins the message; repeating either is a no-op.
+func (w *Worker) changePin(ctx context.Context, event *domain.Event) error {
+	msg := event.Message
+	if msg == nil || event.Type == domain.EventMessagePinned && msg.PinnedAt == nil {
+		w.logger.Info("Skip pin event without pin", slog.String("type", string(event.Type)))
+		return nil
+	}
+
+	var applied bool
+	var err error
+	if event.Type == domain.EventMessagePinned {
+		applied, err = w.persistentStorage.PinMessage(ctx, msg)
+	} else {
+		applied, err = w.persistentStorage.UnpinMessage(ctx, msg)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !applied {
+		w.logger.Info("Skip repeated pin change", slog.String("id", msg.ID))
+	}
+
+	return w.cache.UpdateInList(ctx, msg.RoomID, msg.ID, func(cached *domain.Message) bool {
+		if cached.DeletedAt != nil || (cached.PinnedAt != nil) == (msg.PinnedAt != nil) {
+			return false
+		}
+
+		cached.PinnedBy = msg.PinnedBy
+		cached.PinnedAt = msg.PinnedAt
+		return true
+	})
+}
+
+// changeReaction applies the reaction and copies the resulting counts to the cache.
+// Counts are recalculated rather than incremented, so a redelivered event also repairs
+// a cache update that failed the first time.
+func (w *Worker) changeReaction(ctx context.Context, event *domain.Event) error {
+	reaction := event.Reaction
+	if reaction == nil {
+		w.logger.Info("Skip reaction event without reaction", slog.String("type", string(event.Type)))
+		return nil
+	}
+
+	var applied bool
+	var err error
+	if event.Type == domain.EventReactionAdded {
+		applied, err = w.persistentStorage.AddReaction(ctx, reaction)
+	} else {
+		applied, err = w.persistentStorage.RemoveReaction(ctx, reaction)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !applied {
+		w.logger.Info("Skip repeated reaction change", slog.String("id", reaction.MessageID), slog.String("emoji", reaction.Emoji))
+	}
+
+	counts, err := w.persistentStorage.GetReactionCounts(ctx, reaction.MessageID)
+	if err != nil {
+		return err
+	}
+
+	return w.cache.UpdateInList(ctx, event.RoomID, reaction.MessageID, func(cached *domain.Message) bool {
+		if cached.DeletedAt != nil || maps.Equal(cached.Reactions, counts) {
+			return false
+		}
+
+		cached.Reactions = counts
+		if len(counts) == 0 {
+			cached.Reactions = nil
+		}
+
+		return true
+	})
+}
+
+// saveReadReceipt moves the read position forward; outdated positions are ignored
+// by both storages, so the order of read events does not matter.
+func (w *Worker) saveReadReceipt(ctx context.Context, receipt *domain.ReadReceipt) error {
+	if receipt == nil {
+		w.logger.Info("Skip read event without receipt")
+		return nil
+	}
+
+	moved, err := w.persistentStorage.SaveReadReceipt(ctx, receipt)
+	if err != nil {
+		return err
+	}
+
+	if !moved {
+		w.logger.Info("Skip outdated read receipt", slog.String("room", receipt.RoomID), slog.String("user", receipt.UserID))
+	}
+
+	return w.cache.SetReadReceipt(ctx, receipt)
+}
+
+// purgeRoom removes the history of a deleted room. The room itself stays marked as deleted,
+// so messages of the room still in the broker are dropped instead of stored.
+func (w *Worker) purgeRoom(ctx context.Context, roomID string) error {
+	keys, err := w.persistentStorage.PurgeRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+
+	w.deleteBlobs(ctx, keys)
+
+	return w.cache.DeleteRoom(ctx, roomID)
+}
+
+// deleteBlobs removes files whose attachments are gone. A file that fails to be removed
+// is only logged: nothing refers to it any more.
+func (w *Worker) deleteBlobs(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		err := w.blobs.Delete(ctx, key)
+		if err != nil {
+			w.logger.Error("failed to delete file", slog.String("key", key), slog.String("error", err.Error()))
+		}
+	}
+}
+
 func expBackoff(attempt int) time.Duration {
 	maxDelay := 30 * time.Second
 	backoff := math.Pow(2, float64(attempt))
@@ -3,8 +3,12 @@ package pg
 import (
 	"app-consumer/internal/domain"
 	"context"
+	"errors"
 	"fmt"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"slices"
+	"time"
 )
 
 type Postgres struct {
@@ -36,11 +40,420 @@ func (pg *Postgres) CloseConnection() {
 	pg.pool.Close()
 }
 
-func (pg *Postgres) PushMessage(ctx context.Context, msg *domain.Message) error {
-	_, err := pg.pool.Exec(ctx, "INSERT INTO messages(user_id, content, room_id, time_created) VALUES ($1, $2, $3, $4)", msg.UserID, msg.Content, msg.RoomID, msg.TimeCreated)
+// PushMessage stores the message and reports whether it was new.
+// Redelivered messages are recognised by their ID and left untouched.
+// A new reply also bumps the reply counter of its thread root, and mentions are stored with the message.
+// Messages of deleted rooms are dropped and reported as not new. The room keeps the time
+// of its latest message for the room list. The words of the message are indexed for search
+// with the 'simple' configuration: messages mix languages, so words are kept without stemming.
+// Attachments are given to the message unless another message of the author took them first;
+// those are dropped from msg.Attachments. System messages have no words to index and leave
+// the time of the latest message alone.
+func (pg *Postgres) PushMessage(ctx context.Context, msg *domain.Message) (bool, error) {
+	kind := msg.Kind
+	if kind == "" {
+		kind = domain.MessageKindUser
+	}
+
+	tx, err := pg.pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.PushMessage: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO messages(message_id, seq, kind, system_event, user_id, content, room_id, time_created, reply_to, thread_id, search_vector)
+			SELECT $1, $2, $3, NULLIF($4, ''), $5, $6::text, $7, $8, NULLIF($9, ''), NULLIF($10, ''),
+				CASE WHEN $3 = 'user' THEN to_tsvector('simple', $6::text) END
+			WHERE EXISTS (SELECT 1 FROM rooms WHERE id = $7 AND deleted_at IS NULL)
+			ON CONFLICT (message_id) DO NOTHING`,
+		msg.ID, msg.Seq, kind, msg.Event, msg.UserID, msg.Content, msg.RoomID, msg.TimeCreated, msg.ReplyTo, msg.ThreadID)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.PushMessage: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if !msg.IsSystem() {
+		_, err = tx.Exec(ctx, "UPDATE rooms SET last_message_at = GREATEST(last_message_at, $1) WHERE id = $2", msg.TimeCreated, msg.RoomID)
+		if err != nil {
+			return false, fmt.Errorf("storage.pg.PushMessage: %w", err)
+		}
+	}
+
+	if msg.ThreadID != "" {
+		_, err = tx.Exec(ctx,
+			`UPDATE messages SET reply_count = reply_count + 1, last_reply_at = GREATEST(last_reply_at, $1)
+				WHERE message_id = $2`, msg.TimeCreated, msg.ThreadID)
+		if err != nil {
+			return false, fmt.Errorf("storage.pg.PushMessage: %w", err)
+		}
+	}
+
+	if len(msg.Mentions) > 0 {
+		_, err = tx.Exec(ctx,
+			`INSERT INTO message_mentions(message_id, user_id)
+				SELECT $1, user_id::integer FROM unnest($2::text[]) AS user_id
+				ON CONFLICT DO NOTHING`, msg.ID, msg.Mentions)
+		if err != nil {
+			return false, fmt.Errorf("storage.pg.PushMessage: %w", err)
+		}
+	}
+
+	if len(msg.Attachments) > 0 {
+		ids := make([]string, 0, len(msg.Attachments))
+		for i := range msg.Attachments {
+			ids = append(ids, msg.Attachments[i].ID)
+		}
+
+		rows, err := tx.Query(ctx,
+			`UPDATE attachments SET message_id = $1
+				WHERE id = ANY($2) AND room_id = $3 AND uploaded_by = $4 AND message_id IS NULL
+				RETURNING id`, msg.ID, ids, msg.RoomID, msg.UserID)
+		if err != nil {
+			return false, fmt.Errorf("storage.pg.PushMessage: %w", err)
+		}
+
+		linked, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return false, fmt.Errorf("storage.pg.PushMessage: %w", err)
+		}
+
+		msg.Attachments = slices.DeleteFunc(msg.Attachments, func(attachment domain.Attachment) bool {
+			return !slices.Contains(linked, attachment.ID)
+		})
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.PushMessage: %w", err)
+	}
+
+	return true, nil
+}
+
+// IsStored reports whether a message PushMessage did not report as new is stored in a room that
+// is not deleted. Like PushMessage it drops from msg.Attachments the ones not given to the message.
+func (pg *Postgres) IsStored(ctx context.Context, msg *domain.Message) (bool, error) {
+	var stored bool
+	err := pg.pool.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM messages m JOIN rooms r ON r.id = m.room_id
+			WHERE m.message_id = $1 AND r.deleted_at IS NULL)`, msg.ID).Scan(&stored)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.IsStored: %w", err)
+	}
+
+	if !stored || len(msg.Attachments) == 0 {
+		return stored, nil
+	}
+
+	rows, err := pg.pool.Query(ctx, "SELECT id FROM attachments WHERE message_id = $1", msg.ID)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.IsStored: %w", err)
+	}
+
+	linked, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.IsStored: %w", err)
+	}
+
+	msg.Attachments = slices.DeleteFunc(msg.Attachments, func(attachment domain.Attachment) bool {
+		return !slices.Contains(linked, attachment.ID)
+	})
+
+	return true, nil
+}
+
+// GetReplyStats returns the number of replies in the thread and the time of the latest one.
+func (pg *Postgres) GetReplyStats(ctx context.Context, rootID string) (int, *time.Time, error) {
+	var count int
+	var lastReplyAt *time.Time
+	err := pg.pool.QueryRow(ctx,
+		"SELECT reply_count, last_reply_at FROM messages WHERE message_id = $1", rootID).Scan(&count, &lastReplyAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil, nil
+		}
+
+		return 0, nil, fmt.Errorf("storage.pg.GetReplyStats: %w", err)
+	}
+
+	return count, lastReplyAt, nil
+}
+
+// EditMessage stores the new content and keeps the replaced version in message_edits.
+// It reports false when the message is unknown or already has a newer edit.
+func (pg *Postgres) EditMessage(ctx context.Context, msg *domain.Message) (bool, error) {
+	tx, err := pg.pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.EditMessage: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var content string
+	var versionCreated time.Time
+	err = tx.QueryRow(ctx,
+		`SELECT content, COALESCE(edited_at, time_created) FROM messages
+			WHERE message_id = $1 AND deleted_at IS NULL AND (edited_at IS NULL OR edited_at < $2)
+			FOR UPDATE`, msg.ID, msg.EditedAt).Scan(&content, &versionCreated)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("storage.pg.EditMessage: %w", err)
+	}
+
+	_, err = tx.Exec(ctx,
+		"INSERT INTO message_edits(message_id, content, version_created, replaced_at) VALUES ($1, $2, $3, $4)",
+		msg.ID, content, versionCreated, msg.EditedAt)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.EditMessage: %w", err)
+	}
+
+	_, err = tx.Exec(ctx,
+		"UPDATE messages SET content = $1::text, edited_at = $2, search_vector = to_tsvector('simple', $1::text) WHERE message_id = $3",
+		msg.Content, msg.EditedAt, msg.ID)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.EditMessage: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.EditMessage: %w", err)
+	}
+
+	return true, nil
+}
+
+// DeleteMessage clears the content of the message together with its edit history.
+// It reports false when the message is unknown or already deleted.
+func (pg *Postgres) DeleteMessage(ctx context.Context, msg *domain.Message) (bool, error) {
+	tx, err := pg.pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.DeleteMessage: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE messages SET content = '', search_vector = NULL, pinned_by = NULL, pinned_at = NULL, deleted_by = $1, deleted_at = $2
+			WHERE message_id = $3 AND deleted_at IS NULL`,
+		msg.DeletedBy, msg.DeletedAt, msg.ID)
 	if err != nil {
-		return fmt.Errorf("storage.pg.PushMessage: %w", err)
+		return false, fmt.Errorf("storage.pg.DeleteMessage: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM message_edits WHERE message_id = $1", msg.ID)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.DeleteMessage: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM message_reactions WHERE message_id = $1", msg.ID)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.DeleteMessage: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM message_mentions WHERE message_id = $1", msg.ID)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.DeleteMessage: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.DeleteMessage: %w", err)
+	}
+
+	return true, nil
+}
+
+// DeleteAttachments removes the attachments of the message and returns the blob keys
+// of their files and thumbnails, which are to be removed from the blob store.
+func (pg *Postgres) DeleteAttachments(ctx context.Context, messageID string) ([]string, error) {
+	rows, err := pg.pool.Query(ctx,
+		"DELETE FROM attachments WHERE message_id = $1 RETURNING blob_key, COALESCE(thumbnail_key, '')", messageID)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.DeleteAttachments: %w", err)
+	}
+
+	keys, err := collectBlobKeys(rows)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.DeleteAttachments: %w", err)
+	}
+
+	return keys, nil
+}
+
+// SetThumbnail remembers the thumbnail of an image attachment and the size of the image.
+func (pg *Postgres) SetThumbnail(ctx context.Context, attachment *domain.Attachment) error {
+	_, err := pg.pool.Exec(ctx,
+		"UPDATE attachments SET thumbnail_key = $1, width = $2, height = $3 WHERE id = $4",
+		attachment.ThumbnailKey, attachment.Width, attachment.Height, attachment.ID)
+	if err != nil {
+		return fmt.Errorf("storage.pg.SetThumbnail: %w", err)
 	}
 
 	return nil
 }
+
+func collectBlobKeys(rows pgx.Rows) ([]string, error) {
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var blobKey, thumbnailKey string
+		err := rows.Scan(&blobKey, &thumbnailKey)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, blobKey)
+		if thumbnailKey != "" {
+			keys = append(keys, thumbnailKey)
+		}
+	}
+
+	return keys, rows.Err()
+}
+
+// PinMessage pins the message unless it is pinned already or deleted, and reports whether it was pinned.
+func (pg *Postgres) PinMessage(ctx context.Context, msg *domain.Message) (bool, error) {
+	tag, err := pg.pool.Exec(ctx,
+		`UPDATE messages SET pinned_by = $1, pinned_at = $2
+			WHERE message_id = $3 AND pinned_at IS NULL AND deleted_at IS NULL`,
+		msg.PinnedBy, msg.PinnedAt, msg.ID)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.PinMessage: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// UnpinMessage unpins the message and reports whether it was pinned.
+func (pg *Postgres) UnpinMessage(ctx context.Context, msg *domain.Message) (bool, error) {
+	tag, err := pg.pool.Exec(ctx,
+		"UPDATE messages SET pinned_by = NULL, pinned_at = NULL WHERE message_id = $1 AND pinned_at IS NOT NULL", msg.ID)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.UnpinMessage: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// AddReaction stores the reaction unless the user already put this emoji on the message
+// or the message is deleted, and reports whether it was stored.
+func (pg *Postgres) AddReaction(ctx context.Context, reaction *domain.Reaction) (bool, error) {
+	tag, err := pg.pool.Exec(ctx,
+		`INSERT INTO message_reactions(message_id, user_id, emoji, time_created)
+			SELECT $1, $2, $3, $4 WHERE EXISTS (SELECT 1 FROM messages WHERE message_id = $1 AND deleted_at IS NULL)
+			ON CONFLICT (message_id, user_id, emoji) DO NOTHING`,
+		reaction.MessageID, reaction.UserID, reaction.Emoji, reaction.TimeCreated)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.AddReaction: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// RemoveReaction deletes the reaction and reports whether there was one.
+func (pg *Postgres) RemoveReaction(ctx context.Context, reaction *domain.Reaction) (bool, error) {
+	tag, err := pg.pool.Exec(ctx,
+		"DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3",
+		reaction.MessageID, reaction.UserID, reaction.Emoji)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.RemoveReaction: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetReactionCounts returns the number of users per emoji put on the message.
+func (pg *Postgres) GetReactionCounts(ctx context.Context, messageID string) (map[string]int, error) {
+	rows, err := pg.pool.Query(ctx,
+		"SELECT emoji, COUNT(*) FROM message_reactions WHERE message_id = $1 GROUP BY emoji", messageID)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetReactionCounts: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var emoji string
+		var count int
+		err = rows.Scan(&emoji, &count)
+		if err != nil {
+			return nil, fmt.Errorf("storage.pg.GetReactionCounts: %w", err)
+		}
+
+		counts[emoji] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("storage.pg.GetReactionCounts: %w", err)
+	}
+
+	return counts, nil
+}
+
+// SaveReadReceipt moves the read position of the user forward and reports whether it moved.
+func (pg *Postgres) SaveReadReceipt(ctx context.Context, receipt *domain.ReadReceipt) (bool, error) {
+	tag, err := pg.pool.Exec(ctx,
+		`INSERT INTO room_reads(room_id, user_id, message_id, seq, time_read) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (room_id, user_id) DO UPDATE
+			SET message_id = EXCLUDED.message_id, seq = EXCLUDED.seq, time_read = EXCLUDED.time_read
+			WHERE room_reads.seq < EXCLUDED.seq`,
+		receipt.RoomID, receipt.UserID, receipt.MessageID, receipt.Seq, receipt.TimeRead)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.SaveReadReceipt: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// PurgeRoom removes the messages, attachments, members, invites, sanctions and read positions
+// of the room and returns the blob keys of the attachments. The row of the room is kept, marked as deleted.
+func (pg *Postgres) PurgeRoom(ctx context.Context, roomID string) ([]string, error) {
+	tx, err := pg.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.PurgeRoom: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	rows, err := tx.Query(ctx,
+		"DELETE FROM attachments WHERE room_id = $1 RETURNING blob_key, COALESCE(thumbnail_key, '')", roomID)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.PurgeRoom: %w", err)
+	}
+
+	keys, err := collectBlobKeys(rows)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.PurgeRoom: %w", err)
+	}
+
+	// edits, reactions and mentions go with their messages
+	for _, query := range []string{
+		"DELETE FROM messages WHERE room_id = $1",
+		"DELETE FROM room_reads WHERE room_id = $1",
+		"DELETE FROM room_members WHERE room_id = $1",
+		"DELETE FROM room_invites WHERE room_id = $1",
+		"DELETE FROM room_sanctions WHERE room_id = $1",
+		"DELETE FROM direct_rooms WHERE room_id = $1",
+	} {
+		_, err = tx.Exec(ctx, query, roomID)
+		if err != nil {
+			return nil, fmt.Errorf("storage.pg.PurgeRoom: %w", err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.PurgeRoom: %w", err)
+	}
+
+	return keys, nil
+}
@@ -5,17 +5,25 @@ import (
 	"app-consumer/internal/domain"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/redis/go-redis/v9"
 	"log/slog"
+	"time"
 )
 
 type Redis struct {
-	client redis.UniversalClient
-	logger *slog.Logger
+	client        redis.UniversalClient
+	logger        *slog.Logger
+	listMaxLen    int64
+	cachedMarkTTL time.Duration
 }
 
 func New(config *config.RedisConfig, logger *slog.Logger) (*Redis, error) {
+	if config.CachedMarkTTL < time.Second {
+		return nil, fmt.Errorf("storage.redis.New: cached_mark_ttl must be at least a second")
+	}
+
 	client := redis.NewUniversalClient(&redis.UniversalOptions{
 		Addrs:    config.Addrs,
 		Password: config.Password,
@@ -27,18 +35,129 @@ func New(config *config.RedisConfig, logger *slog.Logger) (*Redis, error) {
 	}
 
 	return &Redis{
-		client: client,
-		logger: logger,
+		client:        client,
+		logger:        logger,
+		listMaxLen:    config.ListMaxLen,
+		cachedMarkTTL: config.CachedMarkTTL,
 	}, nil
 }
 
+// addToList pushes the message to the room list and trims the list, unless the mark of the message
+// shows it was pushed already: KEYS[1] is the room, KEYS[2] the mark, ARGV the message, the length
+// of the list and the life of the mark in seconds.
+var addToList = redis.NewScript(`
+if not redis.call('SET', KEYS[2], 1, 'NX', 'EX', ARGV[3]) then
+	return 0
+end
+
+redis.call('LPUSH', KEYS[1], ARGV[1])
+redis.call('LTRIM', KEYS[1], 0, tonumber(ARGV[2]) - 1)
+return 1
+`)
+
+// AddToList caches the message in the room list. Adding a message that is cached already is a no-op,
+// so an event handled again after a failure is not cached twice.
 func (r *Redis) AddToList(ctx context.Context, msg *domain.Message) error {
 	jsonMsg, err := json.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("storage.redis.AddToList: %w", err)
 	}
 
-	return r.client.LPush(ctx, msg.RoomID, jsonMsg).Err()
+	keys := []string{msg.RoomID, cachedMarkKey(msg.RoomID, msg.ID)}
+	err = addToList.Run(ctx, r.client, keys, jsonMsg, r.listMaxLen, int64(r.cachedMarkTTL.Seconds())).Err()
+	if err != nil {
+		return fmt.Errorf("storage.redis.AddToList: %w", err)
+	}
+
+	return nil
+}
+
+// cachedMarkKey is the mark of a message pushed to the room list. The room in braces puts it
+// in the hash slot of the list, so the script stays on one node of a cluster.
+func cachedMarkKey(roomID, messageID string) string {
+	return "msgcached:{" + roomID + "}:" + messageID
+}
+
+// UpdateInList rewrites the cached copy of a message in the room list.
+// update reports whether it changed the message; messages no longer cached are ignored.
+func (r *Redis) UpdateInList(ctx context.Context, roomID, messageID string, update func(msg *domain.Message) bool) error {
+	jsonMsgs, err := r.client.LRange(ctx, roomID, 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("storage.redis.UpdateInList: %w", err)
+	}
+
+	for i, jsonMsg := range jsonMsgs {
+		var msg domain.Message
+		err = json.Unmarshal([]byte(jsonMsg), &msg)
+		if err != nil {
+			return fmt.Errorf("storage.redis.UpdateInList: %w", err)
+		}
+
+		if msg.ID != messageID {
+			continue
+		}
+
+		if !update(&msg) {
+			return nil
+		}
+
+		updated, err := json.Marshal(&msg)
+		if err != nil {
+			return fmt.Errorf("storage.redis.UpdateInList: %w", err)
+		}
+
+		err = r.client.LSet(ctx, roomID, int64(i), updated).Err()
+		if err != nil {
+			return fmt.Errorf("storage.redis.UpdateInList: %w", err)
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+// SetReadReceipt caches the read position of the user unless a later one is cached already.
+func (r *Redis) SetReadReceipt(ctx context.Context, receipt *domain.ReadReceipt) error {
+	key := "reads:" + receipt.RoomID
+
+	cached, err := r.client.HGet(ctx, key, receipt.UserID).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("storage.redis.SetReadReceipt: %w", err)
+	}
+
+	if err == nil {
+		var current domain.ReadReceipt
+		err = json.Unmarshal([]byte(cached), &current)
+		if err == nil && current.Seq >= receipt.Seq {
+			return nil
+		}
+	}
+
+	jsonReceipt, err := json.Marshal(receipt)
+	if err != nil {
+		return fmt.Errorf("storage.redis.SetReadReceipt: %w", err)
+	}
+
+	err = r.client.HSet(ctx, key, receipt.UserID, jsonReceipt).Err()
+	if err != nil {
+		return fmt.Errorf("storage.redis.SetReadReceipt: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteRoom drops everything cached for the room. Keys are deleted one by one,
+// as they may live on different nodes of a cluster.
+func (r *Redis) DeleteRoom(ctx context.Context, roomID string) error {
+	for _, key := range []string{roomID, "presence:room:" + roomID, "seq:" + roomID, "reads:" + roomID} {
+		err := r.client.Del(ctx, key).Err()
+		if err != nil {
+			return fmt.Errorf("storage.redis.DeleteRoom: %w", err)
+		}
+	}
+
+	return nil
 }
 
 func (r *Redis) Close() {
@@ -37,6 +37,11 @@ func main() {
 		return components.HttpServer.Run(ctx)
 	})
 
+	eg.Go(func() error {
+		components.Presence.Run(ctx)
+		return nil
+	})
+
 	eg.Go(func() error {
 		select {
 		case <-ctx.Done():
@@ -8,10 +8,10 @@ import (
 )
 
 type Consumer struct {
-	handler domain.MessageHandler
+	handler domain.EventHandler
 }
 
-func NewConsumer(handler domain.MessageHandler) *Consumer {
+func NewConsumer(handler domain.EventHandler) *Consumer {
 	return &Consumer{
 		handler: handler,
 	}
@@ -25,13 +25,12 @@ func (c *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim saram
 				return fmt.Errorf("broker.kafka.ConsumeClaim: Messages channel is closed")
 			}
 
-			var domainMsg domain.Message
-			err := json.Unmarshal(msg.Value, &domainMsg)
+			event, err := decodeEvent(msg.Value)
 			if err != nil {
 				return fmt.Errorf("broker.kafka.ConsumeClaim: %w", err)
 			}
 
-			err = c.handler(domainMsg)
+			err = c.handler(event)
 			if err != nil {
 				return fmt.Errorf("broker.kafka.ConsumeClaim: %w", err)
 			}
@@ -52,3 +51,28 @@ func (c *Consumer) Setup(sarama.ConsumerGroupSession) error {
 func (c *Consumer) Cleanup(sarama.ConsumerGroupSession) error {
 	return nil
 }
+
+// decodeEvent also accepts bare messages produced before events were introduced.
+func decodeEvent(value []byte) (domain.Event, error) {
+	var event domain.Event
+	err := json.Unmarshal(value, &event)
+	if err != nil {
+		return domain.Event{}, err
+	}
+
+	if event.Type != "" {
+		return event, nil
+	}
+
+	var msg domain.Message
+	err = json.Unmarshal(value, &msg)
+	if err != nil {
+		return domain.Event{}, err
+	}
+
+	return domain.Event{
+		Type:    domain.EventMessageCreated,
+		RoomID:  msg.RoomID,
+		Message: &msg,
+	}, nil
+}
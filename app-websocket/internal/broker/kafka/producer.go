@@ -48,15 +48,16 @@ func NewProducer(cfg *config.KafkaConfig, logger *slog.Logger) (*KafkaProducer,
 	}, nil
 }
 
-func (kp *KafkaProducer) Produce(msg *domain.Message) error {
-	jsonMsg, err := json.Marshal(msg)
+// Produce publishes the event keyed by its room, so events of one room stay ordered.
+func (kp *KafkaProducer) Produce(event *domain.Event) error {
+	jsonMsg, err := json.Marshal(event)
 	if err != nil {
 		return fmt.Errorf("broker.kafka.Produce: %w", err)
 	}
 
 	kp.client.Input() <- &sarama.ProducerMessage{
 		Topic: kp.topic,
-		Key:   sarama.ByteEncoder(msg.RoomID),
+		Key:   sarama.ByteEncoder(event.RoomID),
 		Value: sarama.ByteEncoder(jsonMsg),
 	}
 
@@ -5,10 +5,14 @@ import (
 	"app-websocket/internal/config"
 	"app-websocket/internal/ports"
 	"app-websocket/internal/ports/ws"
+	"app-websocket/internal/services/attachments"
 	"app-websocket/internal/services/auth"
 	"app-websocket/internal/services/message_cache"
 	"app-websocket/internal/services/message_online"
+	"app-websocket/internal/services/presence"
 	"app-websocket/internal/services/rooms"
+	"app-websocket/internal/services/typing"
+	"app-websocket/internal/storage/blob"
 	"app-websocket/internal/storage/pg"
 	"app-websocket/internal/storage/redis"
 	"app-websocket/pkg/jwt"
@@ -25,6 +29,7 @@ const (
 
 type Components struct {
 	HttpServer         *ports.Server
+	Presence           *presence.PresenceService
 	Postgres           *pg.Postgres
 	Redis              *redis.Redis
 	KafkaProducer      *kafka.KafkaProducer
@@ -42,6 +47,11 @@ func InitComponents(cfg *config.Config, logger *slog.Logger) (*Components, error
 		return nil, err
 	}
 
+	blobs, err := blob.New(&cfg.Blob)
+	if err != nil {
+		return nil, err
+	}
+
 	kafkaProducer, err := kafka.NewProducer(&cfg.Kafka, logger)
 	if err != nil {
 		return nil, err
@@ -52,31 +62,38 @@ func InitComponents(cfg *config.Config, logger *slog.Logger) (*Components, error
 		return nil, err
 	}
 
-	hub := ws.NewHub(kafkaConsumerGroup, logger)
+	hub := ws.NewHub(kafkaConsumerGroup, rds, logger)
 
 	serviceAuth, err := auth.New(&cfg.Auth, postgres)
 	if err != nil {
 		return nil, err
 	}
 
-	roomService := rooms.New(postgres)
+	roomService := rooms.New(postgres, rds, kafkaProducer)
 
 	chatCache := message_cache.New(&cfg.Chat, rds, postgres)
 
-	chatOnline := message_online.New(kafkaProducer, kafkaConsumerGroup, rds, hub)
+	presenceService := presence.New(&cfg.Presence, rds, logger)
+
+	chatOnline := message_online.New(kafkaProducer, kafkaConsumerGroup, presenceService, rds, postgres, rds, postgres, postgres, postgres, hub)
+
+	typingService := typing.New(&cfg.Chat, rds, logger)
+
+	attachmentService := attachments.New(&cfg.Attachments, postgres, blobs)
 
 	tokenManager, err := jwt.NewManager(cfg.Auth.JWTSigningKey)
 	if err != nil {
 		return nil, err
 	}
 
-	httpServer, err := ports.NewServer(&cfg.Http, serviceAuth, chatCache, chatOnline, roomService, logger, tokenManager, hub)
+	httpServer, err := ports.NewServer(&cfg.Http, serviceAuth, chatCache, chatOnline, typingService, roomService, attachmentService, presenceService, logger, tokenManager, hub)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Components{
 		HttpServer:         httpServer,
+		Presence:           presenceService,
 		Postgres:           postgres,
 		Redis:              rds,
 		KafkaProducer:      kafkaProducer,
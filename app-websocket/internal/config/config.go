@@ -11,13 +11,16 @@ import (
 )
 
 type Config struct {
-	Env      string `yaml:"env" env-default:"local"`
-	Auth     AuthConfig
-	Http     HTTPConfig
-	Chat     ChatConfig
-	Postgres PostgresConfig
-	Redis    RedisConfig
-	Kafka    KafkaConfig
+	Env         string `yaml:"env" env-default:"local"`
+	Auth        AuthConfig
+	Http        HTTPConfig
+	Chat        ChatConfig
+	Postgres    PostgresConfig
+	Redis       RedisConfig
+	Kafka       KafkaConfig
+	Blob        BlobConfig
+	Attachments AttachmentsConfig
+	Presence    PresenceConfig
 }
 
 type PostgresConfig struct {
@@ -35,6 +38,28 @@ type KafkaConfig struct {
 	ConsumerGroup string   `yaml:"consumer_group" env-required:"true"`
 }
 
+// BlobConfig chooses where uploaded files are kept. The local driver keeps them in Dir, which
+// has to be shared by all instances of both apps; the s3 driver talks to any S3-compatible storage.
+type BlobConfig struct {
+	Driver string   `yaml:"driver" env-default:"local"`
+	Dir    string   `yaml:"dir" env-default:"/var/lib/rooms/blobs"`
+	S3     S3Config `yaml:"s3"`
+}
+
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region" env-default:"us-east-1"`
+	Bucket    string `yaml:"bucket"`
+	PathStyle bool   `yaml:"path_style" env-default:"true"` // bucket in the path rather than in the host name, as MinIO expects
+	AccessKey string `env:"S3_ACCESS_KEY"`
+	SecretKey string `env:"S3_SECRET_KEY"`
+}
+
+type AttachmentsConfig struct {
+	MaxSize      int64    `yaml:"max_size" env-default:"10485760"` // bytes
+	AllowedTypes []string `yaml:"allowed_types" env-default:"image/png,image/jpeg,image/gif,image/webp,text/plain,application/pdf,application/zip,application/x-gzip"`
+}
+
 type AuthConfig struct {
 	AccessTokenTTL  time.Duration `yaml:"access_token_ttl" env-default:"15m"`
 	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl" env-default:"720h"`
@@ -42,13 +67,29 @@ type AuthConfig struct {
 	JWTSigningKey   string        `env:"JWT_SIGNING_KEY" env-required:"true"`
 }
 
+// HTTPConfig also keeps websockets alive: the server pings every PingInterval and closes a connection
+// that has not answered for PongWait, so PongWait has to be longer than PingInterval. A frame that
+// can not be written within FrameWriteTimeout closes the connection as well.
+// MetricsPort serves the expvar metrics of the instance; it is meant for the internal network only.
 type HTTPConfig struct {
-	Port            string        `yaml:"port" env-default:"8080"`
-	ReadTimeout     time.Duration `yaml:"read_timeout" env-default:"10s"`
-	WriteTimeout    time.Duration `yaml:"write_timeout" env-default:"10s"`
-	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env-default:"10s"`
-	Limiter         Limiter
-	TLS             TLSConfig `yaml:"tls"`
+	Port              string        `yaml:"port" env-default:"8080"`
+	MetricsPort       string        `yaml:"metrics_port" env-default:"9090"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env-default:"10s"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" env-default:"10s"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" env-default:"10s"`
+	PingInterval      time.Duration `yaml:"ping_interval" env-default:"25s"`
+	PongWait          time.Duration `yaml:"pong_wait" env-default:"60s"`
+	FrameWriteTimeout time.Duration `yaml:"frame_write_timeout" env-default:"10s"`
+	Limiter           Limiter
+	TLS               TLSConfig    `yaml:"tls"`
+	Outbox            OutboxConfig `yaml:"outbox"`
+}
+
+// OutboxConfig bounds the frames waiting to be written to a websocket client. A client that
+// falls Size frames behind either loses the oldest ones (drop_oldest) or its connection (disconnect).
+type OutboxConfig struct {
+	Size     int    `yaml:"size" env-default:"256"`
+	Overflow string `yaml:"overflow" env-default:"drop_oldest"`
 }
 
 type TLSConfig struct {
@@ -57,7 +98,18 @@ type TLSConfig struct {
 }
 
 type ChatConfig struct {
-	CountMessagesGet int `yaml:"count_messages_get" env-default:"10"`
+	CountMessagesGet int           `yaml:"count_messages_get" env-default:"10"`
+	ReplayLimit      int           `yaml:"replay_limit" env-default:"1000"`
+	TypingTTL        time.Duration `yaml:"typing_ttl" env-default:"5s"`
+	TypingThrottle   time.Duration `yaml:"typing_throttle" env-default:"2s"`
+}
+
+// PresenceConfig tunes the presence records of connections. A record lives for TTL after the last
+// heartbeat, so TTL has to be a few heartbeat intervals long to survive a slow Redis round trip.
+type PresenceConfig struct {
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval" env-default:"10s"`
+	TTL               time.Duration `yaml:"ttl" env-default:"30s"`
+	AwayAfter         time.Duration `yaml:"away_after" env-default:"5m"` // a connection without frames for this long is away
 }
 
 type Limiter struct {
@@ -1,13 +1,158 @@
 package domain
 
-import "time"
+import (
+	"strings"
+	"time"
+)
+
+// MessageKind tells messages users write from the ones the server writes about the room.
+type MessageKind string
+
+const (
+	MessageKindUser   MessageKind = "user"
+	MessageKindSystem MessageKind = "system"
+)
+
+// SystemEvent is what a system message tells about; UserID and Nickname of the message are
+// the user it is about.
+type SystemEvent string
+
+const (
+	SystemMemberJoined SystemEvent = "member.joined"
+	SystemMemberLeft   SystemEvent = "member.left"
+)
 
 type Message struct {
+	ID          string
+	Seq         int64
+	Kind        MessageKind // empty for messages cached before kinds existed, which are all users'
+	Event       SystemEvent // set on system messages, which have no content
 	Content     string
 	Nickname    string
 	TimeCreated time.Time
 	RoomID      string
 	UserID      string
+	EditedAt    *time.Time
+	DeletedBy   string
+	DeletedAt   *time.Time
+	ReplyTo     string         // message this one answers
+	ThreadID    string         // root message of the thread, empty for root messages
+	ReplyCount  int            // replies in the thread, kept on the root message
+	LastReplyAt *time.Time     // time of the latest reply, kept on the root message
+	Reactions   map[string]int // number of users per emoji
+	Mentions    []string       // IDs of the users mentioned with @nickname
+	PinnedBy    string         // moderator who pinned the message to the top of the room
+	PinnedAt    *time.Time     // nil for messages that are not pinned
+	Attachments []Attachment   // files sent with the message
+}
+
+// IsSystem reports whether the server wrote the message; such messages can not be changed.
+func (m *Message) IsSystem() bool {
+	return m.Kind == MessageKindSystem
+}
+
+// Attachment is a file uploaded to a room. Until it is sent with a message of the uploader
+// MessageID is empty and nobody else can see it. The content lives in the blob store under
+// BlobKey; app-consumer adds a thumbnail to images once the message is stored.
+type Attachment struct {
+	ID           string
+	RoomID       string
+	MessageID    string
+	UploadedBy   string
+	Name         string
+	ContentType  string
+	Size         int64
+	BlobKey      string
+	ThumbnailKey string // empty until the thumbnail is made, and for files that are not images
+	Width        int    // size of the image in pixels, zero for other files
+	Height       int
+	TimeCreated  time.Time
+}
+
+// IsImage reports whether a thumbnail can be made of the attachment.
+func (a *Attachment) IsImage() bool {
+	return strings.HasPrefix(a.ContentType, "image/")
+}
+
+// Reaction is an emoji put on a message by a user; a user puts each emoji once.
+type Reaction struct {
+	MessageID   string
+	UserID      string
+	Emoji       string
+	TimeCreated time.Time
+	ThreadID    string // thread of the message, lets thread followers receive the change
+}
+
+// ReadReceipt is the last message of the room the user has read.
+type ReadReceipt struct {
+	RoomID    string
+	UserID    string
+	Nickname  string
+	MessageID string
+	Seq       int64
+	TimeRead  time.Time
+}
+
+// MessageCursor is a position in the room history ordered by (TimeCreated, ID).
+type MessageCursor struct {
+	TimeCreated time.Time
+	ID          string
+}
+
+// NewMessageCursor builds the cursor of the message the way Postgres stores it:
+// TIMESTAMP keeps the wall clock without zone with microsecond precision.
+func NewMessageCursor(msg *Message) MessageCursor {
+	t := msg.TimeCreated
+	return MessageCursor{
+		TimeCreated: time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC).Truncate(time.Microsecond),
+		ID:          msg.ID,
+	}
+}
+
+// Compare orders cursors by time and then by ID, returning -1, 0 or +1.
+func (c MessageCursor) Compare(other MessageCursor) int {
+	if cmp := c.TimeCreated.Compare(other.TimeCreated); cmp != 0 {
+		return cmp
+	}
+
+	return strings.Compare(c.ID, other.ID)
+}
+
+// MessagesPage selects up to Limit messages strictly between After and Before.
+// A nil cursor leaves that side of the window open. ThreadID narrows the page
+// down to the replies of that thread.
+type MessagesPage struct {
+	Before        *MessageCursor
+	After         *MessageCursor
+	Limit         int
+	ThreadID      string
+	ExcludeSystem bool // leave system messages out
+}
+
+// MessageSearch selects the messages matching Query in the rooms UserID may read.
+// Page positions the window the way it does in the room history.
+type MessageSearch struct {
+	UserID string
+	Query  string     // words to find, in web search syntax: "quoted phrase", or, -word
+	RoomID string     // a single room to search, all readable rooms when empty
+	Author string     // nickname of the author
+	Since  *time.Time // messages sent at or after
+	Until  *time.Time // messages sent before
+	Page   MessagesPage
+}
+
+// Marks around the matched words in SearchHit.Snippet. Control characters do not occur
+// in message text, so presenters can escape the snippet and replace them safely.
+const (
+	HighlightStart = "\x02"
+	HighlightStop  = "\x03"
+)
+
+// SearchHit is a message found by a search, with the fragments that matched in Snippet.
+type SearchHit struct {
+	Message  Message
+	RoomName string
+	Snippet  string
 }
 
 type User struct {
@@ -16,6 +161,36 @@ type User struct {
 	PasswordHash string
 }
 
+type PresenceStatus string
+
+const (
+	PresenceOnline  PresenceStatus = "online"
+	PresenceAway    PresenceStatus = "away"
+	PresenceOffline PresenceStatus = "offline"
+)
+
+// Presence is the record of a single connection, kept alive by the heartbeats of the instance
+// holding the connection. A record that is not refreshed expires, so the connections of a crashed
+// instance go offline on their own.
+type Presence struct {
+	ConnID     string
+	UserID     string
+	Nickname   string
+	RoomID     string
+	Instance   string // ID of the app-websocket instance holding the connection
+	Status     PresenceStatus
+	LastActive time.Time
+}
+
+// UserPresence is the status of a user over all their connections: online when any of them
+// is online, away when all of them are away, offline when there are none.
+type UserPresence struct {
+	UserID     string
+	Nickname   string
+	Status     PresenceStatus
+	LastActive time.Time
+}
+
 type Tokens struct {
 	AccessToken  string
 	RefreshToken string
@@ -26,10 +201,145 @@ type Session struct {
 	ExpiresAt    time.Time
 }
 
+const (
+	RoomKindRoom   = "room"
+	RoomKindDirect = "direct" // conversation of exactly two users, hidden from the room list
+)
+
+const (
+	RoomPublic  = "public"
+	RoomPrivate = "private" // listed and open to its members only, others join by invitation
+)
+
 type Room struct {
+	ID           string
+	Name         string
+	TimeCreated  time.Time
+	Kind         string
+	Visibility   string
+	Topic        string
+	Description  string
+	AvatarURL    string
+	ArchivedAt   *time.Time // archived rooms are read-only and left out of the default room list
+	LastMessage  *time.Time // time of the latest message, nil for rooms without messages
+	SystemEvents bool       // system messages such as joins and leaves are written to the room
+	Members      int        // number of members, filled in room lists only
+}
+
+// RoomUpdate holds the room settings to change; nil fields are left as they are.
+type RoomUpdate struct {
+	Name         *string
+	Topic        *string
+	Description  *string
+	AvatarURL    *string
+	SystemEvents *bool
+}
+
+// RoomSort is the order of a room list.
+type RoomSort string
+
+const (
+	RoomSortActivity RoomSort = "activity" // latest message first, rooms without messages by creation time
+	RoomSortCreated  RoomSort = "created"  // newest rooms first
+	RoomSortMembers  RoomSort = "members"  // most members first
+	RoomSortName     RoomSort = "name"     // alphabetically
+)
+
+func (s RoomSort) Valid() bool {
+	switch s {
+	case RoomSortActivity, RoomSortCreated, RoomSortMembers, RoomSortName:
+		return true
+	default:
+		return false
+	}
+}
+
+// RoomCursor is the position after a room in a list of the given order:
+// Key is the sort key of the room as Postgres renders it, ID breaks ties.
+type RoomCursor struct {
+	Sort RoomSort
+	Key  string
+	ID   string
+}
+
+// RoomsQuery selects a page of the rooms visible to UserID.
+type RoomsQuery struct {
+	UserID   string
+	Search   string // part of the room name, case-insensitive
+	Sort     RoomSort
+	Member   bool // only the rooms the user is a member of
+	Archived bool // the archived rooms instead of the active ones
+	After    *RoomCursor
+	Limit    int
+}
+
+// Invite lets users become members of a room. An invite with InviteeID is
+// addressed to that user only, otherwise anyone who knows Code may accept it.
+type Invite struct {
 	ID          string
-	Name        string
+	RoomID      string
+	CreatedBy   string
+	InviteeID   string
+	Code        string
+	ExpiresAt   *time.Time // nil for invites that never expire
+	MaxUses     int        // 0 for unlimited
+	Uses        int
 	TimeCreated time.Time
 }
 
-type MessageHandler func(msg Message) error
+type EventType string
+
+const (
+	EventMessageCreated  EventType = "message.created"
+	EventMessageEdited   EventType = "message.edited"
+	EventMessageDeleted  EventType = "message.deleted"
+	EventMessagePinned   EventType = "message.pinned"
+	EventMessageUnpinned EventType = "message.unpinned"
+	EventReactionAdded   EventType = "reaction.added"
+	EventReactionRemoved EventType = "reaction.removed"
+	EventReadUpdated     EventType = "read.updated"
+	EventRoomUpdated     EventType = "room.updated"
+	EventRoomArchived    EventType = "room.archived"
+	EventRoomUnarchived  EventType = "room.unarchived"
+	EventRoomDeleted     EventType = "room.deleted"
+)
+
+// Event is what travels through the broker. Message is set for message events,
+// Reaction for reaction events, Read for read events and Room for room events.
+type Event struct {
+	Type     EventType
+	RoomID   string
+	Message  *Message
+	Reaction *Reaction
+	Read     *ReadReceipt
+	Room     *Room
+}
+
+type EventHandler func(event Event) error
+
+type SignalType string
+
+const (
+	SignalTypingStarted SignalType = "typing.started"
+	SignalTypingStopped SignalType = "typing.stopped"
+	SignalRoleChanged   SignalType = "role.changed"
+	SignalMemberKicked  SignalType = "member.kicked"
+	SignalMemberBanned  SignalType = "member.banned"
+	SignalMemberMuted   SignalType = "member.muted"
+	SignalMemberUnmuted SignalType = "member.unmuted"
+)
+
+// Signal is an ephemeral event shared by the instances. Unlike Event it is
+// neither persisted nor replayed, so a signal missed while offline is gone.
+type Signal struct {
+	Type     SignalType
+	RoomID   string
+	ThreadID string
+	UserID   string
+	Nickname string
+	TTL      time.Duration // how long receivers may trust the signal without a refresh; how long a ban or mute lasts, zero for good
+	Role     Role          // new role of the user, set on role.changed
+	Reason   string        // why the user was kicked, banned or muted
+}
+
+type SignalHandler func(signal Signal) error
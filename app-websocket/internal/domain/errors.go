@@ -7,4 +7,32 @@ var (
 	ErrNicknameAlreadyExist = errors.New("nickname already exist")
 	ErrUserNotFound         = errors.New("user not found by refresh token")
 	ErrRoomNotFound         = errors.New("room not found")
+	ErrRoomAccessDenied     = errors.New("no access to the room")
+	ErrPeerNotFound         = errors.New("user to talk to not found")
+	ErrDirectWithSelf       = errors.New("can not start a direct conversation with yourself")
+	ErrInviteNotFound       = errors.New("invite not found")
+	ErrInviteExpired        = errors.New("invite has expired")
+	ErrInviteUsedUp         = errors.New("invite has no uses left")
+	ErrInviteeNotFound      = errors.New("user to invite not found")
+	ErrNotInviteAuthor      = errors.New("only the author can revoke the invite")
+	ErrDirectRoomInvite     = errors.New("direct rooms do not take invites")
+	ErrPermissionDenied     = errors.New("not enough permissions in the room")
+	ErrInvalidRole          = errors.New("role must be one of owner, admin, moderator, member")
+	ErrMemberNotFound       = errors.New("user is not a member of the room")
+	ErrBanned               = errors.New("you are banned from the room")
+	ErrMuted                = errors.New("you are muted in the room")
+	ErrRoomArchived         = errors.New("room is archived")
+	ErrTooManyPins          = errors.New("room has too many pinned messages")
+	ErrMessageNotFound      = errors.New("message not found")
+	ErrNotMessageAuthor     = errors.New("only the author can change the message")
+	ErrNotModerator         = errors.New("only the author or a moderator can delete the message")
+	ErrThreadNotFound       = errors.New("thread not found")
+	ErrSystemMessage        = errors.New("system messages can not be changed")
+	ErrAttachmentNotFound   = errors.New("attachment not found")
+	ErrAttachmentTooLarge   = errors.New("file is too large")
+	ErrAttachmentType       = errors.New("file type is not allowed")
+	ErrTooManyAttachments   = errors.New("message has too many attachments")
+	ErrTooManyRequests      = errors.New("too many requests")
+	ErrInvalidEmoji         = errors.New("emoji must be a single token of at most 32 bytes")
+	ErrInvalidPresence      = errors.New("status must be online or away")
 )
@@ -0,0 +1,88 @@
+package domain
+
+import (
+	"slices"
+	"time"
+)
+
+// Role is the standing of a user in a room. Users without a stored role are members.
+type Role string
+
+const (
+	RoleOwner     Role = "owner"
+	RoleAdmin     Role = "admin"
+	RoleModerator Role = "moderator"
+	RoleMember    Role = "member"
+)
+
+// Permission is an operation in a room that not every role may perform.
+type Permission string
+
+const (
+	PermEditOthers   Permission = "edit_others"
+	PermDeleteOthers Permission = "delete_others"
+	PermInvite       Permission = "invite"
+	PermKick         Permission = "kick"
+	PermBan          Permission = "ban"
+	PermMute         Permission = "mute"
+	PermPin          Permission = "pin"          // pin messages to the top of the room
+	PermManageRoom   Permission = "manage_room"  // change the settings of the room
+	PermManageRoles  Permission = "manage_roles" // change the roles of lower ranked users
+	PermDeleteRoom   Permission = "delete_room"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleOwner:     {PermEditOthers, PermDeleteOthers, PermInvite, PermKick, PermBan, PermMute, PermPin, PermManageRoom, PermManageRoles, PermDeleteRoom},
+	RoleAdmin:     {PermEditOthers, PermDeleteOthers, PermInvite, PermKick, PermBan, PermMute, PermPin, PermManageRoom, PermManageRoles},
+	RoleModerator: {PermDeleteOthers, PermInvite, PermKick, PermBan, PermMute, PermPin},
+	RoleMember:    {},
+}
+
+var roleRanks = map[Role]int{
+	RoleOwner:     3,
+	RoleAdmin:     2,
+	RoleModerator: 1,
+	RoleMember:    0,
+}
+
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+func (r Role) Can(perm Permission) bool {
+	return slices.Contains(rolePermissions[r], perm)
+}
+
+// Outranks reports whether a user with the role may act on a user with the other role,
+// e.g. change that role or kick that user.
+func (r Role) Outranks(other Role) bool {
+	return roleRanks[r] > roleRanks[other]
+}
+
+type SanctionKind string
+
+const (
+	SanctionBan  SanctionKind = "ban"  // the user can not join the room
+	SanctionMute SanctionKind = "mute" // the user can read the room but not post
+)
+
+// Sanction is a ban or a mute of a user in a room, imposed by a moderator.
+type Sanction struct {
+	RoomID      string
+	UserID      string
+	Nickname    string
+	Kind        SanctionKind
+	Reason      string
+	CreatedBy   string
+	ExpiresAt   *time.Time // nil for sanctions that last until lifted
+	TimeCreated time.Time
+}
+
+// Member is a user who has joined a room, with the role in it.
+type Member struct {
+	UserID     string
+	Nickname   string
+	Role       Role
+	TimeJoined time.Time
+}
@@ -0,0 +1,169 @@
+package chat
+
+import (
+	"app-websocket/internal/domain"
+	common "app-websocket/internal/ports/http"
+	"app-websocket/internal/ports/ws"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// multipartOverhead is the room left in a request for the form around the file.
+	multipartOverhead = 64 << 10
+	// multipartMemory is how much of an upload is kept in memory, the rest goes to a temporary file.
+	multipartMemory = 1 << 20
+)
+
+// UploadAttachment stores the multipart 'file' for the user to send to the room: the IDs of the
+// uploaded files go to the 'attachments' of a message frame.
+func (h *Handler) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "id")
+	if len(roomID) == 0 {
+		common.ProcessError(w, "'id' is required param", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Header.Get("user_id")
+	_, ok := h.writableRoom(w, r, roomID, userID)
+	if !ok {
+		return
+	}
+
+	mute, err := h.roomsProvider.GetSanction(r.Context(), roomID, userID, domain.SanctionMute)
+	if err != nil {
+		h.processAttachmentError(w, err, "failed to upload file")
+		return
+	}
+
+	if mute != nil {
+		common.ProcessError(w, domain.ErrMuted.Error(), http.StatusForbidden)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.attachments.MaxSize()+multipartOverhead)
+	defer r.Body.Close()
+
+	err = r.ParseMultipartForm(multipartMemory)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			common.ProcessError(w, domain.ErrAttachmentTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		common.ProcessError(w, "request body must be a multipart form", http.StatusBadRequest)
+		return
+	}
+	defer func() { _ = r.MultipartForm.RemoveAll() }()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		common.ProcessError(w, "'file' is required field", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	if header.Size == 0 {
+		common.ProcessError(w, "file is empty", http.StatusBadRequest)
+		return
+	}
+
+	attachment, err := h.attachments.Upload(r.Context(), roomID, userID, header.Filename, file, header.Size)
+	if err != nil {
+		h.processAttachmentError(w, err, "failed to upload file")
+		return
+	}
+
+	payload, err := json.Marshal(ws.NewAttachment(attachment))
+	if err != nil {
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(payload)
+}
+
+// GetAttachment downloads a file of the room. Images are shown inline, other files are saved.
+func (h *Handler) GetAttachment(w http.ResponseWriter, r *http.Request) {
+	h.serveAttachment(w, r, false)
+}
+
+// GetThumbnail downloads the JPEG thumbnail of an image; it is 404 until app-consumer has made it.
+func (h *Handler) GetThumbnail(w http.ResponseWriter, r *http.Request) {
+	h.serveAttachment(w, r, true)
+}
+
+func (h *Handler) serveAttachment(w http.ResponseWriter, r *http.Request, thumbnail bool) {
+	roomID := chi.URLParam(r, "id")
+	if len(roomID) == 0 {
+		common.ProcessError(w, "'id' is required param", http.StatusBadRequest)
+		return
+	}
+
+	attachmentID := chi.URLParam(r, "attachmentID")
+	if len(attachmentID) == 0 {
+		common.ProcessError(w, "'attachmentID' is required param", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Header.Get("user_id")
+	_, ok := h.accessRoom(w, r, roomID, userID)
+	if !ok {
+		return
+	}
+
+	attachment, content, err := h.attachments.Open(r.Context(), roomID, attachmentID, userID, thumbnail)
+	if err != nil {
+		h.processAttachmentError(w, err, "failed to get file")
+		return
+	}
+	defer content.Close()
+
+	// attachments never change, and the client must not guess a type other than the detected one
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if thumbnail {
+		w.Header().Set("Content-Type", "image/jpeg")
+	} else {
+		disposition := "attachment"
+		if attachment.IsImage() {
+			disposition = "inline"
+		}
+
+		w.Header().Set("Content-Type", attachment.ContentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Name}))
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, err = io.Copy(w, content)
+	if err != nil {
+		h.logger.Debug("failed to send file", slog.String("id", attachmentID), slog.String("error", err.Error()))
+	}
+}
+
+func (h *Handler) processAttachmentError(w http.ResponseWriter, err error, msg string) {
+	switch {
+	case errors.Is(err, domain.ErrAttachmentNotFound):
+		common.ProcessError(w, domain.ErrAttachmentNotFound.Error(), http.StatusNotFound)
+	case errors.Is(err, domain.ErrAttachmentTooLarge):
+		common.ProcessError(w, domain.ErrAttachmentTooLarge.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, domain.ErrAttachmentType):
+		common.ProcessError(w, domain.ErrAttachmentType.Error(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, domain.ErrPermissionDenied):
+		common.ProcessError(w, domain.ErrPermissionDenied.Error(), http.StatusForbidden)
+	default:
+		h.logger.Error(msg, slog.String("error", err.Error()))
+		common.ProcessError(w, msg, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,65 @@
+package chat
+
+import (
+	"app-websocket/internal/domain"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// encodeCursor renders a history position as an opaque string for clients.
+func encodeCursor(cursor domain.MessageCursor) string {
+	raw := cursor.TimeCreated.Format(time.RFC3339Nano) + "|" + cursor.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(value string) (*domain.MessageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	timePart, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return nil, errInvalidCursor
+	}
+
+	timeCreated, err := time.Parse(time.RFC3339Nano, timePart)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	return &domain.MessageCursor{
+		TimeCreated: timeCreated,
+		ID:          id,
+	}, nil
+}
+
+// encodeRoomCursor renders a room list position as an opaque string for clients.
+func encodeRoomCursor(cursor *domain.RoomCursor) string {
+	raw := string(cursor.Sort) + "|" + cursor.ID + "|" + cursor.Key
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeRoomCursor reads a cursor given out for a room list of the same order;
+// the sort key goes last, as names may contain the separator.
+func decodeRoomCursor(value string, sort domain.RoomSort) (*domain.RoomCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	parts := strings.SplitN(string(raw), "|", 3)
+	if len(parts) != 3 || domain.RoomSort(parts[0]) != sort || parts[1] == "" {
+		return nil, errInvalidCursor
+	}
+
+	return &domain.RoomCursor{
+		Sort: sort,
+		ID:   parts[1],
+		Key:  parts[2],
+	}, nil
+}
@@ -1,49 +1,128 @@
 package chat
 
 import (
+	"app-websocket/internal/config"
 	"app-websocket/internal/domain"
 	common "app-websocket/internal/ports/http"
 	"app-websocket/internal/ports/ws"
+	"app-websocket/pkg/ulid"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/websocket"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type ServiceChatCache interface {
 	GetLastMessagesFromRoom(ctx context.Context, roomID string) ([]domain.Message, error)
-	GetRoomClients(ctx context.Context, roomID string) ([]domain.User, error)
+	GetMessagesSince(ctx context.Context, roomID string, since int64) ([]domain.Message, bool, error)
+	GetMessagesPage(ctx context.Context, roomID string, page *domain.MessagesPage) ([]domain.Message, bool, error)
+	GetThreadMessagesSince(ctx context.Context, roomID, rootID string, since int64) ([]domain.Message, bool, error)
+	GetThreadRoot(ctx context.Context, roomID, rootID string) (*domain.Message, error)
+	GetReadReceipts(ctx context.Context, roomID string) ([]domain.ReadReceipt, error)
+	GetUnreadMentions(ctx context.Context, userID string, count int) ([]domain.Message, error)
+	SearchMessages(ctx context.Context, search *domain.MessageSearch) ([]domain.SearchHit, bool, error)
+	GetPinnedMessages(ctx context.Context, roomID string) ([]domain.Message, error)
 }
 
 type ServiceRoomsProvider interface {
-	GetAllRooms(ctx context.Context) ([]domain.Room, error)
+	GetRooms(ctx context.Context, query *domain.RoomsQuery) ([]domain.Room, *domain.RoomCursor, error)
+	Join(ctx context.Context, room *domain.Room, userID string) error
 	GetRoom(ctx context.Context, roomID string) (*domain.Room, error)
-	CreateRoom(ctx context.Context, name string) (*domain.Room, error)
+	CreateRoom(ctx context.Context, name, visibility, creatorID string) (*domain.Room, error)
+	GetUnreadCounts(ctx context.Context, userID string, roomIDs []string) (map[string]int, error)
+	CreateDirectRoom(ctx context.Context, userID, peerID string) (*domain.Room, error)
+	GetDirectRooms(ctx context.Context, userID string) ([]domain.Room, error)
+	CanAccess(ctx context.Context, room *domain.Room, userID string) (bool, error)
+	CreateInvite(ctx context.Context, room *domain.Room, userID, inviteeID string, ttl time.Duration, maxUses int) (*domain.Invite, error)
+	GetRoomInvites(ctx context.Context, roomID string) ([]domain.Invite, error)
+	GetUserInvites(ctx context.Context, userID string) ([]domain.Invite, error)
+	RevokeInvite(ctx context.Context, room *domain.Room, inviteID, userID string) error
+	AcceptInvite(ctx context.Context, code, userID string) (*domain.Room, error)
+	Authorize(ctx context.Context, room *domain.Room, userID string, perm domain.Permission) error
+	GetMembers(ctx context.Context, roomID string) ([]domain.Member, error)
+	SetRole(ctx context.Context, room *domain.Room, actorID, userID string, role domain.Role) error
+	Kick(ctx context.Context, room *domain.Room, actorID, userID, reason string) error
+	Ban(ctx context.Context, room *domain.Room, actorID, userID, reason string, ttl time.Duration) error
+	Unban(ctx context.Context, room *domain.Room, actorID, userID string) error
+	Mute(ctx context.Context, room *domain.Room, actorID, userID, reason string, ttl time.Duration) error
+	Unmute(ctx context.Context, room *domain.Room, actorID, userID string) error
+	GetSanction(ctx context.Context, roomID, userID string, kind domain.SanctionKind) (*domain.Sanction, error)
+	GetSanctions(ctx context.Context, roomID string, kind domain.SanctionKind) ([]domain.Sanction, error)
+	UpdateRoom(ctx context.Context, room *domain.Room, userID string, update *domain.RoomUpdate) (*domain.Room, error)
+	Archive(ctx context.Context, room *domain.Room, userID string) (*domain.Room, error)
+	Unarchive(ctx context.Context, room *domain.Room, userID string) (*domain.Room, error)
+	DeleteRoom(ctx context.Context, room *domain.Room, userID string) error
 }
 
 type ServiceChatPusher interface {
 	PushMessage(ctx context.Context, msg *domain.Message) error
+	EditMessage(ctx context.Context, roomID, messageID, userID, content string) (*domain.Message, error)
+	DeleteMessage(ctx context.Context, roomID, messageID, userID string) (*domain.Message, error)
+	PinMessage(ctx context.Context, roomID, messageID, userID string) (*domain.Message, error)
+	UnpinMessage(ctx context.Context, roomID, messageID, userID string) (*domain.Message, error)
+	AddReaction(ctx context.Context, roomID, messageID, userID, emoji string) error
+	RemoveReaction(ctx context.Context, roomID, messageID, userID, emoji string) error
+	MarkRead(ctx context.Context, roomID, messageID string, user *domain.User) (*domain.ReadReceipt, error)
 	Subscribe(ctx context.Context, client *ws.Client) error
 	Unsubscribe(ctx context.Context, client *ws.Client) error
 }
 
+// ServiceAttachments keeps the files uploaded to rooms.
+type ServiceAttachments interface {
+	MaxSize() int64
+	Upload(ctx context.Context, roomID, userID, name string, file io.ReadSeeker, size int64) (*domain.Attachment, error)
+	Open(ctx context.Context, roomID, attachmentID, userID string, thumbnail bool) (*domain.Attachment, io.ReadCloser, error)
+}
+
+// ServicePresence tells who is connected, to a room or to any room of any instance.
+type ServicePresence interface {
+	ws.ServicePresence
+	GetRoomClients(ctx context.Context, roomID string) ([]domain.UserPresence, error)
+	CountRoomClients(ctx context.Context, roomIDs []string) (map[string]int, error)
+	GetOnline(ctx context.Context) ([]domain.UserPresence, error)
+	GetUsers(ctx context.Context, userIDs []string) ([]domain.UserPresence, error)
+}
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 100
+	maxRoomSearchLen = 50 // room names are not longer
+)
+
 type Handler struct {
 	logger        *slog.Logger
 	chatCache     ServiceChatCache
 	chatPusher    ServiceChatPusher
+	typing        ws.ServiceTyping
 	roomsProvider ServiceRoomsProvider
+	attachments   ServiceAttachments
+	presence      ServicePresence
+	outbox        *config.OutboxConfig
+	keepalive     ws.Keepalive
 }
 
-func NewHandler(logger *slog.Logger, chatCache ServiceChatCache, chatPusher ServiceChatPusher, roomsProvider ServiceRoomsProvider) *Handler {
+func NewHandler(logger *slog.Logger, chatCache ServiceChatCache, chatPusher ServiceChatPusher, typing ws.ServiceTyping, roomsProvider ServiceRoomsProvider, attachments ServiceAttachments, presence ServicePresence, outbox *config.OutboxConfig, keepalive ws.Keepalive) *Handler {
 	return &Handler{
 		logger:        logger,
 		chatCache:     chatCache,
 		chatPusher:    chatPusher,
+		typing:        typing,
 		roomsProvider: roomsProvider,
+		attachments:   attachments,
+		presence:      presence,
+		outbox:        outbox,
+		keepalive:     keepalive,
 	}
 }
 
@@ -62,18 +141,22 @@ func (h *Handler) CreateRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room, err := h.roomsProvider.CreateRoom(r.Context(), req.Name)
+	if err = validator.New().Struct(req); err != nil {
+		var validateErrs validator.ValidationErrors
+		errors.As(err, &validateErrs)
+
+		common.ProcessError(w, common.ValidationError(validateErrs), http.StatusBadRequest)
+		return
+	}
+
+	room, err := h.roomsProvider.CreateRoom(r.Context(), req.Name, req.Visibility, r.Header.Get("user_id"))
 	if err != nil {
 		h.logger.Error("failed to create room", slog.String("error", err.Error()))
 		common.ProcessError(w, "failed to create room", http.StatusInternalServerError)
 		return
 	}
 
-	roomsResp := RoomRes{
-		ID:          room.ID,
-		Name:        room.Name,
-		TimeCreated: room.TimeCreated,
-	}
+	roomsResp := newRoomRes(room)
 
 	payload, err := json.Marshal(roomsResp)
 	if err != nil {
@@ -101,21 +184,28 @@ func (h *Handler) JoinRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := h.roomsProvider.GetRoom(r.Context(), roomID)
-	if err != nil {
-		if errors.Is(err, domain.ErrRoomNotFound) {
-			common.ProcessError(w, domain.ErrRoomNotFound.Error(), http.StatusBadRequest)
-			return
-		}
+	userID := r.Header.Get("user_id")
+	if userID == "" {
+		userID = r.URL.Query().Get("user_id")
+	}
 
-		h.logger.Error("failed to get room", slog.String("error", err.Error()))
+	room, ok := h.accessRoom(w, r, roomID, userID)
+	if !ok {
+		return
+	}
+
+	mute, err := h.roomsProvider.GetSanction(r.Context(), roomID, userID, domain.SanctionMute)
+	if err != nil {
+		h.logger.Error("failed to get mute", slog.String("error", err.Error()))
 		common.ProcessError(w, "failed to get room", http.StatusInternalServerError)
 		return
 	}
 
-	userID := r.Header.Get("user_id")
-	if userID == "" {
-		userID = r.URL.Query().Get("user_id")
+	err = h.roomsProvider.Join(r.Context(), room, userID)
+	if err != nil {
+		h.logger.Error("failed to join room", slog.String("error", err.Error()))
+		common.ProcessError(w, "failed to join room", http.StatusInternalServerError)
+		return
 	}
 
 	username := r.Header.Get("nickname")
@@ -123,6 +213,39 @@ func (h *Handler) JoinRoom(w http.ResponseWriter, r *http.Request) {
 		username = r.URL.Query().Get("nickname")
 	}
 
+	// since is the sequence number of the last message the client has seen before reconnecting
+	var since int64
+	resume := r.URL.Query().Has("since")
+	if resume {
+		since, err = strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+		if err != nil || since < 0 {
+			common.ProcessError(w, "'since' must be a non-negative sequence number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	excludeSystem, err := parseExcludeSystem(r.URL.Query())
+	if err != nil {
+		common.ProcessError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// thread is the root message of a thread to follow instead of the whole room
+	threadID := r.URL.Query().Get("thread")
+	if threadID != "" {
+		_, err = h.chatCache.GetThreadRoot(r.Context(), roomID, threadID)
+		if err != nil {
+			if errors.Is(err, domain.ErrThreadNotFound) {
+				common.ProcessError(w, domain.ErrThreadNotFound.Error(), http.StatusBadRequest)
+				return
+			}
+
+			h.logger.Error("failed to get thread", slog.String("error", err.Error()))
+			common.ProcessError(w, "failed to get thread", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		h.logger.Error("failed to upgrade connection to web socket", slog.String("error", err.Error()))
@@ -130,7 +253,44 @@ func (h *Handler) JoinRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	messages, err := h.chatCache.GetLastMessagesFromRoom(context.Background(), roomID)
+	cl := &ws.Client{
+		ID:     ulid.New(),
+		Conn:   conn,
+		Send:   ws.NewOutbox(h.outbox.Size, ws.OverflowPolicy(h.outbox.Overflow)),
+		Logger: h.logger,
+		User: &domain.User{
+			ID:       userID,
+			Nickname: username,
+		},
+		RoomID:    roomID,
+		Pusher:    h.chatPusher,
+		Typing:    h.typing,
+		Presence:  h.presence,
+		ThreadID:  threadID,
+		Keepalive: h.keepalive,
+	}
+	cl.SetArchived(room.ArchivedAt != nil)
+	if mute != nil {
+		cl.SetMuted(mute.ExpiresAt)
+	}
+
+	// Subscribe before reading history: live messages are buffered meanwhile,
+	// and the ones that also made it into history are dropped by the writer.
+	err = h.chatPusher.Subscribe(r.Context(), cl)
+	if err != nil {
+		h.logger.Error("failed to subscribe:", slog.String("error", err.Error()))
+	}
+
+	var messages []domain.Message
+	var hasMore bool
+	switch {
+	case threadID != "":
+		messages, hasMore, err = h.chatCache.GetThreadMessagesSince(context.Background(), roomID, threadID, since)
+	case resume:
+		messages, hasMore, err = h.chatCache.GetMessagesSince(context.Background(), roomID, since)
+	default:
+		messages, err = h.chatCache.GetLastMessagesFromRoom(context.Background(), roomID)
+	}
 	if err != nil {
 		h.logger.Error("can not get last messages from room",
 			slog.String("username", username),
@@ -138,110 +298,465 @@ func (h *Handler) JoinRoom(w http.ResponseWriter, r *http.Request) {
 			slog.String("error", err.Error()))
 	}
 
+	if excludeSystem {
+		messages = slices.DeleteFunc(messages, func(msg domain.Message) bool {
+			return msg.IsSystem()
+		})
+	}
+
 	messagesResp := make([]ws.Message, 0)
 	for i := range messages {
-		messagesResp = append(messagesResp, ws.Message{
-			Content:     messages[i].Content,
-			TimeCreated: messages[i].TimeCreated,
-			Username:    messages[i].Nickname,
-			UserID:      messages[i].UserID,
-			RoomID:      roomID,
-		})
+		messagesResp = append(messagesResp, ws.NewMessage(&messages[i]))
 	}
 
-	err = conn.WriteJSON(messagesResp)
+	history, err := ws.NewFrame(ws.FrameHistory, "", ws.HistoryPayload{Messages: messagesResp, HasMore: hasMore})
+	if err == nil {
+		err = cl.WriteFrame(history)
+	}
 	if err != nil {
 		h.logger.Error("can not send message to client",
 			slog.String("username", username),
 			slog.String("RoomID", roomID),
 			slog.String("error", err.Error()))
 	}
-
-	cl := &ws.Client{
-		Conn:    conn,
-		Message: make(chan *ws.Message, 10),
-		Logger:  h.logger,
-		User: &domain.User{
-			ID:       userID,
-			Nickname: username,
-		},
-		RoomID: roomID,
-		Pusher: h.chatPusher,
-	}
-
-	err = h.chatPusher.Subscribe(r.Context(), cl)
-	if err != nil {
-		h.logger.Error("failed to subscribe:", slog.String("error", err.Error()))
-	}
+	cl.MarkReplayed(messages)
 
 	go cl.WriteMessage()
 	cl.ReadMessage(r.Context())
 }
 
+// GetRooms returns a page of the rooms with member and online counts. The list is narrowed
+// with 'q', 'member' and 'archived', ordered by 'sort' and continued with 'after'.
 func (h *Handler) GetRooms(w http.ResponseWriter, r *http.Request) {
-	rooms, err := h.roomsProvider.GetAllRooms(r.Context())
+	userID := r.Header.Get("user_id")
+
+	query, err := parseRoomsQuery(r.URL.Query())
+	if err != nil {
+		common.ProcessError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query.UserID = userID
+
+	rooms, next, err := h.roomsProvider.GetRooms(r.Context(), query)
 	if err != nil {
 		h.logger.Error("failed to get rooms", slog.String("error", err.Error()))
 		common.ProcessError(w, "failed to get rooms", http.StatusInternalServerError)
 		return
 	}
 
-	var roomsResps []RoomRes
+	roomIDs := make([]string, 0, len(rooms))
 	for _, room := range rooms {
-		roomsResp := RoomRes{
-			ID:          room.ID,
-			Name:        room.Name,
-			TimeCreated: room.TimeCreated,
+		roomIDs = append(roomIDs, room.ID)
+	}
+
+	unread, err := h.roomsProvider.GetUnreadCounts(r.Context(), userID, roomIDs)
+	if err != nil {
+		h.logger.Error("failed to get unread counts", slog.String("error", err.Error()))
+		common.ProcessError(w, "failed to get rooms", http.StatusInternalServerError)
+		return
+	}
+
+	// online counts are best effort, the list is still useful without them
+	online, err := h.presence.CountRoomClients(r.Context(), roomIDs)
+	if err != nil {
+		h.logger.Error("failed to count room clients", slog.String("error", err.Error()))
+	}
+
+	pageResp := RoomsPageRes{
+		Rooms:   make([]RoomRes, 0, len(rooms)),
+		HasMore: next != nil,
+	}
+	for _, room := range rooms {
+		roomsResp := newRoomRes(&room)
+		roomsResp.Unread = unread[room.ID]
+		roomsResp.Members = room.Members
+		roomsResp.Online = online[room.ID]
+
+		pageResp.Rooms = append(pageResp.Rooms, roomsResp)
+	}
+
+	if next != nil {
+		pageResp.NextCursor = encodeRoomCursor(next)
+	}
+
+	payload, err := json.Marshal(pageResp)
+	if err != nil {
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(payload)
+}
+
+func (h *Handler) GetClients(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "id")
+	if len(roomID) == 0 {
+		common.ProcessError(w, "'id' is required param", http.StatusBadRequest)
+		return
+	}
+
+	_, ok := h.accessRoom(w, r, roomID, r.Header.Get("user_id"))
+	if !ok {
+		return
+	}
+
+	users, err := h.presence.GetRoomClients(r.Context(), roomID)
+	if err != nil {
+		h.logger.Error("failed to get room clients", slog.String("error", err.Error()))
+		common.ProcessError(w, "failed to get room clients", http.StatusInternalServerError)
+		return
+	}
+
+	clients := make([]ClientRes, 0, len(users))
+	for i := range users {
+		clients = append(clients, newClientRes(&users[i]))
+	}
+
+	payload, err := json.Marshal(clients)
+	if err != nil {
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(payload)
+}
+
+func (h *Handler) GetMessages(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "id")
+	if len(roomID) == 0 {
+		common.ProcessError(w, "'id' is required param", http.StatusBadRequest)
+		return
+	}
+
+	page, err := parseMessagesPage(r.URL.Query())
+	if err != nil {
+		common.ProcessError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page.ExcludeSystem, err = parseExcludeSystem(r.URL.Query())
+	if err != nil {
+		common.ProcessError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, ok := h.accessRoom(w, r, roomID, r.Header.Get("user_id"))
+	if !ok {
+		return
+	}
+
+	messages, hasMore, err := h.chatCache.GetMessagesPage(r.Context(), roomID, page)
+	if err != nil {
+		h.logger.Error("failed to get messages", slog.String("error", err.Error()))
+		common.ProcessError(w, "failed to get messages", http.StatusInternalServerError)
+		return
+	}
+
+	payload, err := json.Marshal(newMessagesPageRes(messages, hasMore))
+	if err != nil {
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(payload)
+}
+
+func (h *Handler) GetThread(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "id")
+	rootID := chi.URLParam(r, "rootID")
+	if len(roomID) == 0 || len(rootID) == 0 {
+		common.ProcessError(w, "'id' and 'rootID' are required params", http.StatusBadRequest)
+		return
+	}
+
+	page, err := parseMessagesPage(r.URL.Query())
+	if err != nil {
+		common.ProcessError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page.ThreadID = rootID
+
+	_, ok := h.accessRoom(w, r, roomID, r.Header.Get("user_id"))
+	if !ok {
+		return
+	}
+
+	root, err := h.chatCache.GetThreadRoot(r.Context(), roomID, rootID)
+	if err != nil {
+		if errors.Is(err, domain.ErrThreadNotFound) {
+			common.ProcessError(w, domain.ErrThreadNotFound.Error(), http.StatusNotFound)
+			return
 		}
 
-		roomsResps = append(roomsResps, roomsResp)
+		h.logger.Error("failed to get thread", slog.String("error", err.Error()))
+		common.ProcessError(w, "failed to get thread", http.StatusInternalServerError)
+		return
 	}
 
-	payload, err := json.Marshal(roomsResps)
+	replies, hasMore, err := h.chatCache.GetMessagesPage(r.Context(), roomID, page)
+	if err != nil {
+		h.logger.Error("failed to get thread replies", slog.String("error", err.Error()))
+		common.ProcessError(w, "failed to get thread replies", http.StatusInternalServerError)
+		return
+	}
+
+	payload, err := json.Marshal(ThreadRes{
+		Root:            ws.NewMessage(root),
+		MessagesPageRes: newMessagesPageRes(replies, hasMore),
+	})
 	if err != nil {
 		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
 		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
 		return
 	}
 
+	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write(payload)
+}
+
+func (h *Handler) EditMessage(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "id")
+	messageID := chi.URLParam(r, "msgID")
+	if len(roomID) == 0 || len(messageID) == 0 {
+		common.ProcessError(w, "'id' and 'msgID' are required params", http.StatusBadRequest)
+		return
+	}
+
+	buf, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		common.ProcessError(w, "can not read request body", http.StatusBadRequest)
+		return
+	}
+
+	_, ok := h.writableRoom(w, r, roomID, r.Header.Get("user_id"))
+	if !ok {
+		return
+	}
+
+	var req EditMessageReq
+	err = json.Unmarshal(buf, &req)
+	if err != nil {
+		common.ProcessError(w, "can not unmarshal request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Content = strings.TrimSpace(req.Content)
+	if err = validator.New().Struct(req); err != nil {
+		var validateErrs validator.ValidationErrors
+		errors.As(err, &validateErrs)
+
+		common.ProcessError(w, common.ValidationError(validateErrs), http.StatusBadRequest)
+		return
+	}
+
+	msg, err := h.chatPusher.EditMessage(r.Context(), roomID, messageID, r.Header.Get("user_id"), req.Content)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrMessageNotFound):
+			common.ProcessError(w, domain.ErrMessageNotFound.Error(), http.StatusNotFound)
+		case errors.Is(err, domain.ErrNotMessageAuthor):
+			common.ProcessError(w, domain.ErrNotMessageAuthor.Error(), http.StatusForbidden)
+		case errors.Is(err, domain.ErrSystemMessage):
+			common.ProcessError(w, domain.ErrSystemMessage.Error(), http.StatusForbidden)
+		case errors.Is(err, domain.ErrMuted):
+			common.ProcessError(w, domain.ErrMuted.Error(), http.StatusForbidden)
+		default:
+			h.logger.Error("failed to edit message", slog.String("error", err.Error()))
+			common.ProcessError(w, "failed to edit message", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	payload, err := json.Marshal(ws.NewMessage(msg))
+	if err != nil {
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(payload)
 }
 
-func (h *Handler) GetClients(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "id")
+	messageID := chi.URLParam(r, "msgID")
+	if len(roomID) == 0 || len(messageID) == 0 {
+		common.ProcessError(w, "'id' and 'msgID' are required params", http.StatusBadRequest)
+		return
+	}
+
+	_, ok := h.writableRoom(w, r, roomID, r.Header.Get("user_id"))
+	if !ok {
+		return
+	}
+
+	msg, err := h.chatPusher.DeleteMessage(r.Context(), roomID, messageID, r.Header.Get("user_id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrMessageNotFound):
+			common.ProcessError(w, domain.ErrMessageNotFound.Error(), http.StatusNotFound)
+		case errors.Is(err, domain.ErrNotModerator):
+			common.ProcessError(w, domain.ErrNotModerator.Error(), http.StatusForbidden)
+		case errors.Is(err, domain.ErrSystemMessage):
+			common.ProcessError(w, domain.ErrSystemMessage.Error(), http.StatusForbidden)
+		case errors.Is(err, domain.ErrMuted):
+			common.ProcessError(w, domain.ErrMuted.Error(), http.StatusForbidden)
+		default:
+			h.logger.Error("failed to delete message", slog.String("error", err.Error()))
+			common.ProcessError(w, "failed to delete message", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	payload, err := json.Marshal(ws.NewMessage(msg))
+	if err != nil {
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(payload)
+}
+
+func (h *Handler) AddReaction(w http.ResponseWriter, r *http.Request) {
+	h.changeReaction(w, r, h.chatPusher.AddReaction)
+}
+
+func (h *Handler) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	h.changeReaction(w, r, h.chatPusher.RemoveReaction)
+}
+
+func (h *Handler) changeReaction(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, roomID, messageID, userID, emoji string) error) {
+	roomID := chi.URLParam(r, "id")
+	messageID := chi.URLParam(r, "msgID")
+	emoji, err := url.PathUnescape(chi.URLParam(r, "emoji"))
+	if err != nil || len(roomID) == 0 || len(messageID) == 0 || len(emoji) == 0 {
+		common.ProcessError(w, "'id', 'msgID' and 'emoji' are required params", http.StatusBadRequest)
+		return
+	}
+
+	_, ok := h.writableRoom(w, r, roomID, r.Header.Get("user_id"))
+	if !ok {
+		return
+	}
+
+	err = change(r.Context(), roomID, messageID, r.Header.Get("user_id"), emoji)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrMessageNotFound):
+			common.ProcessError(w, domain.ErrMessageNotFound.Error(), http.StatusNotFound)
+		case errors.Is(err, domain.ErrInvalidEmoji):
+			common.ProcessError(w, domain.ErrInvalidEmoji.Error(), http.StatusBadRequest)
+		case errors.Is(err, domain.ErrSystemMessage):
+			common.ProcessError(w, domain.ErrSystemMessage.Error(), http.StatusForbidden)
+		case errors.Is(err, domain.ErrMuted):
+			common.ProcessError(w, domain.ErrMuted.Error(), http.StatusForbidden)
+		default:
+			h.logger.Error("failed to change reaction", slog.String("error", err.Error()))
+			common.ProcessError(w, "failed to change reaction", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) MarkRead(w http.ResponseWriter, r *http.Request) {
 	roomID := chi.URLParam(r, "id")
 	if len(roomID) == 0 {
 		common.ProcessError(w, "'id' is required param", http.StatusBadRequest)
 		return
 	}
 
-	_, err := h.roomsProvider.GetRoom(r.Context(), roomID)
+	buf, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
 	if err != nil {
-		if errors.Is(err, domain.ErrRoomNotFound) {
-			common.ProcessError(w, domain.ErrRoomNotFound.Error(), http.StatusBadRequest)
+		common.ProcessError(w, "can not read request body", http.StatusBadRequest)
+		return
+	}
+
+	_, ok := h.accessRoom(w, r, roomID, r.Header.Get("user_id"))
+	if !ok {
+		return
+	}
+
+	var req MarkReadReq
+	err = json.Unmarshal(buf, &req)
+	if err != nil {
+		common.ProcessError(w, "can not unmarshal request body", http.StatusBadRequest)
+		return
+	}
+
+	if err = validator.New().Struct(req); err != nil {
+		var validateErrs validator.ValidationErrors
+		errors.As(err, &validateErrs)
+
+		common.ProcessError(w, common.ValidationError(validateErrs), http.StatusBadRequest)
+		return
+	}
+
+	user := &domain.User{
+		ID:       r.Header.Get("user_id"),
+		Nickname: r.Header.Get("nickname"),
+	}
+
+	receipt, err := h.chatPusher.MarkRead(r.Context(), roomID, req.MessageID, user)
+	if err != nil {
+		if errors.Is(err, domain.ErrMessageNotFound) {
+			common.ProcessError(w, domain.ErrMessageNotFound.Error(), http.StatusNotFound)
 			return
 		}
 
-		h.logger.Error("failed to get room", slog.String("error", err.Error()))
-		common.ProcessError(w, "failed to get room", http.StatusInternalServerError)
+		h.logger.Error("failed to mark messages read", slog.String("error", err.Error()))
+		common.ProcessError(w, "failed to mark messages read", http.StatusInternalServerError)
 		return
 	}
 
-	users, err := h.chatCache.GetRoomClients(r.Context(), roomID)
+	payload, err := json.Marshal(ws.NewReadPayload(receipt))
 	if err != nil {
-		h.logger.Error("failed to get room clients", slog.String("error", err.Error()))
-		common.ProcessError(w, "failed to get room clients", http.StatusInternalServerError)
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
 		return
 	}
 
-	clients := make([]ClientRes, 0)
-	for _, c := range users {
-		clients = append(clients, ClientRes{
-			Username: c.Nickname,
-		})
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(payload)
+}
+
+func (h *Handler) GetReadReceipts(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "id")
+	if len(roomID) == 0 {
+		common.ProcessError(w, "'id' is required param", http.StatusBadRequest)
+		return
 	}
 
-	payload, err := json.Marshal(clients)
+	_, ok := h.accessRoom(w, r, roomID, r.Header.Get("user_id"))
+	if !ok {
+		return
+	}
+
+	receipts, err := h.chatCache.GetReadReceipts(r.Context(), roomID)
+	if err != nil {
+		h.logger.Error("failed to get read receipts", slog.String("error", err.Error()))
+		common.ProcessError(w, "failed to get read receipts", http.StatusInternalServerError)
+		return
+	}
+
+	receiptsResp := make([]ws.ReadPayload, 0, len(receipts))
+	for i := range receipts {
+		receiptsResp = append(receiptsResp, ws.NewReadPayload(&receipts[i]))
+	}
+
+	payload, err := json.Marshal(receiptsResp)
 	if err != nil {
 		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
 		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
@@ -251,3 +766,289 @@ func (h *Handler) GetClients(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write(payload)
 }
+
+// GetMentions lists messages mentioning the current user past the user's read position in their rooms.
+func (h *Handler) GetMentions(w http.ResponseWriter, r *http.Request) {
+	limit := defaultPageLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			common.ProcessError(w, fmt.Sprintf("'limit' must be between 1 and %d", maxPageLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	messages, err := h.chatCache.GetUnreadMentions(r.Context(), r.Header.Get("user_id"), limit)
+	if err != nil {
+		h.logger.Error("failed to get mentions", slog.String("error", err.Error()))
+		common.ProcessError(w, "failed to get mentions", http.StatusInternalServerError)
+		return
+	}
+
+	mentionsResp := make([]ws.Message, 0, len(messages))
+	for i := range messages {
+		mentionsResp = append(mentionsResp, ws.NewMessage(&messages[i]))
+	}
+
+	payload, err := json.Marshal(mentionsResp)
+	if err != nil {
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(payload)
+}
+
+// CreateDirectRoom opens the direct room of the current user and the user from the path.
+// Calling it again returns the same room.
+func (h *Handler) CreateDirectRoom(w http.ResponseWriter, r *http.Request) {
+	peerID := chi.URLParam(r, "userID")
+	if len(peerID) == 0 {
+		common.ProcessError(w, "'userID' is required param", http.StatusBadRequest)
+		return
+	}
+
+	room, err := h.roomsProvider.CreateDirectRoom(r.Context(), r.Header.Get("user_id"), peerID)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrPeerNotFound):
+			common.ProcessError(w, domain.ErrPeerNotFound.Error(), http.StatusNotFound)
+		case errors.Is(err, domain.ErrDirectWithSelf):
+			common.ProcessError(w, domain.ErrDirectWithSelf.Error(), http.StatusBadRequest)
+		default:
+			h.logger.Error("failed to create direct room", slog.String("error", err.Error()))
+			common.ProcessError(w, "failed to create direct room", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	payload, err := json.Marshal(newRoomRes(room))
+	if err != nil {
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(payload)
+}
+
+func (h *Handler) GetDirectRooms(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("user_id")
+
+	rooms, err := h.roomsProvider.GetDirectRooms(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("failed to get direct rooms", slog.String("error", err.Error()))
+		common.ProcessError(w, "failed to get direct rooms", http.StatusInternalServerError)
+		return
+	}
+
+	roomIDs := make([]string, 0, len(rooms))
+	for _, room := range rooms {
+		roomIDs = append(roomIDs, room.ID)
+	}
+
+	unread, err := h.roomsProvider.GetUnreadCounts(r.Context(), userID, roomIDs)
+	if err != nil {
+		h.logger.Error("failed to get unread counts", slog.String("error", err.Error()))
+		common.ProcessError(w, "failed to get direct rooms", http.StatusInternalServerError)
+		return
+	}
+
+	roomsResp := make([]RoomRes, 0, len(rooms))
+	for i := range rooms {
+		roomResp := newRoomRes(&rooms[i])
+		roomResp.Unread = unread[rooms[i].ID]
+		roomsResp = append(roomsResp, roomResp)
+	}
+
+	payload, err := json.Marshal(roomsResp)
+	if err != nil {
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(payload)
+}
+
+// accessRoom loads the room and checks that the user may see it.
+// When it reports false the error response is already written.
+func (h *Handler) accessRoom(w http.ResponseWriter, r *http.Request, roomID, userID string) (*domain.Room, bool) {
+	room, err := h.roomsProvider.GetRoom(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, domain.ErrRoomNotFound) {
+			common.ProcessError(w, domain.ErrRoomNotFound.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+
+		h.logger.Error("failed to get room", slog.String("error", err.Error()))
+		common.ProcessError(w, "failed to get room", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	ok, err := h.roomsProvider.CanAccess(r.Context(), room, userID)
+	if errors.Is(err, domain.ErrBanned) {
+		common.ProcessError(w, domain.ErrBanned.Error(), http.StatusForbidden)
+		return nil, false
+	}
+
+	if err != nil {
+		h.logger.Error("failed to check room access", slog.String("error", err.Error()))
+		common.ProcessError(w, "failed to get room", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if !ok {
+		common.ProcessError(w, domain.ErrRoomAccessDenied.Error(), http.StatusForbidden)
+		return nil, false
+	}
+
+	return room, true
+}
+
+// writableRoom is accessRoom that also refuses changes to archived rooms.
+func (h *Handler) writableRoom(w http.ResponseWriter, r *http.Request, roomID, userID string) (*domain.Room, bool) {
+	room, ok := h.accessRoom(w, r, roomID, userID)
+	if !ok {
+		return nil, false
+	}
+
+	if room.ArchivedAt != nil {
+		common.ProcessError(w, domain.ErrRoomArchived.Error(), http.StatusForbidden)
+		return nil, false
+	}
+
+	return room, true
+}
+
+// authorizeRoom is accessRoom that also requires the role of the user in the room to grant perm.
+func (h *Handler) authorizeRoom(w http.ResponseWriter, r *http.Request, roomID, userID string, perm domain.Permission) (*domain.Room, bool) {
+	room, ok := h.accessRoom(w, r, roomID, userID)
+	if !ok {
+		return nil, false
+	}
+
+	err := h.roomsProvider.Authorize(r.Context(), room, userID, perm)
+	if err != nil {
+		if errors.Is(err, domain.ErrPermissionDenied) {
+			common.ProcessError(w, domain.ErrPermissionDenied.Error(), http.StatusForbidden)
+			return nil, false
+		}
+
+		h.logger.Error("failed to check permissions", slog.String("error", err.Error()))
+		common.ProcessError(w, "failed to get room", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return room, true
+}
+
+// parseMessagesPage reads the 'limit', 'before' and 'after' query params of a history page.
+func parseMessagesPage(query url.Values) (*domain.MessagesPage, error) {
+	page := &domain.MessagesPage{Limit: defaultPageLimit}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return nil, fmt.Errorf("'limit' must be between 1 and %d", maxPageLimit)
+		}
+
+		page.Limit = limit
+	}
+
+	var err error
+	if value := query.Get("before"); value != "" {
+		page.Before, err = decodeCursor(value)
+		if err != nil {
+			return nil, errors.New("'before' is not a valid cursor")
+		}
+	}
+
+	if value := query.Get("after"); value != "" {
+		page.After, err = decodeCursor(value)
+		if err != nil {
+			return nil, errors.New("'after' is not a valid cursor")
+		}
+	}
+
+	return page, nil
+}
+
+// parseExcludeSystem reads the 'system' query param of a history: system messages are
+// included unless it is false.
+func parseExcludeSystem(query url.Values) (bool, error) {
+	value := query.Get("system")
+	if value == "" {
+		return false, nil
+	}
+
+	include, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New("'system' must be true or false")
+	}
+
+	return !include, nil
+}
+
+// parseRoomsQuery reads the 'q', 'sort', 'member', 'archived', 'limit' and 'after' query params of a room list.
+func parseRoomsQuery(values url.Values) (*domain.RoomsQuery, error) {
+	query := &domain.RoomsQuery{
+		Search:   strings.TrimSpace(values.Get("q")),
+		Sort:     domain.RoomSortActivity,
+		Member:   values.Get("member") == "true",
+		Archived: values.Get("archived") == "true",
+		Limit:    defaultPageLimit,
+	}
+
+	if len(query.Search) > maxRoomSearchLen {
+		return nil, fmt.Errorf("'q' must be at most %d bytes", maxRoomSearchLen)
+	}
+
+	if value := values.Get("sort"); value != "" {
+		query.Sort = domain.RoomSort(value)
+		if !query.Sort.Valid() {
+			return nil, errors.New("'sort' must be one of activity, created, members, name")
+		}
+	}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return nil, fmt.Errorf("'limit' must be between 1 and %d", maxPageLimit)
+		}
+
+		query.Limit = limit
+	}
+
+	if value := values.Get("after"); value != "" {
+		var err error
+		query.After, err = decodeRoomCursor(value, query.Sort)
+		if err != nil {
+			return nil, errors.New("'after' is not a valid cursor for this sort")
+		}
+	}
+
+	return query, nil
+}
+
+func newMessagesPageRes(messages []domain.Message, hasMore bool) MessagesPageRes {
+	pageResp := MessagesPageRes{
+		Messages: make([]ws.Message, 0, len(messages)),
+		HasMore:  hasMore,
+	}
+	for i := range messages {
+		pageResp.Messages = append(pageResp.Messages, ws.NewMessage(&messages[i]))
+	}
+
+	if len(messages) > 0 {
+		pageResp.NewerCursor = encodeCursor(domain.NewMessageCursor(&messages[0]))
+		pageResp.OlderCursor = encodeCursor(domain.NewMessageCursor(&messages[len(messages)-1]))
+	}
+
+	return pageResp
+}
@@ -0,0 +1,183 @@
+package chat
+
+import (
+	"app-websocket/internal/domain"
+	common "app-websocket/internal/ports/http"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+func (h *Handler) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "id")
+	if len(roomID) == 0 {
+		common.ProcessError(w, "'id' is required param", http.StatusBadRequest)
+		return
+	}
+
+	buf, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		common.ProcessError(w, "can not read request body", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Header.Get("user_id")
+	room, ok := h.authorizeRoom(w, r, roomID, userID, domain.PermInvite)
+	if !ok {
+		return
+	}
+
+	var req CreateInviteReq
+	err = json.Unmarshal(buf, &req)
+	if err != nil {
+		common.ProcessError(w, "can not unmarshal request body", http.StatusBadRequest)
+		return
+	}
+
+	if err = validator.New().Struct(req); err != nil {
+		var validateErrs validator.ValidationErrors
+		errors.As(err, &validateErrs)
+
+		common.ProcessError(w, common.ValidationError(validateErrs), http.StatusBadRequest)
+		return
+	}
+
+	invite, err := h.roomsProvider.CreateInvite(r.Context(), room, userID, req.UserID, time.Duration(req.ExpiresIn)*time.Second, req.MaxUses)
+	if err != nil {
+		h.processInviteError(w, err, "failed to create invite")
+		return
+	}
+
+	payload, err := json.Marshal(newInviteRes(invite))
+	if err != nil {
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(payload)
+}
+
+func (h *Handler) GetRoomInvites(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "id")
+	if len(roomID) == 0 {
+		common.ProcessError(w, "'id' is required param", http.StatusBadRequest)
+		return
+	}
+
+	_, ok := h.authorizeRoom(w, r, roomID, r.Header.Get("user_id"), domain.PermInvite)
+	if !ok {
+		return
+	}
+
+	invites, err := h.roomsProvider.GetRoomInvites(r.Context(), roomID)
+	if err != nil {
+		h.processInviteError(w, err, "failed to get invites")
+		return
+	}
+
+	h.writeInvites(w, invites)
+}
+
+// GetUserInvites lists the pending invites addressed to the current user.
+func (h *Handler) GetUserInvites(w http.ResponseWriter, r *http.Request) {
+	invites, err := h.roomsProvider.GetUserInvites(r.Context(), r.Header.Get("user_id"))
+	if err != nil {
+		h.processInviteError(w, err, "failed to get invites")
+		return
+	}
+
+	h.writeInvites(w, invites)
+}
+
+func (h *Handler) RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "id")
+	inviteID := chi.URLParam(r, "inviteID")
+	if len(roomID) == 0 || len(inviteID) == 0 {
+		common.ProcessError(w, "'id' and 'inviteID' are required params", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Header.Get("user_id")
+	room, ok := h.accessRoom(w, r, roomID, userID)
+	if !ok {
+		return
+	}
+
+	err := h.roomsProvider.RevokeInvite(r.Context(), room, inviteID, userID)
+	if err != nil {
+		h.processInviteError(w, err, "failed to revoke invite")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptInvite makes the current user a member of the room the invite code leads to.
+func (h *Handler) AcceptInvite(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+	if len(code) == 0 {
+		common.ProcessError(w, "'code' is required param", http.StatusBadRequest)
+		return
+	}
+
+	room, err := h.roomsProvider.AcceptInvite(r.Context(), code, r.Header.Get("user_id"))
+	if err != nil {
+		h.processInviteError(w, err, "failed to accept invite")
+		return
+	}
+
+	payload, err := json.Marshal(newRoomRes(room))
+	if err != nil {
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(payload)
+}
+
+func (h *Handler) writeInvites(w http.ResponseWriter, invites []domain.Invite) {
+	invitesResp := make([]InviteRes, 0, len(invites))
+	for i := range invites {
+		invitesResp = append(invitesResp, newInviteRes(&invites[i]))
+	}
+
+	payload, err := json.Marshal(invitesResp)
+	if err != nil {
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(payload)
+}
+
+func (h *Handler) processInviteError(w http.ResponseWriter, err error, msg string) {
+	switch {
+	case errors.Is(err, domain.ErrInviteNotFound):
+		common.ProcessError(w, domain.ErrInviteNotFound.Error(), http.StatusNotFound)
+	case errors.Is(err, domain.ErrInviteeNotFound):
+		common.ProcessError(w, domain.ErrInviteeNotFound.Error(), http.StatusNotFound)
+	case errors.Is(err, domain.ErrInviteExpired):
+		common.ProcessError(w, domain.ErrInviteExpired.Error(), http.StatusGone)
+	case errors.Is(err, domain.ErrInviteUsedUp):
+		common.ProcessError(w, domain.ErrInviteUsedUp.Error(), http.StatusGone)
+	case errors.Is(err, domain.ErrNotInviteAuthor):
+		common.ProcessError(w, domain.ErrNotInviteAuthor.Error(), http.StatusForbidden)
+	case errors.Is(err, domain.ErrDirectRoomInvite):
+		common.ProcessError(w, domain.ErrDirectRoomInvite.Error(), http.StatusBadRequest)
+	default:
+		h.logger.Error(msg, slog.String("error", err.Error()))
+		common.ProcessError(w, msg, http.StatusInternalServerError)
+	}
+}
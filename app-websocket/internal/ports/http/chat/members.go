@@ -0,0 +1,280 @@
+package chat
+
+import (
+	"app-websocket/internal/domain"
+	common "app-websocket/internal/ports/http"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+func (h *Handler) GetMembers(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "id")
+	if len(roomID) == 0 {
+		common.ProcessError(w, "'id' is required param", http.StatusBadRequest)
+		return
+	}
+
+	_, ok := h.accessRoom(w, r, roomID, r.Header.Get("user_id"))
+	if !ok {
+		return
+	}
+
+	members, err := h.roomsProvider.GetMembers(r.Context(), roomID)
+	if err != nil {
+		h.logger.Error("failed to get members", slog.String("error", err.Error()))
+		common.ProcessError(w, "failed to get members", http.StatusInternalServerError)
+		return
+	}
+
+	membersResp := make([]MemberRes, 0, len(members))
+	for _, member := range members {
+		membersResp = append(membersResp, MemberRes{
+			UserID:     member.UserID,
+			Nickname:   member.Nickname,
+			Role:       string(member.Role),
+			TimeJoined: member.TimeJoined,
+		})
+	}
+
+	payload, err := json.Marshal(membersResp)
+	if err != nil {
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(payload)
+}
+
+// SetRole changes the role of a user in the room; the change is announced to the room with a role frame.
+func (h *Handler) SetRole(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "id")
+	memberID := chi.URLParam(r, "userID")
+	if len(roomID) == 0 || len(memberID) == 0 {
+		common.ProcessError(w, "'id' and 'userID' are required params", http.StatusBadRequest)
+		return
+	}
+
+	buf, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		common.ProcessError(w, "can not read request body", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Header.Get("user_id")
+	room, ok := h.accessRoom(w, r, roomID, userID)
+	if !ok {
+		return
+	}
+
+	var req SetRoleReq
+	err = json.Unmarshal(buf, &req)
+	if err != nil {
+		common.ProcessError(w, "can not unmarshal request body", http.StatusBadRequest)
+		return
+	}
+
+	if err = validator.New().Struct(req); err != nil {
+		var validateErrs validator.ValidationErrors
+		errors.As(err, &validateErrs)
+
+		common.ProcessError(w, common.ValidationError(validateErrs), http.StatusBadRequest)
+		return
+	}
+
+	err = h.roomsProvider.SetRole(r.Context(), room, userID, memberID, domain.Role(req.Role))
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrPermissionDenied):
+			common.ProcessError(w, domain.ErrPermissionDenied.Error(), http.StatusForbidden)
+		case errors.Is(err, domain.ErrMemberNotFound):
+			common.ProcessError(w, domain.ErrMemberNotFound.Error(), http.StatusNotFound)
+		case errors.Is(err, domain.ErrInvalidRole):
+			common.ProcessError(w, domain.ErrInvalidRole.Error(), http.StatusBadRequest)
+		default:
+			h.logger.Error("failed to set role", slog.String("error", err.Error()))
+			common.ProcessError(w, "failed to set role", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Kick disconnects a user from the room; the user may join again.
+func (h *Handler) Kick(w http.ResponseWriter, r *http.Request) {
+	var req KickReq
+	room, memberID, ok := h.parseModeration(w, r, &req)
+	if !ok {
+		return
+	}
+
+	err := h.roomsProvider.Kick(r.Context(), room, r.Header.Get("user_id"), memberID, req.Reason)
+	if err != nil {
+		h.processModerationError(w, err, "failed to kick user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) Ban(w http.ResponseWriter, r *http.Request) {
+	h.addSanction(w, r, h.roomsProvider.Ban)
+}
+
+func (h *Handler) Unban(w http.ResponseWriter, r *http.Request) {
+	h.removeSanction(w, r, h.roomsProvider.Unban)
+}
+
+func (h *Handler) GetBans(w http.ResponseWriter, r *http.Request) {
+	h.getSanctions(w, r, domain.SanctionBan, domain.PermBan)
+}
+
+func (h *Handler) Mute(w http.ResponseWriter, r *http.Request) {
+	h.addSanction(w, r, h.roomsProvider.Mute)
+}
+
+func (h *Handler) Unmute(w http.ResponseWriter, r *http.Request) {
+	h.removeSanction(w, r, h.roomsProvider.Unmute)
+}
+
+func (h *Handler) GetMutes(w http.ResponseWriter, r *http.Request) {
+	h.getSanctions(w, r, domain.SanctionMute, domain.PermMute)
+}
+
+func (h *Handler) addSanction(w http.ResponseWriter, r *http.Request, add func(ctx context.Context, room *domain.Room, actorID, userID, reason string, ttl time.Duration) error) {
+	var req SanctionReq
+	room, memberID, ok := h.parseModeration(w, r, &req)
+	if !ok {
+		return
+	}
+
+	err := add(r.Context(), room, r.Header.Get("user_id"), memberID, req.Reason, time.Duration(req.Duration)*time.Second)
+	if err != nil {
+		h.processModerationError(w, err, "failed to sanction user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) removeSanction(w http.ResponseWriter, r *http.Request, remove func(ctx context.Context, room *domain.Room, actorID, userID string) error) {
+	room, memberID, ok := h.parseModeration(w, r, nil)
+	if !ok {
+		return
+	}
+
+	err := remove(r.Context(), room, r.Header.Get("user_id"), memberID)
+	if err != nil {
+		h.processModerationError(w, err, "failed to lift sanction")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) getSanctions(w http.ResponseWriter, r *http.Request, kind domain.SanctionKind, perm domain.Permission) {
+	roomID := chi.URLParam(r, "id")
+	if len(roomID) == 0 {
+		common.ProcessError(w, "'id' is required param", http.StatusBadRequest)
+		return
+	}
+
+	_, ok := h.authorizeRoom(w, r, roomID, r.Header.Get("user_id"), perm)
+	if !ok {
+		return
+	}
+
+	sanctions, err := h.roomsProvider.GetSanctions(r.Context(), roomID, kind)
+	if err != nil {
+		h.logger.Error("failed to get sanctions", slog.String("error", err.Error()))
+		common.ProcessError(w, "failed to get sanctions", http.StatusInternalServerError)
+		return
+	}
+
+	sanctionsResp := make([]SanctionRes, 0, len(sanctions))
+	for _, sanction := range sanctions {
+		sanctionsResp = append(sanctionsResp, SanctionRes{
+			UserID:      sanction.UserID,
+			Nickname:    sanction.Nickname,
+			Reason:      sanction.Reason,
+			CreatedBy:   sanction.CreatedBy,
+			ExpiresAt:   sanction.ExpiresAt,
+			TimeCreated: sanction.TimeCreated,
+		})
+	}
+
+	payload, err := json.Marshal(sanctionsResp)
+	if err != nil {
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(payload)
+}
+
+// parseModeration reads the room and the target user of a moderation request and decodes
+// the optional body into req. When it reports false the error response is already written.
+func (h *Handler) parseModeration(w http.ResponseWriter, r *http.Request, req any) (*domain.Room, string, bool) {
+	roomID := chi.URLParam(r, "id")
+	memberID := chi.URLParam(r, "userID")
+	if len(roomID) == 0 || len(memberID) == 0 {
+		common.ProcessError(w, "'id' and 'userID' are required params", http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	buf, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		common.ProcessError(w, "can not read request body", http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	room, ok := h.accessRoom(w, r, roomID, r.Header.Get("user_id"))
+	if !ok {
+		return nil, "", false
+	}
+
+	if req == nil || len(buf) == 0 {
+		return room, memberID, true
+	}
+
+	err = json.Unmarshal(buf, req)
+	if err != nil {
+		common.ProcessError(w, "can not unmarshal request body", http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	if err = validator.New().Struct(req); err != nil {
+		var validateErrs validator.ValidationErrors
+		errors.As(err, &validateErrs)
+
+		common.ProcessError(w, common.ValidationError(validateErrs), http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	return room, memberID, true
+}
+
+func (h *Handler) processModerationError(w http.ResponseWriter, err error, msg string) {
+	switch {
+	case errors.Is(err, domain.ErrPermissionDenied):
+		common.ProcessError(w, domain.ErrPermissionDenied.Error(), http.StatusForbidden)
+	case errors.Is(err, domain.ErrMemberNotFound):
+		common.ProcessError(w, domain.ErrMemberNotFound.Error(), http.StatusNotFound)
+	default:
+		h.logger.Error(msg, slog.String("error", err.Error()))
+		common.ProcessError(w, msg, http.StatusInternalServerError)
+	}
+}
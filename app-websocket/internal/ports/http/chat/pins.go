@@ -0,0 +1,101 @@
+package chat
+
+import (
+	"app-websocket/internal/domain"
+	common "app-websocket/internal/ports/http"
+	"app-websocket/internal/ports/ws"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"log/slog"
+	"net/http"
+)
+
+// GetPins lists the pinned messages of the room, the latest pinned first.
+func (h *Handler) GetPins(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "id")
+	if len(roomID) == 0 {
+		common.ProcessError(w, "'id' is required param", http.StatusBadRequest)
+		return
+	}
+
+	_, ok := h.accessRoom(w, r, roomID, r.Header.Get("user_id"))
+	if !ok {
+		return
+	}
+
+	messages, err := h.chatCache.GetPinnedMessages(r.Context(), roomID)
+	if err != nil {
+		h.logger.Error("failed to get pinned messages", slog.String("error", err.Error()))
+		common.ProcessError(w, "failed to get pinned messages", http.StatusInternalServerError)
+		return
+	}
+
+	messagesResp := make([]ws.Message, 0, len(messages))
+	for i := range messages {
+		messagesResp = append(messagesResp, ws.NewMessage(&messages[i]))
+	}
+
+	payload, err := json.Marshal(messagesResp)
+	if err != nil {
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(payload)
+}
+
+// PinMessage pins the message to the top of the room; the room gets a pin frame.
+func (h *Handler) PinMessage(w http.ResponseWriter, r *http.Request) {
+	h.changePin(w, r, h.chatPusher.PinMessage)
+}
+
+func (h *Handler) UnpinMessage(w http.ResponseWriter, r *http.Request) {
+	h.changePin(w, r, h.chatPusher.UnpinMessage)
+}
+
+func (h *Handler) changePin(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, roomID, messageID, userID string) (*domain.Message, error)) {
+	roomID := chi.URLParam(r, "id")
+	messageID := chi.URLParam(r, "msgID")
+	if len(roomID) == 0 || len(messageID) == 0 {
+		common.ProcessError(w, "'id' and 'msgID' are required params", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Header.Get("user_id")
+	_, ok := h.writableRoom(w, r, roomID, userID)
+	if !ok {
+		return
+	}
+
+	msg, err := change(r.Context(), roomID, messageID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrMessageNotFound):
+			common.ProcessError(w, domain.ErrMessageNotFound.Error(), http.StatusNotFound)
+		case errors.Is(err, domain.ErrPermissionDenied):
+			common.ProcessError(w, domain.ErrPermissionDenied.Error(), http.StatusForbidden)
+		case errors.Is(err, domain.ErrSystemMessage):
+			common.ProcessError(w, domain.ErrSystemMessage.Error(), http.StatusForbidden)
+		case errors.Is(err, domain.ErrTooManyPins):
+			common.ProcessError(w, domain.ErrTooManyPins.Error(), http.StatusConflict)
+		default:
+			h.logger.Error("failed to change pin", slog.String("error", err.Error()))
+			common.ProcessError(w, "failed to change pin", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	payload, err := json.Marshal(ws.NewMessage(msg))
+	if err != nil {
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(payload)
+}
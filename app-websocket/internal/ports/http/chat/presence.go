@@ -0,0 +1,53 @@
+package chat
+
+import (
+	"app-websocket/internal/domain"
+	common "app-websocket/internal/ports/http"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// maxPresenceUsers limits how many users a single presence query may ask about.
+const maxPresenceUsers = 100
+
+// GetPresence lists the users connected to any room, or with 'users' (comma-separated IDs)
+// the status of exactly those users, offline ones included.
+func (h *Handler) GetPresence(w http.ResponseWriter, r *http.Request) {
+	var users []domain.UserPresence
+	var err error
+
+	if param := r.URL.Query().Get("users"); param != "" {
+		userIDs := strings.Split(param, ",")
+		if len(userIDs) > maxPresenceUsers {
+			common.ProcessError(w, fmt.Sprintf("'users' must list at most %d users", maxPresenceUsers), http.StatusBadRequest)
+			return
+		}
+
+		users, err = h.presence.GetUsers(r.Context(), userIDs)
+	} else {
+		users, err = h.presence.GetOnline(r.Context())
+	}
+	if err != nil {
+		h.logger.Error("failed to get presence", slog.String("error", err.Error()))
+		common.ProcessError(w, "failed to get presence", http.StatusInternalServerError)
+		return
+	}
+
+	clients := make([]ClientRes, 0, len(users))
+	for i := range users {
+		clients = append(clients, newClientRes(&users[i]))
+	}
+
+	payload, err := json.Marshal(clients)
+	if err != nil {
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(payload)
+}
@@ -1,17 +1,198 @@
 package chat
 
-import "time"
+import (
+	"app-websocket/internal/domain"
+	"app-websocket/internal/ports/ws"
+	"html"
+	"strings"
+	"time"
+)
 
 type CreateRoomReq struct {
-	Name string `json:"name"`
+	Name       string `json:"name" validate:"required,max=50"`
+	Visibility string `json:"visibility" validate:"omitempty,oneof=public private"`
+}
+
+// CreateInviteReq addresses the invite to UserID when set, otherwise the invite is a shareable link.
+// ExpiresIn is in seconds; zero ExpiresIn and MaxUses leave the invite unlimited.
+type CreateInviteReq struct {
+	UserID    string `json:"user_id" validate:"omitempty,numeric"`
+	ExpiresIn int    `json:"expires_in" validate:"gte=0"`
+	MaxUses   int    `json:"max_uses" validate:"gte=0"`
+}
+
+type InviteRes struct {
+	ID          string     `json:"id"`
+	RoomID      string     `json:"room_id"`
+	CreatedBy   string     `json:"created_by"`
+	UserID      string     `json:"user_id,omitempty"`
+	Code        string     `json:"code"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	MaxUses     int        `json:"max_uses"`
+	Uses        int        `json:"uses"`
+	TimeCreated time.Time  `json:"time_created"`
+}
+
+func newInviteRes(invite *domain.Invite) InviteRes {
+	return InviteRes{
+		ID:          invite.ID,
+		RoomID:      invite.RoomID,
+		CreatedBy:   invite.CreatedBy,
+		UserID:      invite.InviteeID,
+		Code:        invite.Code,
+		ExpiresAt:   invite.ExpiresAt,
+		MaxUses:     invite.MaxUses,
+		Uses:        invite.Uses,
+		TimeCreated: invite.TimeCreated,
+	}
+}
+
+type EditMessageReq struct {
+	Content string `json:"content" validate:"required,max=300"`
 }
 
 type RoomRes struct {
-	ID          string    `json:"id"`
-	TimeCreated time.Time `json:"time_created"`
-	Name        string    `json:"name"`
+	ID           string     `json:"id"`
+	TimeCreated  time.Time  `json:"time_created"`
+	Name         string     `json:"name"`
+	Kind         string     `json:"kind"`
+	Visibility   string     `json:"visibility"`
+	Topic        string     `json:"topic"`
+	Description  string     `json:"description"`
+	AvatarURL    string     `json:"avatar_url"`
+	ArchivedAt   *time.Time `json:"archived_at,omitempty"`
+	LastMessage  *time.Time `json:"last_message_at,omitempty"`
+	SystemEvents bool       `json:"system_events"`
+	Unread       int        `json:"unread"`
+	Members      int        `json:"members"`
+	Online       int        `json:"online"`
+}
+
+// RoomsPageRes is a page of the room list; NextCursor continues it via 'after'.
+type RoomsPageRes struct {
+	Rooms      []RoomRes `json:"rooms"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	HasMore    bool      `json:"has_more"`
+}
+
+// UpdateRoomReq changes only the fields present in the request.
+type UpdateRoomReq struct {
+	Name        *string `json:"name" validate:"omitempty,min=1,max=50"`
+	Topic       *string `json:"topic" validate:"omitempty,max=250"`
+	Description *string `json:"description" validate:"omitempty,max=1000"`
+	AvatarURL   *string `json:"avatar_url" validate:"omitempty,url,max=500"`
+	// SystemEvents turns the join and leave messages of the room on or off
+	SystemEvents *bool `json:"system_events"`
+}
+
+func newRoomRes(room *domain.Room) RoomRes {
+	return RoomRes{
+		ID:           room.ID,
+		Name:         room.Name,
+		TimeCreated:  room.TimeCreated,
+		Kind:         room.Kind,
+		Visibility:   room.Visibility,
+		Topic:        room.Topic,
+		Description:  room.Description,
+		AvatarURL:    room.AvatarURL,
+		ArchivedAt:   room.ArchivedAt,
+		LastMessage:  room.LastMessage,
+		SystemEvents: room.SystemEvents,
+	}
+}
+
+type SetRoleReq struct {
+	Role string `json:"role" validate:"required,oneof=owner admin moderator member"`
+}
+
+type MemberRes struct {
+	UserID     string    `json:"user_id"`
+	Nickname   string    `json:"nickname"`
+	Role       string    `json:"role"`
+	TimeJoined time.Time `json:"time_joined"`
+}
+
+type KickReq struct {
+	Reason string `json:"reason" validate:"max=300"`
 }
 
+// SanctionReq bans or mutes a user for Duration seconds, for good when it is zero.
+type SanctionReq struct {
+	Reason   string `json:"reason" validate:"max=300"`
+	Duration int    `json:"duration" validate:"gte=0"`
+}
+
+type SanctionRes struct {
+	UserID      string     `json:"user_id"`
+	Nickname    string     `json:"nickname"`
+	Reason      string     `json:"reason,omitempty"`
+	CreatedBy   string     `json:"created_by"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	TimeCreated time.Time  `json:"time_created"`
+}
+
+type MarkReadReq struct {
+	MessageID string `json:"message_id" validate:"required"`
+}
+
+// ClientRes is the presence of a user; LastActive is missing for offline users.
 type ClientRes struct {
-	Username string `json:"nickname"`
+	UserID     string                `json:"user_id"`
+	Username   string                `json:"nickname"`
+	Status     domain.PresenceStatus `json:"status"`
+	LastActive *time.Time            `json:"last_active,omitempty"`
+}
+
+func newClientRes(user *domain.UserPresence) ClientRes {
+	res := ClientRes{
+		UserID:   user.UserID,
+		Username: user.Nickname,
+		Status:   user.Status,
+	}
+	if !user.LastActive.IsZero() {
+		lastActive := user.LastActive
+		res.LastActive = &lastActive
+	}
+
+	return res
+}
+
+// MessagesPageRes lists messages newest first. OlderCursor continues the history
+// backwards via 'before', NewerCursor forwards via 'after'.
+type MessagesPageRes struct {
+	Messages    []ws.Message `json:"messages"`
+	OlderCursor string       `json:"older_cursor,omitempty"`
+	NewerCursor string       `json:"newer_cursor,omitempty"`
+	HasMore     bool         `json:"has_more"`
+}
+
+// SearchRes is a page of search results newest first, paged with the cursors like MessagesPageRes.
+type SearchRes struct {
+	Results     []SearchHitRes `json:"results"`
+	OlderCursor string         `json:"older_cursor,omitempty"`
+	NewerCursor string         `json:"newer_cursor,omitempty"`
+	HasMore     bool           `json:"has_more"`
+}
+
+// SearchHitRes is a found message; Snippet is HTML-escaped with the matched words in <mark>.
+type SearchHitRes struct {
+	Message  ws.Message `json:"message"`
+	RoomName string     `json:"room_name"`
+	Snippet  string     `json:"snippet"`
+}
+
+var snippetMarks = strings.NewReplacer(domain.HighlightStart, "<mark>", domain.HighlightStop, "</mark>")
+
+func newSearchHitRes(hit *domain.SearchHit) SearchHitRes {
+	return SearchHitRes{
+		Message:  ws.NewMessage(&hit.Message),
+		RoomName: hit.RoomName,
+		Snippet:  snippetMarks.Replace(html.EscapeString(hit.Snippet)),
+	}
+}
+
+// ThreadRes holds the root message and a page of its replies, newest first.
+type ThreadRes struct {
+	Root ws.Message `json:"root"`
+	MessagesPageRes
 }
@@ -0,0 +1,156 @@
+package chat
+
+import (
+	"app-websocket/internal/domain"
+	common "app-websocket/internal/ports/http"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// UpdateRoom changes the name, topic, description or avatar of the room; the clients get a room frame.
+func (h *Handler) UpdateRoom(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "id")
+	if len(roomID) == 0 {
+		common.ProcessError(w, "'id' is required param", http.StatusBadRequest)
+		return
+	}
+
+	buf, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		common.ProcessError(w, "can not read request body", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Header.Get("user_id")
+	room, ok := h.writableRoom(w, r, roomID, userID)
+	if !ok {
+		return
+	}
+
+	var req UpdateRoomReq
+	err = json.Unmarshal(buf, &req)
+	if err != nil {
+		common.ProcessError(w, "can not unmarshal request body", http.StatusBadRequest)
+		return
+	}
+
+	if err = validator.New().Struct(req); err != nil {
+		var validateErrs validator.ValidationErrors
+		errors.As(err, &validateErrs)
+
+		common.ProcessError(w, common.ValidationError(validateErrs), http.StatusBadRequest)
+		return
+	}
+
+	room, err = h.roomsProvider.UpdateRoom(r.Context(), room, userID, &domain.RoomUpdate{
+		Name:         req.Name,
+		Topic:        req.Topic,
+		Description:  req.Description,
+		AvatarURL:    req.AvatarURL,
+		SystemEvents: req.SystemEvents,
+	})
+	if err != nil {
+		h.processRoomError(w, err, "failed to update room")
+		return
+	}
+
+	h.writeRoom(w, room)
+}
+
+// ArchiveRoom makes the room read-only; archived rooms are listed with ?archived=true only.
+func (h *Handler) ArchiveRoom(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "id")
+	if len(roomID) == 0 {
+		common.ProcessError(w, "'id' is required param", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Header.Get("user_id")
+	room, ok := h.accessRoom(w, r, roomID, userID)
+	if !ok {
+		return
+	}
+
+	room, err := h.roomsProvider.Archive(r.Context(), room, userID)
+	if err != nil {
+		h.processRoomError(w, err, "failed to archive room")
+		return
+	}
+
+	h.writeRoom(w, room)
+}
+
+func (h *Handler) UnarchiveRoom(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "id")
+	if len(roomID) == 0 {
+		common.ProcessError(w, "'id' is required param", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Header.Get("user_id")
+	room, ok := h.accessRoom(w, r, roomID, userID)
+	if !ok {
+		return
+	}
+
+	room, err := h.roomsProvider.Unarchive(r.Context(), room, userID)
+	if err != nil {
+		h.processRoomError(w, err, "failed to unarchive room")
+		return
+	}
+
+	h.writeRoom(w, room)
+}
+
+// DeleteRoom removes the room with its history; connected clients are disconnected.
+func (h *Handler) DeleteRoom(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "id")
+	if len(roomID) == 0 {
+		common.ProcessError(w, "'id' is required param", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Header.Get("user_id")
+	room, ok := h.accessRoom(w, r, roomID, userID)
+	if !ok {
+		return
+	}
+
+	err := h.roomsProvider.DeleteRoom(r.Context(), room, userID)
+	if err != nil {
+		h.processRoomError(w, err, "failed to delete room")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) writeRoom(w http.ResponseWriter, room *domain.Room) {
+	payload, err := json.Marshal(newRoomRes(room))
+	if err != nil {
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(payload)
+}
+
+func (h *Handler) processRoomError(w http.ResponseWriter, err error, msg string) {
+	switch {
+	case errors.Is(err, domain.ErrPermissionDenied):
+		common.ProcessError(w, domain.ErrPermissionDenied.Error(), http.StatusForbidden)
+	case errors.Is(err, domain.ErrRoomNotFound):
+		common.ProcessError(w, domain.ErrRoomNotFound.Error(), http.StatusNotFound)
+	default:
+		h.logger.Error(msg, slog.String("error", err.Error()))
+		common.ProcessError(w, msg, http.StatusInternalServerError)
+	}
+}
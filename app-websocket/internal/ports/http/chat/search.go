@@ -0,0 +1,115 @@
+package chat
+
+import (
+	"app-websocket/internal/domain"
+	common "app-websocket/internal/ports/http"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const maxSearchQueryLen = 200
+
+// SearchMessages finds messages by their words in the rooms the user can read. The search is
+// narrowed down with 'room', 'from' (nickname of the author), 'since' and 'until' (RFC 3339
+// times or dates), and paged with 'limit', 'before' and 'after' like the room history.
+func (h *Handler) SearchMessages(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	userID := r.Header.Get("user_id")
+
+	q := strings.TrimSpace(values.Get("q"))
+	if q == "" {
+		common.ProcessError(w, "'q' is required param", http.StatusBadRequest)
+		return
+	}
+
+	if len(q) > maxSearchQueryLen {
+		common.ProcessError(w, fmt.Sprintf("'q' must be at most %d bytes", maxSearchQueryLen), http.StatusBadRequest)
+		return
+	}
+
+	page, err := parseMessagesPage(values)
+	if err != nil {
+		common.ProcessError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	search := &domain.MessageSearch{
+		UserID: userID,
+		Query:  q,
+		RoomID: values.Get("room"),
+		Author: values.Get("from"),
+		Page:   *page,
+	}
+
+	search.Since, err = parseSearchTime(values.Get("since"))
+	if err != nil {
+		common.ProcessError(w, "'since' must be a RFC 3339 time or a date", http.StatusBadRequest)
+		return
+	}
+
+	search.Until, err = parseSearchTime(values.Get("until"))
+	if err != nil {
+		common.ProcessError(w, "'until' must be a RFC 3339 time or a date", http.StatusBadRequest)
+		return
+	}
+
+	// the search skips rooms the user can not read anyway, but a single room deserves a clear answer
+	if search.RoomID != "" {
+		_, ok := h.accessRoom(w, r, search.RoomID, userID)
+		if !ok {
+			return
+		}
+	}
+
+	hits, hasMore, err := h.chatCache.SearchMessages(r.Context(), search)
+	if err != nil {
+		h.logger.Error("failed to search messages", slog.String("error", err.Error()))
+		common.ProcessError(w, "failed to search messages", http.StatusInternalServerError)
+		return
+	}
+
+	searchResp := SearchRes{
+		Results: make([]SearchHitRes, 0, len(hits)),
+		HasMore: hasMore,
+	}
+	for i := range hits {
+		searchResp.Results = append(searchResp.Results, newSearchHitRes(&hits[i]))
+	}
+
+	if len(hits) > 0 {
+		searchResp.NewerCursor = encodeCursor(domain.NewMessageCursor(&hits[0].Message))
+		searchResp.OlderCursor = encodeCursor(domain.NewMessageCursor(&hits[len(hits)-1].Message))
+	}
+
+	payload, err := json.Marshal(searchResp)
+	if err != nil {
+		h.logger.Error("can not marshal response body", slog.String("error", err.Error()))
+		common.ProcessError(w, "can not marshal response body", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(payload)
+}
+
+// parseSearchTime reads a time filter; a date stands for its midnight in UTC.
+func parseSearchTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t, err = time.Parse(time.DateOnly, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t = t.UTC()
+	return &t, nil
+}
@@ -9,6 +9,7 @@ import (
 	mwlogger "app-websocket/pkg/logger/middleware"
 	"app-websocket/pkg/rate_limiter"
 	"context"
+	"expvar"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -21,15 +22,32 @@ import (
 type Server struct {
 	logger          *slog.Logger
 	server          *http.Server
+	metrics         *http.Server
 	hub             *ws.Hub
 	shutDownTimeout time.Duration
 	certFilePath    string
 	keyFilePath     string
 }
 
-func NewServer(config *config.HTTPConfig, authService auth.ServiceAuth, chatService chat.ServiceChatCache, chatPusher chat.ServiceChatPusher, roomsProvider chat.ServiceRoomsProvider, logger *slog.Logger, manager jwt.TokenManager, hub *ws.Hub) (*Server, error) {
+func NewServer(config *config.HTTPConfig, authService auth.ServiceAuth, chatService chat.ServiceChatCache, chatPusher chat.ServiceChatPusher, typing ws.ServiceTyping, roomsProvider chat.ServiceRoomsProvider, attachments chat.ServiceAttachments, presence chat.ServicePresence, logger *slog.Logger, manager jwt.TokenManager, hub *ws.Hub) (*Server, error) {
+	if config.Outbox.Size <= 0 || !ws.OverflowPolicy(config.Outbox.Overflow).Valid() {
+		return nil, fmt.Errorf("outbox needs a positive size and overflow of %q or %q", ws.OverflowDropOldest, ws.OverflowDisconnect)
+	}
+
+	if config.PingInterval <= 0 || config.PongWait <= config.PingInterval || config.FrameWriteTimeout <= 0 {
+		return nil, fmt.Errorf("websocket keepalive needs a positive ping_interval, a longer pong_wait and a positive frame_write_timeout")
+	}
+
+	expvar.Publish("ws_outbox_depth", expvar.Func(func() any {
+		return hub.OutboxDepth()
+	}))
+
 	httpHandler := auth.NewHandler(logger, authService)
-	wsHandler := chat.NewHandler(logger, chatService, chatPusher, roomsProvider)
+	wsHandler := chat.NewHandler(logger, chatService, chatPusher, typing, roomsProvider, attachments, presence, &config.Outbox, ws.Keepalive{
+		PingInterval: config.PingInterval,
+		PongWait:     config.PongWait,
+		WriteTimeout: config.FrameWriteTimeout,
+	})
 
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%s", config.Port),
@@ -38,9 +56,21 @@ func NewServer(config *config.HTTPConfig, authService auth.ServiceAuth, chatServ
 		WriteTimeout: config.WriteTimeout,
 	}
 
+	// the metrics are served apart from the API, so they are never reachable through its port
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/debug/vars", expvar.Handler())
+
+	metrics := &http.Server{
+		Addr:         fmt.Sprintf(":%s", config.MetricsPort),
+		Handler:      metricsMux,
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+	}
+
 	return &Server{
 		hub:             hub,
 		server:          server,
+		metrics:         metrics,
 		shutDownTimeout: config.ShutdownTimeout,
 		logger:          logger,
 		certFilePath:    config.TLS.CertFilePath,
@@ -53,7 +83,7 @@ func InitRouter(auth *auth.Handler, chat *chat.Handler, logger *slog.Logger, lim
 
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"http://localhost"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
 		AllowCredentials: true,
 		MaxAge:           300, // максимальный срок кэширования предварительных запросов
@@ -72,14 +102,51 @@ func InitRouter(auth *auth.Handler, chat *chat.Handler, logger *slog.Logger, lim
 
 		r.Post("/rooms", chat.CreateRoom)
 		r.Get("/rooms", chat.GetRooms)
+		r.Get("/mentions", chat.GetMentions)
+		r.Get("/search", chat.SearchMessages)
+		r.Get("/presence", chat.GetPresence)
+		r.Get("/dm", chat.GetDirectRooms)
+		r.Post("/dm/{userID}", chat.CreateDirectRoom)
+		r.Get("/invites", chat.GetUserInvites)
+		r.Post("/invites/{code}", chat.AcceptInvite)
+		r.Post("/rooms/{id}/invites", chat.CreateInvite)
+		r.Get("/rooms/{id}/invites", chat.GetRoomInvites)
+		r.Delete("/rooms/{id}/invites/{inviteID}", chat.RevokeInvite)
+		r.Get("/rooms/{id}/members", chat.GetMembers)
+		r.Put("/rooms/{id}/members/{userID}/role", chat.SetRole)
+		r.Post("/rooms/{id}/members/{userID}/kick", chat.Kick)
+		r.Get("/rooms/{id}/bans", chat.GetBans)
+		r.Put("/rooms/{id}/bans/{userID}", chat.Ban)
+		r.Delete("/rooms/{id}/bans/{userID}", chat.Unban)
+		r.Get("/rooms/{id}/mutes", chat.GetMutes)
+		r.Put("/rooms/{id}/mutes/{userID}", chat.Mute)
+		r.Delete("/rooms/{id}/mutes/{userID}", chat.Unmute)
 		r.Get("/rooms/{id}/clients", chat.GetClients)
-		r.HandleFunc("/rooms/{id}", chat.JoinRoom)
+		r.Get("/rooms/{id}/messages", chat.GetMessages)
+		r.Get("/rooms/{id}/threads/{rootID}", chat.GetThread)
+		r.Post("/rooms/{id}/read", chat.MarkRead)
+		r.Get("/rooms/{id}/reads", chat.GetReadReceipts)
+		r.Patch("/rooms/{id}/messages/{msgID}", chat.EditMessage)
+		r.Delete("/rooms/{id}/messages/{msgID}", chat.DeleteMessage)
+		r.Get("/rooms/{id}/pins", chat.GetPins)
+		r.Put("/rooms/{id}/pins/{msgID}", chat.PinMessage)
+		r.Delete("/rooms/{id}/pins/{msgID}", chat.UnpinMessage)
+		r.Post("/rooms/{id}/attachments", chat.UploadAttachment)
+		r.Get("/rooms/{id}/attachments/{attachmentID}", chat.GetAttachment)
+		r.Get("/rooms/{id}/attachments/{attachmentID}/thumbnail", chat.GetThumbnail)
+		r.Put("/rooms/{id}/messages/{msgID}/reactions/{emoji}", chat.AddReaction)
+		r.Delete("/rooms/{id}/messages/{msgID}/reactions/{emoji}", chat.RemoveReaction)
+		r.Post("/rooms/{id}/archive", chat.ArchiveRoom)
+		r.Delete("/rooms/{id}/archive", chat.UnarchiveRoom)
+		r.Patch("/rooms/{id}", chat.UpdateRoom)
+		r.Delete("/rooms/{id}", chat.DeleteRoom)
+		r.Get("/rooms/{id}", chat.JoinRoom)
 	})
 	return mux
 }
 
 func (s *Server) Run(ctx context.Context) error {
-	errResult := make(chan error)
+	errResult := make(chan error, 2)
 	go func() {
 		s.logger.Info(fmt.Sprintf("starting listening: %s", s.server.Addr))
 
@@ -90,6 +157,11 @@ func (s *Server) Run(ctx context.Context) error {
 		}
 	}()
 
+	go func() {
+		s.logger.Info(fmt.Sprintf("starting metrics listening: %s", s.metrics.Addr))
+		errResult <- s.metrics.ListenAndServe()
+	}()
+
 	go func() {
 		s.hub.Run(ctx)
 	}()
@@ -111,4 +183,9 @@ func (s *Server) Stop() {
 	if err != nil {
 		s.logger.Error("failed to shutdown HTTP Server", slog.String("error", err.Error()))
 	}
+
+	err = s.metrics.Shutdown(ctx)
+	if err != nil {
+		s.logger.Error("failed to shutdown metrics server", slog.String("error", err.Error()))
+	}
 }
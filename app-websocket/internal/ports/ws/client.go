@@ -3,59 +3,237 @@ package ws
 import (
 	"app-websocket/internal/domain"
 	"context"
+	"errors"
 	"github.com/gorilla/websocket"
 	"log/slog"
+	"net"
+	"sync/atomic"
 	"time"
 )
 
 type ServiceChatPusher interface {
 	PushMessage(ctx context.Context, msg *domain.Message) error
+	EditMessage(ctx context.Context, roomID, messageID, userID, content string) (*domain.Message, error)
+	DeleteMessage(ctx context.Context, roomID, messageID, userID string) (*domain.Message, error)
+	AddReaction(ctx context.Context, roomID, messageID, userID, emoji string) error
+	RemoveReaction(ctx context.Context, roomID, messageID, userID, emoji string) error
+	MarkRead(ctx context.Context, roomID, messageID string, user *domain.User) (*domain.ReadReceipt, error)
 	Unsubscribe(ctx context.Context, client *Client) error
 }
 
+type ServiceTyping interface {
+	Start(ctx context.Context, client *Client) error
+	Stop(ctx context.Context, client *Client) error
+	Forget(ctx context.Context, client *Client)
+}
+
+type ServicePresence interface {
+	Touch(ctx context.Context, client *Client)
+	SetStatus(ctx context.Context, client *Client, status domain.PresenceStatus) error
+}
+
+// CloseSlowClient is the close code of the connections dropped for not reading their frames
+// fast enough under the disconnect overflow policy.
+const CloseSlowClient = websocket.CloseTryAgainLater
+
+// Keepalive finds connections whose other end is gone without closing them, which would
+// otherwise keep the reader blocked and the user online for good.
+type Keepalive struct {
+	PingInterval time.Duration
+	PongWait     time.Duration // the connection is closed when nothing, pongs included, is read for this long
+	WriteTimeout time.Duration // the connection is closed when a frame takes longer to write
+}
+
 type Client struct {
-	Conn    *websocket.Conn
-	Message chan *Message
-	Logger  *slog.Logger
-	RoomID  string
-	User    *domain.User
-	Pusher  ServiceChatPusher
+	ID     string // of the connection, unique across instances
+	Conn   *websocket.Conn
+	Send   *Outbox
+	Logger *slog.Logger
+	RoomID string
+	User   *domain.User
+	Pusher ServiceChatPusher
+	Typing ServiceTyping
+	// Presence tracks the activity of the connection
+	Presence ServicePresence
+	// ThreadID limits live delivery to a single thread, the whole room is delivered when empty
+	ThreadID  string
+	Keepalive Keepalive
+
+	replayed map[string]struct{}       // messages sent in history, owned by the writer goroutine
+	muted    atomic.Pointer[time.Time] // end of the mute, zero for a mute until lifted, nil when not muted
+	archived atomic.Bool               // the room is read-only
+	deleted  atomic.Bool               // the room is deleted and the client is being disconnected
+}
+
+// Muted reports whether the user may read the room but not post to it.
+func (c *Client) Muted() bool {
+	until := c.muted.Load()
+	return until != nil && (until.IsZero() || time.Now().Before(*until))
+}
+
+// SetMuted mutes the user until the given time, until lifted when it is nil.
+func (c *Client) SetMuted(until *time.Time) {
+	if until == nil {
+		until = &time.Time{}
+	}
+
+	c.muted.Store(until)
+}
+
+func (c *Client) SetUnmuted() {
+	c.muted.Store(nil)
+}
+
+func (c *Client) Archived() bool {
+	return c.archived.Load()
+}
+
+func (c *Client) SetArchived(archived bool) {
+	c.archived.Store(archived)
+}
+
+// RoomDeleted reports whether the client is disconnected because its room was deleted.
+func (c *Client) RoomDeleted() bool {
+	return c.deleted.Load()
 }
 
 func (c *Client) WriteMessage() {
-	defer c.Close()
+	defer func() {
+		c.Send.Close()
+		c.Close()
+	}()
 
 	for {
-		message, ok := <-c.Message
+		frame, dropped, ok := c.Send.Pop()
 		if !ok {
 			return
 		}
 
-		err := c.Conn.WriteJSON(message)
+		if dropped > 0 {
+			err := c.writeGap(dropped)
+			if err != nil {
+				c.logWriteError(err)
+				return
+			}
+		}
+
+		if _, ok = c.replayed[frame.messageID]; ok {
+			delete(c.replayed, frame.messageID)
+			continue
+		}
+
+		// a write that failed or timed out leaves the connection broken, closing it lets the reader unsubscribe
+		err := c.WriteFrame(frame)
 		if err != nil {
-			c.Logger.Error("can not send message to client",
-				slog.String("Username", c.User.Nickname),
-				slog.String("RoomID", c.RoomID),
-				slog.String("ClientID", c.User.ID),
-				slog.String("error", err.Error()))
+			c.logWriteError(err)
+			return
+		}
+
+		if frame.closeAfter {
+			// closing the connection makes ReadMessage fail and unsubscribe the client
+			_ = c.Conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, string(frame.Type)), time.Now().Add(time.Second))
+			return
+		}
+	}
+}
+
+// WriteFrame writes the frame within the write timeout. Only the writer goroutine may call it
+// once it has started.
+func (c *Client) WriteFrame(frame *Envelope) error {
+	err := c.Conn.SetWriteDeadline(time.Now().Add(c.Keepalive.WriteTimeout))
+	if err != nil {
+		return err
+	}
+
+	return c.Conn.WriteJSON(frame)
+}
+
+// writeGap tells the client that frames were dropped because it did not keep up with them.
+func (c *Client) writeGap(dropped int) error {
+	frame, err := NewFrame(FrameGap, "", GapPayload{Dropped: dropped})
+	if err != nil {
+		return err
+	}
+
+	return c.WriteFrame(frame)
+}
+
+func (c *Client) logWriteError(err error) {
+	c.Logger.Error("can not send message to client",
+		slog.String("Username", c.User.Nickname),
+		slog.String("RoomID", c.RoomID),
+		slog.String("ClientID", c.User.ID),
+		slog.String("error", err.Error()))
+}
+
+// ping keeps asking the client for a pong until ctx is done. Control frames may be written
+// alongside the writer goroutine.
+func (c *Client) ping(ctx context.Context) {
+	ticker := time.NewTicker(c.Keepalive.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			err := c.Conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.Keepalive.WriteTimeout))
+			if err != nil {
+				// the reader notices the broken connection once it is closed
+				c.Close()
+				return
+			}
 		}
 	}
 }
 
+// extendReadDeadline gives the client another PongWait to show it is alive.
+func (c *Client) extendReadDeadline() error {
+	return c.Conn.SetReadDeadline(time.Now().Add(c.Keepalive.PongWait))
+}
+
+// ReadMessage reads the frames of the client until the connection is closed or stops answering
+// pings, and then unsubscribes the client, whichever way the connection ended.
 func (c *Client) ReadMessage(ctx context.Context) {
+	pingCtx, stopPing := context.WithCancel(ctx)
+
 	defer func() {
+		stopPing()
+		c.Typing.Forget(ctx, c)
+
 		err := c.Pusher.Unsubscribe(ctx, c)
 		if err != nil {
 			c.Logger.Error("failed to Unsubscribe from room:", slog.String("error", err.Error()))
 		}
 
+		// stops the writer goroutine
+		c.Send.Close()
 		c.Close()
 	}()
 
+	c.Conn.SetPongHandler(func(string) error {
+		return c.extendReadDeadline()
+	})
+
+	err := c.extendReadDeadline()
+	if err != nil {
+		return
+	}
+
+	go c.ping(pingCtx)
+
 	for {
 		_, m, err := c.Conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				c.Logger.Info("closing connection that stopped answering pings",
+					slog.String("username", c.User.Nickname),
+					slog.String("RoomID", c.RoomID),
+					slog.String("ClientID", c.User.ID))
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				c.Logger.Error("can not write message to client",
 					slog.String("username", c.User.Nickname),
 					slog.String("RoomID", c.RoomID),
@@ -65,24 +243,71 @@ func (c *Client) ReadMessage(ctx context.Context) {
 			break
 		}
 
-		msg := &domain.Message{
-			Content:     string(m),
-			RoomID:      c.RoomID,
-			Nickname:    c.User.Nickname,
-			UserID:      c.User.ID,
-			TimeCreated: time.Now(),
-		}
-
-		err = c.Pusher.PushMessage(ctx, msg)
+		// any frame shows the client is alive, not only a pong
+		err = c.extendReadDeadline()
 		if err != nil {
-			c.Logger.Error("failed to push message:", slog.String("error", err.Error()))
+			break
 		}
+
+		c.dispatch(ctx, m)
+	}
+}
+
+// MarkReplayed remembers messages already delivered in the history frame so that
+// their live copies are not sent twice. It must be called before WriteMessage starts.
+func (c *Client) MarkReplayed(messages []domain.Message) {
+	c.replayed = make(map[string]struct{}, len(messages))
+	for i := range messages {
+		c.replayed[messages[i].ID] = struct{}{}
+	}
+}
+
+// send queues a frame for the writer goroutine, which is the only one allowed to write to Conn.
+func (c *Client) send(frameType FrameType, id string, payload any) error {
+	frame, err := NewFrame(frameType, id, payload)
+	if err != nil {
+		return err
+	}
+
+	c.enqueue(frame)
+	return nil
+}
+
+// enqueue hands the frame to the writer goroutine without waiting for it. A client whose outbox
+// is full under the disconnect policy is disconnected, which unsubscribes it the usual way.
+func (c *Client) enqueue(frame *Envelope) {
+	if !c.Send.Push(frame) {
+		return
 	}
+
+	outboxDisconnects.Add(1)
+	c.Logger.Warn("disconnecting slow client",
+		slog.String("Username", c.User.Nickname),
+		slog.String("RoomID", c.RoomID),
+		slog.String("ClientID", c.User.ID))
+
+	// the writer may be stuck on the slow connection, so the close frame is written aside of it
+	go func() {
+		_ = c.Conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(CloseSlowClient, "client is too slow"), time.Now().Add(time.Second))
+		c.Close()
+	}()
 }
 
+func (c *Client) sendError(id string, protoErr *ProtocolError) {
+	err := c.send(FrameError, id, ErrorPayload{
+		Code:    protoErr.Code,
+		Message: protoErr.Message,
+	})
+	if err != nil {
+		c.Logger.Error("can not build error frame", slog.String("error", err.Error()))
+	}
+}
+
+// Close closes the connection; the reader, the writer and the pinger all do it on their way out.
 func (c *Client) Close() {
 	err := c.Conn.Close()
-	if err != nil {
+	if err != nil && !errors.Is(err, net.ErrClosed) {
 		c.Logger.Error("failed to close WebSocket connection:", slog.String("error", err.Error()))
 	}
 }
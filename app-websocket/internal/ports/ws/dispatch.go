@@ -0,0 +1,386 @@
+package ws
+
+import (
+	"app-websocket/internal/domain"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxContentLength mirrors messages.content VARCHAR(300).
+const maxContentLength = 300
+
+// maxAttachments is how many files a single message may carry.
+const maxAttachments = 10
+
+// ProtocolError is returned by frame handlers to report a problem back to the
+// client as an error frame instead of dropping the connection.
+type ProtocolError struct {
+	Code    string
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func newProtocolError(code, format string, args ...any) *ProtocolError {
+	return &ProtocolError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+type frameHandler func(c *Client, ctx context.Context, env *Envelope) error
+
+var frameHandlers = map[FrameType]frameHandler{
+	FrameMessage:  handleMessage,
+	FrameEdit:     handleEdit,
+	FrameDelete:   handleDelete,
+	FrameReaction: handleReaction,
+	FrameRead:     handleRead,
+	FrameTyping:   handleTyping,
+	FrameAck:      handleAck,
+	FramePing:     handlePing,
+	FramePresence: handlePresence,
+}
+
+// mutedFrames are the frames a muted user is not allowed to send that do not go through
+// the message service; messages, edits, deletes and reactions are checked by the service.
+var mutedFrames = map[FrameType]struct{}{
+	FrameTyping: {},
+}
+
+// passiveFrames are sent by clients on their own and do not tell that the user is active.
+var passiveFrames = map[FrameType]struct{}{
+	FrameAck:      {},
+	FramePing:     {},
+	FramePresence: {},
+}
+
+// archivedFrames are the frames that would change an archived room.
+var archivedFrames = map[FrameType]struct{}{
+	FrameMessage:  {},
+	FrameEdit:     {},
+	FrameDelete:   {},
+	FrameReaction: {},
+	FrameTyping:   {},
+}
+
+// dispatch decodes a raw inbound frame and routes it to its handler.
+// Any failure is reported to the client as an error frame.
+func (c *Client) dispatch(ctx context.Context, raw []byte) {
+	var env Envelope
+	err := json.Unmarshal(raw, &env)
+	if err != nil {
+		c.sendError("", newProtocolError(ErrCodeBadFrame, "frame is not a valid envelope"))
+		return
+	}
+
+	if env.Version != 0 && env.Version != ProtocolVersion {
+		c.sendError(env.ID, newProtocolError(ErrCodeUnsupportedVersion, "protocol version %d is not supported", env.Version))
+		return
+	}
+
+	handler, ok := frameHandlers[env.Type]
+	if !ok {
+		c.sendError(env.ID, newProtocolError(ErrCodeUnknownType, "unknown frame type %q", env.Type))
+		return
+	}
+
+	if _, ok = passiveFrames[env.Type]; !ok {
+		c.Presence.Touch(ctx, c)
+	}
+
+	if _, ok = archivedFrames[env.Type]; ok && c.Archived() {
+		c.sendError(env.ID, newProtocolError(ErrCodeForbidden, domain.ErrRoomArchived.Error()))
+		return
+	}
+
+	if _, ok = mutedFrames[env.Type]; ok && c.Muted() {
+		c.sendError(env.ID, newProtocolError(ErrCodeForbidden, domain.ErrMuted.Error()))
+		return
+	}
+
+	err = handler(c, ctx, &env)
+	if err != nil {
+		var protoErr *ProtocolError
+		if !errors.As(err, &protoErr) {
+			c.Logger.Error("failed to handle frame",
+				slog.String("type", string(env.Type)),
+				slog.String("RoomID", c.RoomID),
+				slog.String("ClientID", c.User.ID),
+				slog.String("error", err.Error()))
+
+			protoErr = newProtocolError(ErrCodeInternal, "failed to handle %s frame", env.Type)
+		}
+
+		c.sendError(env.ID, protoErr)
+	}
+}
+
+func decodePayload(env *Envelope, v any) error {
+	if len(env.Payload) == 0 {
+		return newProtocolError(ErrCodeInvalidPayload, "%s frame requires a payload", env.Type)
+	}
+
+	err := json.Unmarshal(env.Payload, v)
+	if err != nil {
+		return newProtocolError(ErrCodeInvalidPayload, "can not decode %s payload", env.Type)
+	}
+
+	return nil
+}
+
+// protocolError translates domain errors a client can act upon into error frames.
+func protocolError(err error) error {
+	switch {
+	case errors.Is(err, domain.ErrMessageNotFound):
+		return newProtocolError(ErrCodeNotFound, domain.ErrMessageNotFound.Error())
+	case errors.Is(err, domain.ErrNotMessageAuthor):
+		return newProtocolError(ErrCodeForbidden, domain.ErrNotMessageAuthor.Error())
+	case errors.Is(err, domain.ErrNotModerator):
+		return newProtocolError(ErrCodeForbidden, domain.ErrNotModerator.Error())
+	case errors.Is(err, domain.ErrSystemMessage):
+		return newProtocolError(ErrCodeForbidden, domain.ErrSystemMessage.Error())
+	case errors.Is(err, domain.ErrPermissionDenied):
+		return newProtocolError(ErrCodeForbidden, domain.ErrPermissionDenied.Error())
+	case errors.Is(err, domain.ErrMuted):
+		return newProtocolError(ErrCodeForbidden, domain.ErrMuted.Error())
+	case errors.Is(err, domain.ErrTooManyRequests):
+		return newProtocolError(ErrCodeRateLimited, domain.ErrTooManyRequests.Error())
+	case errors.Is(err, domain.ErrInvalidEmoji):
+		return newProtocolError(ErrCodeInvalidPayload, domain.ErrInvalidEmoji.Error())
+	case errors.Is(err, domain.ErrInvalidPresence):
+		return newProtocolError(ErrCodeInvalidPayload, domain.ErrInvalidPresence.Error())
+	case errors.Is(err, domain.ErrAttachmentNotFound):
+		return newProtocolError(ErrCodeNotFound, domain.ErrAttachmentNotFound.Error())
+	case errors.Is(err, domain.ErrTooManyAttachments):
+		return newProtocolError(ErrCodeInvalidPayload, domain.ErrTooManyAttachments.Error())
+	default:
+		return err
+	}
+}
+
+func validateContent(content string) (string, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return "", newProtocolError(ErrCodeInvalidPayload, "content is empty")
+	}
+
+	if utf8.RuneCountInString(content) > maxContentLength {
+		return "", newProtocolError(ErrCodeInvalidPayload, "content is longer than %d characters", maxContentLength)
+	}
+
+	return content, nil
+}
+
+func handleMessage(c *Client, ctx context.Context, env *Envelope) error {
+	var payload SendMessagePayload
+	err := decodePayload(env, &payload)
+	if err != nil {
+		return err
+	}
+
+	if len(payload.Attachments) > maxAttachments {
+		return protocolError(domain.ErrTooManyAttachments)
+	}
+
+	// a message with files needs no text
+	content := strings.TrimSpace(payload.Content)
+	if content != "" || len(payload.Attachments) == 0 {
+		content, err = validateContent(content)
+		if err != nil {
+			return err
+		}
+	}
+
+	// messages sent while following a thread are replies to its root by default
+	replyTo := payload.ReplyTo
+	if replyTo == "" {
+		replyTo = c.ThreadID
+	}
+
+	msg := &domain.Message{
+		Content:     content,
+		RoomID:      c.RoomID,
+		Nickname:    c.User.Nickname,
+		UserID:      c.User.ID,
+		TimeCreated: time.Now(),
+		ReplyTo:     replyTo,
+	}
+
+	// the service resolves the IDs into the uploaded files
+	for _, id := range payload.Attachments {
+		msg.Attachments = append(msg.Attachments, domain.Attachment{ID: id})
+	}
+
+	err = c.Pusher.PushMessage(ctx, msg)
+	if err != nil {
+		if errors.Is(err, domain.ErrMessageNotFound) {
+			return newProtocolError(ErrCodeNotFound, "message to reply to is not found")
+		}
+
+		if errors.Is(err, domain.ErrAttachmentNotFound) {
+			return protocolError(err)
+		}
+
+		return fmt.Errorf("ws.handleMessage: %w", err)
+	}
+
+	// a sent message ends typing without waiting for the expiry
+	err = c.Typing.Stop(ctx, c)
+	if err != nil {
+		return fmt.Errorf("ws.handleMessage: %w", err)
+	}
+
+	return c.send(FrameAck, env.ID, AckPayload{
+		MessageID: msg.ID,
+		Seq:       msg.Seq,
+	})
+}
+
+func handleEdit(c *Client, ctx context.Context, env *Envelope) error {
+	var payload EditMessagePayload
+	err := decodePayload(env, &payload)
+	if err != nil {
+		return err
+	}
+
+	if payload.MessageID == "" {
+		return newProtocolError(ErrCodeInvalidPayload, "message_id is required")
+	}
+
+	content, err := validateContent(payload.Content)
+	if err != nil {
+		return err
+	}
+
+	msg, err := c.Pusher.EditMessage(ctx, c.RoomID, payload.MessageID, c.User.ID, content)
+	if err != nil {
+		return protocolError(err)
+	}
+
+	return c.send(FrameAck, env.ID, AckPayload{
+		MessageID: msg.ID,
+		Seq:       msg.Seq,
+	})
+}
+
+func handleDelete(c *Client, ctx context.Context, env *Envelope) error {
+	var payload DeleteMessagePayload
+	err := decodePayload(env, &payload)
+	if err != nil {
+		return err
+	}
+
+	if payload.MessageID == "" {
+		return newProtocolError(ErrCodeInvalidPayload, "message_id is required")
+	}
+
+	msg, err := c.Pusher.DeleteMessage(ctx, c.RoomID, payload.MessageID, c.User.ID)
+	if err != nil {
+		return protocolError(err)
+	}
+
+	return c.send(FrameAck, env.ID, AckPayload{
+		MessageID: msg.ID,
+		Seq:       msg.Seq,
+	})
+}
+
+func handleReaction(c *Client, ctx context.Context, env *Envelope) error {
+	var payload ReactionPayload
+	err := decodePayload(env, &payload)
+	if err != nil {
+		return err
+	}
+
+	if payload.MessageID == "" {
+		return newProtocolError(ErrCodeInvalidPayload, "message_id is required")
+	}
+
+	switch payload.Action {
+	case ReactionAdd:
+		err = c.Pusher.AddReaction(ctx, c.RoomID, payload.MessageID, c.User.ID, payload.Emoji)
+	case ReactionRemove:
+		err = c.Pusher.RemoveReaction(ctx, c.RoomID, payload.MessageID, c.User.ID, payload.Emoji)
+	default:
+		return newProtocolError(ErrCodeInvalidPayload, "action must be %q or %q", ReactionAdd, ReactionRemove)
+	}
+	if err != nil {
+		return protocolError(err)
+	}
+
+	return c.send(FrameAck, env.ID, AckPayload{MessageID: payload.MessageID})
+}
+
+func handleRead(c *Client, ctx context.Context, env *Envelope) error {
+	var payload ReadPayload
+	err := decodePayload(env, &payload)
+	if err != nil {
+		return err
+	}
+
+	if payload.MessageID == "" {
+		return newProtocolError(ErrCodeInvalidPayload, "message_id is required")
+	}
+
+	receipt, err := c.Pusher.MarkRead(ctx, c.RoomID, payload.MessageID, c.User)
+	if err != nil {
+		return protocolError(err)
+	}
+
+	return c.send(FrameAck, env.ID, AckPayload{
+		MessageID: receipt.MessageID,
+		Seq:       receipt.Seq,
+	})
+}
+
+func handleTyping(c *Client, ctx context.Context, env *Envelope) error {
+	var payload TypingPayload
+	err := decodePayload(env, &payload)
+	if err != nil {
+		return err
+	}
+
+	if payload.Typing {
+		err = c.Typing.Start(ctx, c)
+	} else {
+		err = c.Typing.Stop(ctx, c)
+	}
+	if err != nil {
+		return protocolError(err)
+	}
+
+	return nil
+}
+
+func handleAck(_ *Client, _ context.Context, _ *Envelope) error {
+	// Clients acknowledge delivered frames; nothing is tracked for them yet.
+	return nil
+}
+
+func handlePing(c *Client, _ context.Context, env *Envelope) error {
+	return c.send(FramePong, env.ID, nil)
+}
+
+func handlePresence(c *Client, ctx context.Context, env *Envelope) error {
+	var payload PresencePayload
+	err := decodePayload(env, &payload)
+	if err != nil {
+		return err
+	}
+
+	err = c.Presence.SetStatus(ctx, c, payload.Status)
+	if err != nil {
+		return protocolError(err)
+	}
+
+	return nil
+}
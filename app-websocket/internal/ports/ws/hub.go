@@ -11,26 +11,29 @@ import (
 )
 
 type MessageConsumer interface {
-	Consume(ctx context.Context, handler domain.MessageHandler) error
+	Consume(ctx context.Context, handler domain.EventHandler) error
 }
 
-type ClientsService interface {
-	AddRoomClient(ctx context.Context, roomID string, user *domain.User) error
-	DeleteClient(ctx context.Context, roomID, userID string) error
+type SignalConsumer interface {
+	ConsumeSignals(ctx context.Context, handler domain.SignalHandler) error
 }
 
 type Hub struct {
 	logger   *slog.Logger
 	consumer MessageConsumer
-	clients  map[string]map[string]*Client // pull of connections in current server
+	signals  SignalConsumer
+	clients  map[string]map[string]*Client // connections in current server by room and connection ID
+	users    map[string]map[string]*Client // the same connections by user and connection ID, all devices of a user
 	mu       sync.Mutex
 }
 
-func NewHub(consumer MessageConsumer, logger *slog.Logger) *Hub {
+func NewHub(consumer MessageConsumer, signals SignalConsumer, logger *slog.Logger) *Hub {
 	return &Hub{
 		consumer: consumer,
+		signals:  signals,
 		logger:   logger,
 		clients:  make(map[string]map[string]*Client),
+		users:    make(map[string]map[string]*Client),
 	}
 }
 
@@ -41,15 +44,27 @@ func (h *Hub) AddConnection(client *Client) {
 	if h.clients[client.RoomID] == nil {
 		h.clients[client.RoomID] = make(map[string]*Client)
 	}
+	h.clients[client.RoomID][client.ID] = client
 
-	h.clients[client.RoomID][client.User.ID] = client
+	if h.users[client.User.ID] == nil {
+		h.users[client.User.ID] = make(map[string]*Client)
+	}
+	h.users[client.User.ID][client.ID] = client
 }
 
 func (h *Hub) DeleteConnection(client *Client) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	delete(h.clients[client.RoomID], client.User.ID)
+	delete(h.clients[client.RoomID], client.ID)
+	if len(h.clients[client.RoomID]) == 0 {
+		delete(h.clients, client.RoomID)
+	}
+
+	delete(h.users[client.User.ID], client.ID)
+	if len(h.users[client.User.ID]) == 0 {
+		delete(h.users, client.User.ID)
+	}
 }
 
 func (h *Hub) Run(ctx context.Context) {
@@ -57,24 +72,7 @@ func (h *Hub) Run(ctx context.Context) {
 
 	go func() {
 		for {
-			err := h.consumer.Consume(ctx, func(msg domain.Message) error {
-				h.mu.Lock()
-				connections := h.clients[msg.RoomID]
-				h.mu.Unlock()
-
-				for _, conn := range connections {
-					conn.Message <- &Message{
-						Content:     msg.Content,
-						TimeCreated: msg.TimeCreated,
-						RoomID:      msg.RoomID,
-						Username:    msg.Nickname,
-						UserID:      msg.UserID,
-					}
-				}
-
-				return nil
-			})
-
+			err := h.consumer.Consume(ctx, h.handleEvent)
 			if err != nil {
 				h.logger.Error("failed to consume message:", slog.String("error", err.Error()))
 
@@ -84,9 +82,292 @@ func (h *Hub) Run(ctx context.Context) {
 		}
 	}()
 
+	go func() {
+		signalsAttempt := 0
+		for {
+			err := h.signals.ConsumeSignals(ctx, h.handleSignal)
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				h.logger.Error("failed to consume signals:", slog.String("error", err.Error()))
+
+				time.Sleep(expBackoff(signalsAttempt))
+				signalsAttempt++
+			}
+		}
+	}()
+
 	<-ctx.Done()
 }
 
+// handleEvent turns a broker event into a frame for the clients of its room connected to this server.
+func (h *Hub) handleEvent(event domain.Event) error {
+	var frame *Envelope
+	var err error
+
+	switch event.Type {
+	case domain.EventMessageCreated:
+		if event.Message.IsSystem() {
+			frame, err = newMessageFrame(FrameSystem, event.Message)
+			break
+		}
+
+		err = h.notifyMentioned(event.Message)
+		if err != nil {
+			return err
+		}
+
+		frame, err = newMessageFrame(FrameMessage, event.Message)
+	case domain.EventMessageEdited:
+		frame, err = newMessageFrame(FrameEdit, event.Message)
+	case domain.EventMessageDeleted:
+		frame, err = newMessageFrame(FrameDelete, event.Message)
+	case domain.EventMessagePinned:
+		frame, err = newMessageFrame(FramePin, event.Message)
+	case domain.EventMessageUnpinned:
+		frame, err = newMessageFrame(FrameUnpin, event.Message)
+	case domain.EventReactionAdded, domain.EventReactionRemoved:
+		frame, err = newReactionFrame(event.Type, event.Reaction)
+	case domain.EventReadUpdated:
+		frame, err = NewFrame(FrameRead, "", NewReadPayload(event.Read))
+	case domain.EventRoomUpdated, domain.EventRoomArchived, domain.EventRoomUnarchived, domain.EventRoomDeleted:
+		return h.notifyRoom(&event)
+	default:
+		h.logger.Debug("skip event of unknown type", slog.String("type", string(event.Type)))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	h.broadcast(event.RoomID, frame)
+	return nil
+}
+
+// handleSignal turns an ephemeral signal into a frame for the clients of its room connected to this server.
+func (h *Hub) handleSignal(signal domain.Signal) error {
+	var frame *Envelope
+	var err error
+
+	switch signal.Type {
+	case domain.SignalTypingStarted, domain.SignalTypingStopped:
+		frame, err = newTypingFrame(&signal)
+	case domain.SignalRoleChanged:
+		frame, err = NewFrame(FrameRole, "", RolePayload{
+			UserID:   signal.UserID,
+			Nickname: signal.Nickname,
+			Role:     signal.Role,
+		})
+	case domain.SignalMemberKicked, domain.SignalMemberBanned, domain.SignalMemberMuted, domain.SignalMemberUnmuted:
+		return h.moderate(&signal)
+	default:
+		h.logger.Debug("skip signal of unknown type", slog.String("type", string(signal.Type)))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	h.broadcast(signal.RoomID, frame)
+	return nil
+}
+
+// notifyMentioned sends a mention frame to every connection of the mentioned users
+// on this server, whichever room or thread they are in.
+func (h *Hub) notifyMentioned(msg *domain.Message) error {
+	if len(msg.Mentions) == 0 {
+		return nil
+	}
+
+	frame, err := NewFrame(FrameMention, "", NewMessage(msg))
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	connections := make([]*Client, 0)
+	for _, userID := range msg.Mentions {
+		for _, conn := range h.users[userID] {
+			connections = append(connections, conn)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, conn := range connections {
+		conn.enqueue(frame)
+	}
+
+	return nil
+}
+
+var roomEvents = map[domain.EventType]string{
+	domain.EventRoomUpdated:    RoomUpdated,
+	domain.EventRoomArchived:   RoomArchived,
+	domain.EventRoomUnarchived: RoomUnarchived,
+	domain.EventRoomDeleted:    RoomDeleted,
+}
+
+// notifyRoom sends a room lifecycle event to every connection of the room on this server,
+// thread followers included, and applies it: archiving makes the connections read-only,
+// deleting closes them once the frame is written.
+func (h *Hub) notifyRoom(event *domain.Event) error {
+	if event.Room == nil {
+		h.logger.Debug("skip room event without room", slog.String("type", string(event.Type)))
+		return nil
+	}
+
+	frame, err := NewFrame(FrameRoom, "", RoomPayload{
+		Event: roomEvents[event.Type],
+		Room:  NewRoom(event.Room),
+	})
+	if err != nil {
+		return err
+	}
+	frame.closeAfter = event.Type == domain.EventRoomDeleted
+
+	h.mu.Lock()
+	connections := make([]*Client, 0, len(h.clients[event.RoomID]))
+	for _, conn := range h.clients[event.RoomID] {
+		connections = append(connections, conn)
+	}
+	h.mu.Unlock()
+
+	for _, conn := range connections {
+		switch event.Type {
+		case domain.EventRoomArchived:
+			conn.SetArchived(true)
+		case domain.EventRoomUnarchived:
+			conn.SetArchived(false)
+		case domain.EventRoomDeleted:
+			conn.deleted.Store(true)
+		}
+
+		conn.enqueue(frame)
+	}
+
+	return nil
+}
+
+// moderate announces a moderation action to the room and applies it to the connections
+// of its target on this server: kicked and banned users are disconnected once the frame
+// reaches them, muted ones stop being able to post.
+func (h *Hub) moderate(signal *domain.Signal) error {
+	payload := ModerationPayload{
+		UserID:   signal.UserID,
+		Nickname: signal.Nickname,
+		Reason:   signal.Reason,
+	}
+
+	var until *time.Time
+	if signal.TTL > 0 {
+		expiresAt := time.Now().Add(signal.TTL)
+		until = &expiresAt
+	}
+
+	switch signal.Type {
+	case domain.SignalMemberKicked:
+		payload.Action = ModerationKick
+	case domain.SignalMemberBanned:
+		payload.Action = ModerationBan
+		payload.ExpiresAt = until
+	case domain.SignalMemberMuted:
+		payload.Action = ModerationMute
+		payload.ExpiresAt = until
+	case domain.SignalMemberUnmuted:
+		payload.Action = ModerationUnmute
+	}
+
+	frame, err := NewFrame(FrameModeration, "", payload)
+	if err != nil {
+		return err
+	}
+	frame.senderID = signal.UserID
+
+	targetFrame := *frame
+	targetFrame.closeAfter = signal.Type == domain.SignalMemberKicked || signal.Type == domain.SignalMemberBanned
+
+	for _, target := range h.userConnections(signal.RoomID, signal.UserID) {
+		switch signal.Type {
+		case domain.SignalMemberMuted:
+			target.SetMuted(until)
+		case domain.SignalMemberUnmuted:
+			target.SetUnmuted()
+		}
+
+		target.enqueue(&targetFrame)
+	}
+
+	h.broadcast(signal.RoomID, frame)
+	return nil
+}
+
+// userConnections returns the connections of the user to the room on this server.
+func (h *Hub) userConnections(roomID, userID string) []*Client {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	connections := make([]*Client, 0, len(h.users[userID]))
+	for _, conn := range h.users[userID] {
+		if conn.RoomID == roomID {
+			connections = append(connections, conn)
+		}
+	}
+
+	return connections
+}
+
+func (h *Hub) broadcast(roomID string, frame *Envelope) {
+	h.mu.Lock()
+	connections := make([]*Client, 0, len(h.clients[roomID]))
+	for _, conn := range h.clients[roomID] {
+		connections = append(connections, conn)
+	}
+	h.mu.Unlock()
+
+	for _, conn := range connections {
+		if conn.ThreadID != "" && conn.ThreadID != frame.threadID {
+			continue
+		}
+
+		if frame.senderID != "" && conn.User.ID == frame.senderID {
+			continue
+		}
+
+		conn.enqueue(frame)
+	}
+}
+
+// OutboxDepth is how far behind the connections of the instance are.
+type OutboxDepth struct {
+	Connections int `json:"connections"`
+	Frames      int `json:"frames"`     // waiting in all outboxes
+	Max         int `json:"max"`        // waiting in the fullest outbox
+	Backlogged  int `json:"backlogged"` // connections with at least half of their outbox full
+}
+
+func (h *Hub) OutboxDepth() OutboxDepth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var depth OutboxDepth
+	for _, connections := range h.clients {
+		for _, conn := range connections {
+			frames := conn.Send.Len()
+
+			depth.Connections++
+			depth.Frames += frames
+			depth.Max = max(depth.Max, frames)
+			if frames*2 >= conn.Send.size {
+				depth.Backlogged++
+			}
+		}
+	}
+
+	return depth
+}
+
 func expBackoff(attempt int) time.Duration {
 	maxDelay := 30 * time.Second
 	backoff := math.Pow(2, float64(attempt))
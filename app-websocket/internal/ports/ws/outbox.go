@@ -0,0 +1,139 @@
+package ws
+
+import (
+	"expvar"
+	"sync"
+)
+
+// OverflowPolicy is what happens to a client whose outbox is full.
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest makes room for the new frame by dropping the oldest one; the client
+	// is told how many frames it missed with a gap frame.
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowDisconnect closes the connection of the client with CloseSlowClient.
+	OverflowDisconnect OverflowPolicy = "disconnect"
+)
+
+func (p OverflowPolicy) Valid() bool {
+	return p == OverflowDropOldest || p == OverflowDisconnect
+}
+
+// Counters of all outboxes of the instance, published by expvar; the depth is in Hub.OutboxDepth.
+var (
+	outboxDropped     = expvar.NewInt("ws_outbox_dropped")     // frames dropped from full outboxes
+	outboxDisconnects = expvar.NewInt("ws_outbox_disconnects") // clients disconnected for a full outbox
+)
+
+// Outbox is the queue of frames waiting to be written to a client. Pushing never blocks,
+// so a client that reads slowly only ever holds up itself.
+type Outbox struct {
+	size   int
+	policy OverflowPolicy
+
+	mu      sync.Mutex
+	frames  []*Envelope
+	dropped int  // frames dropped since the writer took the last one
+	closing bool // a frame closing the connection is queued, nothing after it is written
+	closed  bool
+	ready   chan struct{} // signalled when a frame is pushed or the outbox is closed
+}
+
+func NewOutbox(size int, policy OverflowPolicy) *Outbox {
+	return &Outbox{
+		size:   size,
+		policy: policy,
+		frames: make([]*Envelope, 0, size),
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// Push queues the frame. It reports whether the outbox was full under the disconnect policy,
+// in which case the outbox is closed and the client has to be disconnected.
+func (o *Outbox) Push(frame *Envelope) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed || o.closing {
+		return false
+	}
+
+	if len(o.frames) == o.size {
+		if o.policy == OverflowDisconnect {
+			o.close()
+			return true
+		}
+
+		o.frames[0] = nil
+		o.frames = o.frames[1:]
+		o.dropped++
+		outboxDropped.Add(1)
+	}
+
+	o.frames = append(o.frames, frame)
+	o.closing = frame.closeAfter
+
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+
+	return false
+}
+
+// Pop waits for the next frame and returns it with the number of frames dropped right before it.
+// It returns false once the outbox is closed.
+func (o *Outbox) Pop() (*Envelope, int, bool) {
+	for {
+		o.mu.Lock()
+		if o.closed {
+			o.mu.Unlock()
+			return nil, 0, false
+		}
+
+		if len(o.frames) > 0 {
+			frame := o.frames[0]
+			o.frames[0] = nil
+			o.frames = o.frames[1:]
+			dropped := o.dropped
+			o.dropped = 0
+			o.mu.Unlock()
+
+			return frame, dropped, true
+		}
+		o.mu.Unlock()
+
+		<-o.ready
+	}
+}
+
+// Len returns the number of frames waiting in the outbox.
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.frames)
+}
+
+// Close discards the waiting frames; frames pushed later are discarded as well.
+func (o *Outbox) Close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.close()
+}
+
+func (o *Outbox) close() {
+	if o.closed {
+		return
+	}
+
+	o.closed = true
+	o.frames = nil
+
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+}
@@ -1,11 +1,391 @@
 package ws
 
-import "time"
+import (
+	"app-websocket/internal/domain"
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
+// ProtocolVersion is the version of the envelope format spoken on /chat/rooms/{id}.
+const ProtocolVersion = 1
+
+type FrameType string
+
+const (
+	FrameMessage    FrameType = "message"
+	FrameEdit       FrameType = "edit"
+	FrameDelete     FrameType = "delete"
+	FrameTyping     FrameType = "typing"
+	FrameAck        FrameType = "ack"
+	FramePing       FrameType = "ping"
+	FramePong       FrameType = "pong"
+	FrameError      FrameType = "error"
+	FrameHistory    FrameType = "history"
+	FrameReaction   FrameType = "reaction"
+	FrameRead       FrameType = "read"
+	FrameMention    FrameType = "mention"
+	FrameRole       FrameType = "role"
+	FrameModeration FrameType = "moderation"
+	FrameRoom       FrameType = "room"
+	FramePin        FrameType = "pin"
+	FrameUnpin      FrameType = "unpin"
+	FramePresence   FrameType = "presence"
+	FrameSystem     FrameType = "system"
+	FrameGap        FrameType = "gap"
+)
+
+const (
+	ErrCodeBadFrame           = "bad_frame"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeInvalidPayload     = "invalid_payload"
+	ErrCodeNotFound           = "not_found"
+	ErrCodeForbidden          = "forbidden"
+	ErrCodeRateLimited        = "rate_limited"
+	ErrCodeInternal           = "internal_error"
+)
+
+// Envelope is the single shape of every frame sent in both directions.
+// ID is chosen by the sender and echoed back in ack and error frames.
+type Envelope struct {
+	Version int             `json:"v"`
+	Type    FrameType       `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+
+	messageID  string // set on message frames to skip the ones already sent in history
+	threadID   string // thread of the message the frame carries, the ID of the message itself for roots
+	senderID   string // user the frame is about, set on frames not echoed back to that user
+	closeAfter bool   // the connection is closed once the frame is written
+}
+
+// Message is a message of a user, or with Kind "system" an event of the room told by the server:
+// Event says what happened to the user of the message, and there is no content.
 type Message struct {
-	Content     string    `json:"content"`
-	RoomID      string    `json:"room_id"`
-	Username    string    `json:"nickname"`
-	UserID      string    `json:"user_id"`
-	TimeCreated time.Time `json:"time_created"`
+	ID          string             `json:"id"`
+	Seq         int64              `json:"seq"`
+	Kind        domain.MessageKind `json:"kind"`
+	Event       domain.SystemEvent `json:"event,omitempty"`
+	Content     string             `json:"content"`
+	RoomID      string             `json:"room_id"`
+	Username    string             `json:"nickname"`
+	UserID      string             `json:"user_id"`
+	TimeCreated time.Time          `json:"time_created"`
+	EditedAt    *time.Time         `json:"edited_at,omitempty"`
+	DeletedBy   string             `json:"deleted_by,omitempty"`
+	DeletedAt   *time.Time         `json:"deleted_at,omitempty"`
+	ReplyTo     string             `json:"reply_to,omitempty"`
+	ThreadID    string             `json:"thread_id,omitempty"`
+	ReplyCount  int                `json:"reply_count,omitempty"`
+	LastReplyAt *time.Time         `json:"last_reply_at,omitempty"`
+	Reactions   map[string]int     `json:"reactions,omitempty"`
+	Mentions    []string           `json:"mentions,omitempty"`
+	PinnedBy    string             `json:"pinned_by,omitempty"`
+	PinnedAt    *time.Time         `json:"pinned_at,omitempty"`
+	Attachments []Attachment       `json:"attachments,omitempty"`
+}
+
+// Attachment describes a file sent with a message. URL and ThumbnailURL are paths of the API;
+// they take the access token like any other request, or as the 'access_token' query param.
+type Attachment struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	ContentType  string `json:"content_type"`
+	Size         int64  `json:"size"`
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+}
+
+// SendMessagePayload carries the text of a message and the IDs of the files uploaded for it.
+// The content may be empty when the message has attachments.
+type SendMessagePayload struct {
+	Content     string   `json:"content"`
+	ReplyTo     string   `json:"reply_to,omitempty"`
+	Attachments []string `json:"attachments,omitempty"`
+}
+
+type EditMessagePayload struct {
+	MessageID string `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+// AckPayload confirms an accepted message frame with the identity the server assigned to it.
+type AckPayload struct {
+	MessageID string `json:"message_id"`
+	Seq       int64  `json:"seq"`
+}
+
+type DeleteMessagePayload struct {
+	MessageID string `json:"message_id"`
+}
+
+const (
+	ReactionAdd    = "add"
+	ReactionRemove = "remove"
+)
+
+// ReactionPayload adds or removes an emoji. The server fills UserID when it
+// fans the change out to the room.
+type ReactionPayload struct {
+	MessageID string `json:"message_id"`
+	Emoji     string `json:"emoji"`
+	Action    string `json:"action"`
+	UserID    string `json:"user_id,omitempty"`
+}
+
+// ReadPayload is sent by a client to mark messages up to MessageID as read. The server
+// fans the new read position out to the room with the rest of the fields filled in.
+type ReadPayload struct {
+	MessageID string     `json:"message_id"`
+	Seq       int64      `json:"seq,omitempty"`
+	UserID    string     `json:"user_id,omitempty"`
+	Nickname  string     `json:"nickname,omitempty"`
+	TimeRead  *time.Time `json:"time_read,omitempty"`
+}
+
+// PresencePayload is sent by a client to tell whether its user is away, for example when
+// the tab is hidden, or back online.
+type PresencePayload struct {
+	Status domain.PresenceStatus `json:"status"`
+}
+
+// TypingPayload is sent by a client to start or stop typing. The server fans it out with
+// the user filled in; ExpiresIn tells how long to show a start if no stop arrives.
+type TypingPayload struct {
+	Typing    bool   `json:"typing"`
+	UserID    string `json:"user_id,omitempty"`
+	Nickname  string `json:"nickname,omitempty"`
+	ExpiresIn int64  `json:"expires_in_ms,omitempty"`
+}
+
+// RolePayload announces the new role of a user in the room.
+type RolePayload struct {
+	UserID   string      `json:"user_id"`
+	Nickname string      `json:"nickname"`
+	Role     domain.Role `json:"role"`
+}
+
+const (
+	ModerationKick   = "kick"
+	ModerationBan    = "ban"
+	ModerationMute   = "mute"
+	ModerationUnmute = "unmute"
+)
+
+// ModerationPayload announces a moderation action taken on a user of the room.
+// ExpiresAt is set on bans and mutes that are not for good.
+type ModerationPayload struct {
+	Action    string     `json:"action"`
+	UserID    string     `json:"user_id"`
+	Nickname  string     `json:"nickname"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type Room struct {
+	ID           string     `json:"id"`
+	Name         string     `json:"name"`
+	Kind         string     `json:"kind"`
+	Visibility   string     `json:"visibility"`
+	Topic        string     `json:"topic,omitempty"`
+	Description  string     `json:"description,omitempty"`
+	AvatarURL    string     `json:"avatar_url,omitempty"`
+	ArchivedAt   *time.Time `json:"archived_at,omitempty"`
+	SystemEvents bool       `json:"system_events"`
+}
+
+const (
+	RoomUpdated    = "updated"
+	RoomArchived   = "archived"
+	RoomUnarchived = "unarchived"
+	RoomDeleted    = "deleted"
+)
+
+// RoomPayload announces a change of the room itself. After a deleted one the server closes the connection.
+type RoomPayload struct {
+	Event string `json:"event"`
+	Room  Room   `json:"room"`
+}
+
+func NewRoom(room *domain.Room) Room {
+	return Room{
+		ID:           room.ID,
+		Name:         room.Name,
+		Kind:         room.Kind,
+		Visibility:   room.Visibility,
+		Topic:        room.Topic,
+		Description:  room.Description,
+		AvatarURL:    room.AvatarURL,
+		ArchivedAt:   room.ArchivedAt,
+		SystemEvents: room.SystemEvents,
+	}
+}
+
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// HistoryPayload holds messages newest first. HasMore is set when a resumed client
+// missed more messages than were replayed.
+type HistoryPayload struct {
+	Messages []Message `json:"messages"`
+	HasMore  bool      `json:"has_more"`
+}
+
+// GapPayload tells a client that it read too slowly and Dropped frames before the next one
+// were never sent; it catches up by reconnecting with 'since' or reading the history.
+type GapPayload struct {
+	Dropped int `json:"dropped"`
+}
+
+func NewFrame(frameType FrameType, id string, payload any) (*Envelope, error) {
+	env := &Envelope{
+		Version: ProtocolVersion,
+		Type:    frameType,
+		ID:      id,
+	}
+
+	if payload != nil {
+		buf, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		env.Payload = buf
+	}
+
+	return env, nil
+}
+
+func NewMessage(msg *domain.Message) Message {
+	var attachments []Attachment
+	for i := range msg.Attachments {
+		attachments = append(attachments, NewAttachment(&msg.Attachments[i]))
+	}
+
+	kind := msg.Kind
+	if kind == "" {
+		kind = domain.MessageKindUser
+	}
+
+	return Message{
+		ID:          msg.ID,
+		Seq:         msg.Seq,
+		Kind:        kind,
+		Event:       msg.Event,
+		Content:     msg.Content,
+		TimeCreated: msg.TimeCreated,
+		RoomID:      msg.RoomID,
+		Username:    msg.Nickname,
+		UserID:      msg.UserID,
+		EditedAt:    msg.EditedAt,
+		DeletedBy:   msg.DeletedBy,
+		DeletedAt:   msg.DeletedAt,
+		ReplyTo:     msg.ReplyTo,
+		ThreadID:    msg.ThreadID,
+		ReplyCount:  msg.ReplyCount,
+		LastReplyAt: msg.LastReplyAt,
+		Reactions:   msg.Reactions,
+		Mentions:    msg.Mentions,
+		PinnedBy:    msg.PinnedBy,
+		PinnedAt:    msg.PinnedAt,
+		Attachments: attachments,
+	}
+}
+
+func NewAttachment(attachment *domain.Attachment) Attachment {
+	res := Attachment{
+		ID:          attachment.ID,
+		Name:        attachment.Name,
+		ContentType: attachment.ContentType,
+		Size:        attachment.Size,
+		Width:       attachment.Width,
+		Height:      attachment.Height,
+		URL:         fmt.Sprintf("/chat/rooms/%s/attachments/%s", attachment.RoomID, attachment.ID),
+	}
+
+	if attachment.ThumbnailKey != "" {
+		res.ThumbnailURL = res.URL + "/thumbnail"
+	}
+
+	return res
+}
+
+// newMessageFrame builds a frame carrying the message, remembering which message and
+// thread it belongs to for the delivery to the clients.
+func newMessageFrame(frameType FrameType, msg *domain.Message) (*Envelope, error) {
+	frame, err := NewFrame(frameType, "", NewMessage(msg))
+	if err != nil {
+		return nil, err
+	}
+
+	// new messages may also be in the history the client got on connecting
+	if frameType == FrameMessage || frameType == FrameSystem {
+		frame.messageID = msg.ID
+	}
+
+	frame.threadID = msg.ThreadID
+	if frame.threadID == "" {
+		frame.threadID = msg.ID
+	}
+
+	return frame, nil
+}
+
+func newReactionFrame(eventType domain.EventType, reaction *domain.Reaction) (*Envelope, error) {
+	action := ReactionAdd
+	if eventType == domain.EventReactionRemoved {
+		action = ReactionRemove
+	}
+
+	frame, err := NewFrame(FrameReaction, "", ReactionPayload{
+		MessageID: reaction.MessageID,
+		Emoji:     reaction.Emoji,
+		Action:    action,
+		UserID:    reaction.UserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	frame.threadID = reaction.ThreadID
+	if frame.threadID == "" {
+		frame.threadID = reaction.MessageID
+	}
+
+	return frame, nil
+}
+
+func newTypingFrame(signal *domain.Signal) (*Envelope, error) {
+	payload := TypingPayload{
+		Typing:   signal.Type == domain.SignalTypingStarted,
+		UserID:   signal.UserID,
+		Nickname: signal.Nickname,
+	}
+	if payload.Typing {
+		payload.ExpiresIn = signal.TTL.Milliseconds()
+	}
+
+	frame, err := NewFrame(FrameTyping, "", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	frame.threadID = signal.ThreadID
+	frame.senderID = signal.UserID
+	return frame, nil
+}
+
+func NewReadPayload(receipt *domain.ReadReceipt) ReadPayload {
+	return ReadPayload{
+		MessageID: receipt.MessageID,
+		Seq:       receipt.Seq,
+		UserID:    receipt.UserID,
+		Nickname:  receipt.Nickname,
+		TimeRead:  &receipt.TimeRead,
+	}
 }
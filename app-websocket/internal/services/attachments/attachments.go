@@ -0,0 +1,160 @@
+package attachments
+
+import (
+	"app-websocket/internal/config"
+	"app-websocket/internal/domain"
+	"app-websocket/pkg/ulid"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// sniffLen is how many leading bytes http.DetectContentType looks at.
+const sniffLen = 512
+
+// maxNameLength mirrors attachments.name VARCHAR(255).
+const maxNameLength = 255
+
+type AttachmentStorage interface {
+	CreateAttachment(ctx context.Context, attachment *domain.Attachment) error
+	GetAttachment(ctx context.Context, roomID, attachmentID string) (*domain.Attachment, error)
+}
+
+type BlobStorage interface {
+	Put(ctx context.Context, key, contentType string, r io.Reader, size int64) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// AttachmentService keeps the files uploaded to rooms. The type of a file is detected from its
+// content rather than trusted from the client, and only the configured types are accepted.
+type AttachmentService struct {
+	storage      AttachmentStorage
+	blobs        BlobStorage
+	maxSize      int64
+	allowedTypes []string
+}
+
+func New(config *config.AttachmentsConfig, storage AttachmentStorage, blobs BlobStorage) *AttachmentService {
+	return &AttachmentService{
+		storage:      storage,
+		blobs:        blobs,
+		maxSize:      config.MaxSize,
+		allowedTypes: config.AllowedTypes,
+	}
+}
+
+// MaxSize is the largest file in bytes Upload accepts.
+func (s *AttachmentService) MaxSize() int64 {
+	return s.maxSize
+}
+
+// Upload stores the file of the given size for the user to send to the room later.
+func (s *AttachmentService) Upload(ctx context.Context, roomID, userID, name string, file io.ReadSeeker, size int64) (*domain.Attachment, error) {
+	if size > s.maxSize {
+		return nil, domain.ErrAttachmentTooLarge
+	}
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("service.AttachmentService.Upload: %w", err)
+	}
+
+	contentType := http.DetectContentType(head[:n])
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !slices.Contains(s.allowedTypes, mediaType) {
+		return nil, domain.ErrAttachmentType
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("service.AttachmentService.Upload: %w", err)
+	}
+
+	id := ulid.New()
+	attachment := &domain.Attachment{
+		ID:          id,
+		RoomID:      roomID,
+		UploadedBy:  userID,
+		Name:        cleanName(name),
+		ContentType: contentType,
+		Size:        size,
+		BlobKey:     "rooms/" + roomID + "/" + id,
+		TimeCreated: time.Now(),
+	}
+
+	err = s.blobs.Put(ctx, attachment.BlobKey, contentType, file, size)
+	if err != nil {
+		return nil, fmt.Errorf("service.AttachmentService.Upload: %w", err)
+	}
+
+	err = s.storage.CreateAttachment(ctx, attachment)
+	if err != nil {
+		_ = s.blobs.Delete(ctx, attachment.BlobKey)
+		return nil, fmt.Errorf("service.AttachmentService.Upload: %w", err)
+	}
+
+	return attachment, nil
+}
+
+// Open returns the attachment with its content, or with the content of its thumbnail.
+// Attachments that are not sent yet are seen by their uploader only.
+func (s *AttachmentService) Open(ctx context.Context, roomID, attachmentID, userID string, thumbnail bool) (*domain.Attachment, io.ReadCloser, error) {
+	attachment, err := s.storage.GetAttachment(ctx, roomID, attachmentID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("service.AttachmentService.Open: %w", err)
+	}
+
+	if attachment.MessageID == "" && attachment.UploadedBy != userID {
+		return nil, nil, domain.ErrAttachmentNotFound
+	}
+
+	key := attachment.BlobKey
+	if thumbnail {
+		if attachment.ThumbnailKey == "" {
+			return nil, nil, domain.ErrAttachmentNotFound
+		}
+
+		key = attachment.ThumbnailKey
+	}
+
+	content, err := s.blobs.Get(ctx, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("service.AttachmentService.Open: %w", err)
+	}
+
+	return attachment, content, nil
+}
+
+// cleanName keeps the base name of the file without control characters, so it is safe to show and
+// to put into a Content-Disposition header.
+func cleanName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+
+		return r
+	}, name))
+
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+
+	if utf8.RuneCountInString(name) > maxNameLength {
+		name = string([]rune(name)[:maxNameLength])
+	}
+
+	return name
+}
@@ -4,30 +4,42 @@ import (
 	"app-websocket/internal/config"
 	"app-websocket/internal/domain"
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
 )
 
 type ChatCache interface {
 	GetLastMessagesFromRoom(ctx context.Context, roomID string, count int) ([]domain.Message, error)
-	GetRoomClients(ctx context.Context, roomID string) ([]domain.User, error)
-	AddRoomClient(ctx context.Context, roomID string, user *domain.User) error
-	DeleteClient(ctx context.Context, roomID string, user *domain.User) error
+	GetCachedMessages(ctx context.Context, roomID string) ([]domain.Message, error)
+	GetReadReceipts(ctx context.Context, roomID string) ([]domain.ReadReceipt, error)
 }
 
 type ChatPersistentStorage interface {
 	GetLastMessagesFromRoom(ctx context.Context, roomID string, count int) ([]domain.Message, error)
+	GetMessagesSince(ctx context.Context, roomID string, since int64, count int) ([]domain.Message, error)
+	GetMessagesPage(ctx context.Context, roomID string, page *domain.MessagesPage) ([]domain.Message, error)
+	GetThreadMessagesSince(ctx context.Context, roomID, rootID string, since int64, count int) ([]domain.Message, error)
+	GetMessage(ctx context.Context, roomID, messageID string) (*domain.Message, error)
+	GetReadReceipts(ctx context.Context, roomID string) ([]domain.ReadReceipt, error)
+	GetUnreadMentions(ctx context.Context, userID string, count int) ([]domain.Message, error)
+	SearchMessages(ctx context.Context, search *domain.MessageSearch) ([]domain.SearchHit, error)
+	GetPinnedMessages(ctx context.Context, roomID string) ([]domain.Message, error)
 }
 
 type ChatCacheProvider struct {
 	cache             ChatCache
 	persistentStorage ChatPersistentStorage
 	countMessagesGet  int
+	replayLimit       int
 }
 
 func New(config *config.ChatConfig, cache ChatCache, persistentStorage ChatPersistentStorage) *ChatCacheProvider {
 	return &ChatCacheProvider{
 		cache:             cache,
 		countMessagesGet:  config.CountMessagesGet,
+		replayLimit:       config.ReplayLimit,
 		persistentStorage: persistentStorage,
 	}
 }
@@ -46,14 +58,265 @@ func (c *ChatCacheProvider) GetLastMessagesFromRoom(ctx context.Context, roomID
 	return messages, nil
 }
 
-func (c *ChatCacheProvider) GetRoomClients(ctx context.Context, roomID string) ([]domain.User, error) {
-	return c.cache.GetRoomClients(ctx, roomID)
+// GetMessagesSince returns the messages of the room with sequence numbers greater than since,
+// newest first. The cache is used only when it holds every one of them, otherwise the window
+// is read from persistent storage. hasMore reports that more messages were missed than the
+// replay limit allows to send.
+func (c *ChatCacheProvider) GetMessagesSince(ctx context.Context, roomID string, since int64) ([]domain.Message, bool, error) {
+	cached, err := c.cache.GetLastMessagesFromRoom(ctx, roomID, c.replayLimit)
+	if err == nil {
+		missed, ok := missedFromCache(cached, since)
+		if ok {
+			return missed, false, nil
+		}
+	}
+
+	messages, err := c.persistentStorage.GetMessagesSince(ctx, roomID, since, c.replayLimit+1)
+	if err != nil {
+		return nil, false, fmt.Errorf("services.message_cache.GetMessagesSince: %w", err)
+	}
+
+	if len(messages) > c.replayLimit {
+		return messages[:c.replayLimit], true, nil
+	}
+
+	return messages, false, nil
 }
 
-func (c *ChatCacheProvider) AddRoomClient(ctx context.Context, roomID string, user *domain.User) error {
-	return c.cache.AddRoomClient(ctx, roomID, user)
+// missedFromCache picks the cached messages after since, newest first. Sequence numbers are taken
+// before the messages travel through the broker, so the cache does not keep them in order and may
+// lack some of them yet; it reports false unless the messages make up the whole run from since+1
+// and the cache reaches back to since.
+func missedFromCache(cached []domain.Message, since int64) ([]domain.Message, bool) {
+	missed := make([]domain.Message, 0)
+	reachesSince := false
+	for _, msg := range cached {
+		switch {
+		case msg.Seq > since:
+			missed = append(missed, msg)
+		case msg.Seq > 0:
+			reachesSince = true
+		}
+	}
+
+	sort.Slice(missed, func(i, j int) bool {
+		return missed[i].Seq > missed[j].Seq
+	})
+
+	for i := range missed {
+		if missed[i].Seq != since+int64(len(missed)-i) {
+			return nil, false
+		}
+	}
+
+	return missed, reachesSince
+}
+
+// GetMessagesPage returns a page of the room history newest first and reports whether
+// more messages exist beyond the page in the direction it was requested.
+func (c *ChatCacheProvider) GetMessagesPage(ctx context.Context, roomID string, page *domain.MessagesPage) ([]domain.Message, bool, error) {
+	// ask for one extra message to learn whether there is more
+	probe := *page
+	probe.Limit = page.Limit + 1
+
+	// the cache keeps the room history only, threads are always read from persistent storage
+	var messages []domain.Message
+	ok := false
+	if page.ThreadID == "" {
+		messages, ok = c.pageFromCache(ctx, roomID, &probe)
+	}
+	if !ok {
+		var err error
+		messages, err = c.persistentStorage.GetMessagesPage(ctx, roomID, &probe)
+		if err != nil {
+			return nil, false, fmt.Errorf("services.message_cache.GetMessagesPage: %w", err)
+		}
+	}
+
+	if len(messages) <= page.Limit {
+		return messages, false, nil
+	}
+
+	if isForward(page) {
+		return messages[1:], true, nil
+	}
+
+	return messages[:page.Limit], true, nil
 }
 
-func (c *ChatCacheProvider) DeleteClient(ctx context.Context, roomID string, user *domain.User) error {
-	return c.cache.DeleteClient(ctx, roomID, user)
+// GetPinnedMessages returns the pinned messages of the room, the latest pinned first.
+// Pins are rare and read on joining a room, so they are not cached.
+func (c *ChatCacheProvider) GetPinnedMessages(ctx context.Context, roomID string) ([]domain.Message, error) {
+	messages, err := c.persistentStorage.GetPinnedMessages(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("services.message_cache.GetPinnedMessages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// SearchMessages returns a page of the messages matching the search newest first and reports
+// whether more of them exist beyond the page in the direction it was requested.
+func (c *ChatCacheProvider) SearchMessages(ctx context.Context, search *domain.MessageSearch) ([]domain.SearchHit, bool, error) {
+	// ask for one extra message to learn whether there is more
+	probe := *search
+	probe.Page.Limit = search.Page.Limit + 1
+
+	hits, err := c.persistentStorage.SearchMessages(ctx, &probe)
+	if err != nil {
+		return nil, false, fmt.Errorf("services.message_cache.SearchMessages: %w", err)
+	}
+
+	if len(hits) <= search.Page.Limit {
+		return hits, false, nil
+	}
+
+	if isForward(&search.Page) {
+		return hits[1:], true, nil
+	}
+
+	return hits[:search.Page.Limit], true, nil
+}
+
+// GetThreadMessagesSince returns the root and the replies of the thread with sequence
+// numbers greater than since, newest first. A fresh subscriber (since is 0) gets as many
+// messages as a room subscriber does, a resumed one up to the replay limit.
+func (c *ChatCacheProvider) GetThreadMessagesSince(ctx context.Context, roomID, rootID string, since int64) ([]domain.Message, bool, error) {
+	limit := c.replayLimit
+	if since == 0 {
+		limit = c.countMessagesGet
+	}
+
+	messages, err := c.persistentStorage.GetThreadMessagesSince(ctx, roomID, rootID, since, limit+1)
+	if err != nil {
+		return nil, false, fmt.Errorf("services.message_cache.GetThreadMessagesSince: %w", err)
+	}
+
+	if len(messages) > limit {
+		return messages[:limit], true, nil
+	}
+
+	return messages, false, nil
+}
+
+// GetThreadRoot returns the root message of the thread.
+func (c *ChatCacheProvider) GetThreadRoot(ctx context.Context, roomID, rootID string) (*domain.Message, error) {
+	root, err := c.persistentStorage.GetMessage(ctx, roomID, rootID)
+	if err != nil {
+		if errors.Is(err, domain.ErrMessageNotFound) {
+			return nil, domain.ErrThreadNotFound
+		}
+
+		return nil, fmt.Errorf("services.message_cache.GetThreadRoot: %w", err)
+	}
+
+	if root.ThreadID != "" {
+		return nil, domain.ErrThreadNotFound
+	}
+
+	return root, nil
+}
+
+// pageFromCache serves the page from the cached tail of the history. The cache is
+// trusted only when it has no gaps in sequence numbers and either reaches back to the
+// lower edge of the window or holds enough messages inside it.
+func (c *ChatCacheProvider) pageFromCache(ctx context.Context, roomID string, page *domain.MessagesPage) ([]domain.Message, bool) {
+	cached, err := c.cache.GetCachedMessages(ctx, roomID)
+	if err != nil || len(cached) == 0 || !isContiguous(cached) {
+		return nil, false
+	}
+
+	sort.Slice(cached, func(i, j int) bool {
+		return domain.NewMessageCursor(&cached[i]).Compare(domain.NewMessageCursor(&cached[j])) > 0
+	})
+
+	window := make([]domain.Message, 0, len(cached))
+	for i := range cached {
+		cursor := domain.NewMessageCursor(&cached[i])
+		if page.Before != nil && cursor.Compare(*page.Before) >= 0 {
+			continue
+		}
+
+		if page.After != nil && cursor.Compare(*page.After) <= 0 {
+			continue
+		}
+
+		if page.ExcludeSystem && cached[i].IsSystem() {
+			continue
+		}
+
+		window = append(window, cached[i])
+	}
+
+	oldest := domain.NewMessageCursor(&cached[len(cached)-1])
+	reachesAfter := page.After != nil && oldest.Compare(*page.After) <= 0
+
+	if isForward(page) {
+		if !reachesAfter {
+			return nil, false
+		}
+
+		if len(window) > page.Limit {
+			window = window[len(window)-page.Limit:]
+		}
+
+		return window, true
+	}
+
+	if len(window) < page.Limit && !reachesAfter {
+		return nil, false
+	}
+
+	if len(window) > page.Limit {
+		window = window[:page.Limit]
+	}
+
+	return window, true
+}
+
+// isForward reports whether the page continues the history after a cursor.
+func isForward(page *domain.MessagesPage) bool {
+	return page.After != nil && page.Before == nil
+}
+
+func isContiguous(messages []domain.Message) bool {
+	seqs := make([]int64, 0, len(messages))
+	for i := range messages {
+		seqs = append(seqs, messages[i].Seq)
+	}
+	slices.Sort(seqs)
+
+	for i := 1; i < len(seqs); i++ {
+		if seqs[i] != seqs[i-1]+1 {
+			return false
+		}
+	}
+
+	return len(seqs) > 0 && seqs[0] > 0
+}
+
+// GetReadReceipts returns the read positions of the room members. An empty cache may
+// just be cold, so persistent storage is asked in that case too.
+func (c *ChatCacheProvider) GetReadReceipts(ctx context.Context, roomID string) ([]domain.ReadReceipt, error) {
+	receipts, err := c.cache.GetReadReceipts(ctx, roomID)
+	if err == nil && len(receipts) > 0 {
+		return receipts, nil
+	}
+
+	receipts, err = c.persistentStorage.GetReadReceipts(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("services.message_cache.GetReadReceipts: %w", err)
+	}
+
+	return receipts, nil
+}
+
+// GetUnreadMentions returns up to count messages mentioning the user that the user has not read yet.
+func (c *ChatCacheProvider) GetUnreadMentions(ctx context.Context, userID string, count int) ([]domain.Message, error) {
+	messages, err := c.persistentStorage.GetUnreadMentions(ctx, userID, count)
+	if err != nil {
+		return nil, fmt.Errorf("services.message_cache.GetUnreadMentions: %w", err)
+	}
+
+	return messages, nil
 }
@@ -3,75 +3,638 @@ package message_online
 import (
 	"app-websocket/internal/domain"
 	"app-websocket/internal/ports/ws"
+	"app-websocket/pkg/ulid"
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
+	"unicode"
 )
 
 type MessagePusher interface {
-	Produce(msg *domain.Message) error
+	Produce(event *domain.Event) error
 }
 
 type MessageConsumer interface {
-	Consume(ctx context.Context, handler domain.MessageHandler) error
+	Consume(ctx context.Context, handler domain.EventHandler) error
 }
 
-type RoomClientsStorage interface {
-	AddRoomClient(ctx context.Context, roomID string, user *domain.User) error
-	DeleteClient(ctx context.Context, roomID string, user *domain.User) error
+// PresenceTracker keeps the presence records of the connections.
+type PresenceTracker interface {
+	Connect(ctx context.Context, client *ws.Client) (bool, error)
+	Disconnect(ctx context.Context, client *ws.Client) (bool, error)
 }
 
+// SequenceStorage hands out per-room sequence numbers shared by all instances.
+type SequenceStorage interface {
+	NextSeq(ctx context.Context, roomID string) (int64, bool, error)
+	SeedNextSeq(ctx context.Context, roomID string, last int64) (int64, error)
+}
+
+type MessageStorage interface {
+	GetLastSeq(ctx context.Context, roomID string) (int64, error)
+	GetMessage(ctx context.Context, roomID, messageID string) (*domain.Message, error)
+	GetReadSeq(ctx context.Context, roomID, userID string) (int64, error)
+	CountPinnedMessages(ctx context.Context, roomID string) (int, error)
+	GetUnsentAttachments(ctx context.Context, roomID, userID string, attachmentIDs []string) ([]domain.Attachment, error)
+}
+
+// MessageCache is the Redis cache of the rooms. Besides what the consumer caches, it keeps
+// the messages sent but not stored by the consumer yet.
+type MessageCache interface {
+	GetReadReceipt(ctx context.Context, roomID, userID string) (*domain.ReadReceipt, error)
+	SetPendingMessage(ctx context.Context, msg *domain.Message) error
+	GetPendingMessage(ctx context.Context, roomID, messageID string) (*domain.Message, error)
+}
+
+type UserStorage interface {
+	IsModerator(ctx context.Context, userID string) (bool, error)
+	GetRoomUsersByNicknames(ctx context.Context, roomID string, nicknames []string) ([]domain.User, error)
+	GetRoomRole(ctx context.Context, roomID, userID string) (domain.Role, error)
+}
+
+type ReactionStorage interface {
+	HasReaction(ctx context.Context, reaction *domain.Reaction) (bool, error)
+}
+
+type RoomStorage interface {
+	GetRoom(ctx context.Context, roomID string) (*domain.Room, error)
+	GetActiveSanction(ctx context.Context, roomID, userID string, kind domain.SanctionKind) (*domain.Sanction, error)
+}
+
+// mentionPattern matches @nickname; a nickname mentioned this way consists of letters, digits, '_', '-' and '.'.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([\p{L}\p{N}_.-]+)`)
+
+// maxPinnedMessages keeps the pinned bar of a room short.
+const maxPinnedMessages = 50
+
+// maxEmojiLength mirrors message_reactions.emoji VARCHAR(32).
+const maxEmojiLength = 32
+
 type MessageOnlineService struct {
-	pusher      MessagePusher
-	consumer    MessageConsumer
-	roomClients RoomClientsStorage
-	hub         *ws.Hub
+	pusher    MessagePusher
+	consumer  MessageConsumer
+	presence  PresenceTracker
+	sequences SequenceStorage
+	messages  MessageStorage
+	cache     MessageCache
+	users     UserStorage
+	reactions ReactionStorage
+	rooms     RoomStorage
+	hub       *ws.Hub
 }
 
-func New(pusher MessagePusher, consumer MessageConsumer, roomClients RoomClientsStorage, hub *ws.Hub) *MessageOnlineService {
+func New(pusher MessagePusher, consumer MessageConsumer, presence PresenceTracker, sequences SequenceStorage, messages MessageStorage, cache MessageCache, users UserStorage, reactions ReactionStorage, rooms RoomStorage, hub *ws.Hub) *MessageOnlineService {
 	return &MessageOnlineService{
-		pusher:      pusher,
-		consumer:    consumer,
-		roomClients: roomClients,
-		hub:         hub,
+		pusher:    pusher,
+		consumer:  consumer,
+		presence:  presence,
+		sequences: sequences,
+		messages:  messages,
+		cache:     cache,
+		users:     users,
+		reactions: reactions,
+		rooms:     rooms,
+		hub:       hub,
+	}
+}
+
+// PushMessage assigns the message its ID and room sequence number and produces it to the broker.
+// A reply joins the thread of the message it answers. Attachments are given by ID and have to be
+// files the author uploaded to the room and has not sent yet.
+func (m *MessageOnlineService) PushMessage(ctx context.Context, msg *domain.Message) error {
+	if msg.ID == "" {
+		msg.ID = ulid.New()
+	}
+
+	if msg.Kind == "" {
+		msg.Kind = domain.MessageKindUser
+	}
+
+	if !msg.IsSystem() {
+		if err := m.checkMuted(ctx, msg.RoomID, msg.UserID); err != nil {
+			return fmt.Errorf("service.MessageOnlineService.PushMessage: %w", err)
+		}
+	}
+
+	if len(msg.Attachments) > 0 {
+		attachments, err := m.unsentAttachments(ctx, msg)
+		if err != nil {
+			return fmt.Errorf("service.MessageOnlineService.PushMessage: %w", err)
+		}
+
+		msg.Attachments = attachments
+	}
+
+	if msg.ReplyTo != "" {
+		parent, err := m.getMessage(ctx, msg.RoomID, msg.ReplyTo)
+		if err != nil {
+			return fmt.Errorf("service.MessageOnlineService.PushMessage: %w", err)
+		}
+
+		if parent.IsSystem() {
+			return domain.ErrSystemMessage
+		}
+
+		msg.ThreadID = parent.ThreadID
+		if msg.ThreadID == "" {
+			msg.ThreadID = parent.ID
+		}
 	}
+
+	if nicknames := parseMentions(msg.Content); len(nicknames) > 0 {
+		users, err := m.users.GetRoomUsersByNicknames(ctx, msg.RoomID, nicknames)
+		if err != nil {
+			return fmt.Errorf("service.MessageOnlineService.PushMessage: %w", err)
+		}
+
+		for i := range users {
+			if users[i].ID != msg.UserID {
+				msg.Mentions = append(msg.Mentions, users[i].ID)
+			}
+		}
+	}
+
+	seq, err := m.nextSeq(ctx, msg.RoomID)
+	if err != nil {
+		return fmt.Errorf("service.MessageOnlineService.PushMessage: %w", err)
+	}
+	msg.Seq = seq
+
+	err = m.cache.SetPendingMessage(ctx, msg)
+	if err != nil {
+		return fmt.Errorf("service.MessageOnlineService.PushMessage: %w", err)
+	}
+
+	return m.pusher.Produce(&domain.Event{
+		Type:    domain.EventMessageCreated,
+		RoomID:  msg.RoomID,
+		Message: msg,
+	})
 }
 
-func (m *MessageOnlineService) PushMessage(_ context.Context, msg *domain.Message) error {
-	return m.pusher.Produce(msg)
+// unsentAttachments loads the attachments of the message, which hold only their IDs so far.
+func (m *MessageOnlineService) unsentAttachments(ctx context.Context, msg *domain.Message) ([]domain.Attachment, error) {
+	ids := make([]string, 0, len(msg.Attachments))
+	for i := range msg.Attachments {
+		if !slices.Contains(ids, msg.Attachments[i].ID) {
+			ids = append(ids, msg.Attachments[i].ID)
+		}
+	}
+
+	attachments, err := m.messages.GetUnsentAttachments(ctx, msg.RoomID, msg.UserID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(attachments) != len(ids) {
+		return nil, domain.ErrAttachmentNotFound
+	}
+
+	return attachments, nil
+}
+
+// EditMessage replaces the content of a message on behalf of its author
+// or of a user whose role in the room allows editing others' messages.
+// The change is applied to storage by the consumer and broadcast by the hub.
+func (m *MessageOnlineService) EditMessage(ctx context.Context, roomID, messageID, userID, content string) (*domain.Message, error) {
+	if err := m.checkMuted(ctx, roomID, userID); err != nil {
+		return nil, fmt.Errorf("service.MessageOnlineService.EditMessage: %w", err)
+	}
+
+	msg, err := m.getMessage(ctx, roomID, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("service.MessageOnlineService.EditMessage: %w", err)
+	}
+
+	if msg.DeletedAt != nil {
+		return nil, domain.ErrMessageNotFound
+	}
+
+	if msg.IsSystem() {
+		return nil, domain.ErrSystemMessage
+	}
+
+	if msg.UserID != userID {
+		role, err := m.users.GetRoomRole(ctx, roomID, userID)
+		if err != nil {
+			return nil, fmt.Errorf("service.MessageOnlineService.EditMessage: %w", err)
+		}
+
+		if !role.Can(domain.PermEditOthers) {
+			return nil, domain.ErrNotMessageAuthor
+		}
+	}
+
+	editedAt := time.Now()
+	msg.Content = content
+	msg.EditedAt = &editedAt
+
+	err = m.pusher.Produce(&domain.Event{
+		Type:    domain.EventMessageEdited,
+		RoomID:  roomID,
+		Message: msg,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("service.MessageOnlineService.EditMessage: %w", err)
+	}
+
+	return msg, nil
+}
+
+// DeleteMessage tombstones a message: the content is cleared while the author, the time
+// and who deleted it are kept. Authors delete their own messages; service moderators and
+// users whose role in the room allows it delete anyone's.
+func (m *MessageOnlineService) DeleteMessage(ctx context.Context, roomID, messageID, userID string) (*domain.Message, error) {
+	if err := m.checkMuted(ctx, roomID, userID); err != nil {
+		return nil, fmt.Errorf("service.MessageOnlineService.DeleteMessage: %w", err)
+	}
+
+	msg, err := m.getMessage(ctx, roomID, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("service.MessageOnlineService.DeleteMessage: %w", err)
+	}
+
+	if msg.DeletedAt != nil {
+		return msg, nil
+	}
+
+	if msg.IsSystem() {
+		return nil, domain.ErrSystemMessage
+	}
+
+	if msg.UserID != userID {
+		isModerator, err := m.users.IsModerator(ctx, userID)
+		if err != nil {
+			return nil, fmt.Errorf("service.MessageOnlineService.DeleteMessage: %w", err)
+		}
+
+		if !isModerator {
+			role, err := m.users.GetRoomRole(ctx, roomID, userID)
+			if err != nil {
+				return nil, fmt.Errorf("service.MessageOnlineService.DeleteMessage: %w", err)
+			}
+
+			if !role.Can(domain.PermDeleteOthers) {
+				return nil, domain.ErrNotModerator
+			}
+		}
+	}
+
+	deletedAt := time.Now()
+	msg.Content = ""
+	msg.DeletedBy = userID
+	msg.DeletedAt = &deletedAt
+	msg.Reactions = nil
+	msg.Mentions = nil
+	msg.PinnedBy = ""
+	msg.PinnedAt = nil
+	msg.Attachments = nil
+
+	err = m.pusher.Produce(&domain.Event{
+		Type:    domain.EventMessageDeleted,
+		RoomID:  roomID,
+		Message: msg,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("service.MessageOnlineService.DeleteMessage: %w", err)
+	}
+
+	return msg, nil
+}
+
+// PinMessage pins the message to the top of the room on behalf of a user whose role allows it.
+// Pinning a pinned message is a no-op.
+func (m *MessageOnlineService) PinMessage(ctx context.Context, roomID, messageID, userID string) (*domain.Message, error) {
+	msg, err := m.pinnableMessage(ctx, roomID, messageID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("service.MessageOnlineService.PinMessage: %w", err)
+	}
+
+	if msg.PinnedAt != nil {
+		return msg, nil
+	}
+
+	pinned, err := m.messages.CountPinnedMessages(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("service.MessageOnlineService.PinMessage: %w", err)
+	}
+
+	if pinned >= maxPinnedMessages {
+		return nil, domain.ErrTooManyPins
+	}
+
+	pinnedAt := time.Now()
+	msg.PinnedBy = userID
+	msg.PinnedAt = &pinnedAt
+
+	err = m.pusher.Produce(&domain.Event{
+		Type:    domain.EventMessagePinned,
+		RoomID:  roomID,
+		Message: msg,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("service.MessageOnlineService.PinMessage: %w", err)
+	}
+
+	return msg, nil
+}
+
+// UnpinMessage takes the message off the top of the room. Unpinning a message
+// that is not pinned is a no-op.
+func (m *MessageOnlineService) UnpinMessage(ctx context.Context, roomID, messageID, userID string) (*domain.Message, error) {
+	msg, err := m.pinnableMessage(ctx, roomID, messageID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("service.MessageOnlineService.UnpinMessage: %w", err)
+	}
+
+	if msg.PinnedAt == nil {
+		return msg, nil
+	}
+
+	msg.PinnedBy = ""
+	msg.PinnedAt = nil
+
+	err = m.pusher.Produce(&domain.Event{
+		Type:    domain.EventMessageUnpinned,
+		RoomID:  roomID,
+		Message: msg,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("service.MessageOnlineService.UnpinMessage: %w", err)
+	}
+
+	return msg, nil
 }
 
-func (m *MessageOnlineService) Consume(ctx context.Context, handler func(message domain.Message) error) error {
+// pinnableMessage returns the message if it exists and the user may pin messages in the room.
+func (m *MessageOnlineService) pinnableMessage(ctx context.Context, roomID, messageID, userID string) (*domain.Message, error) {
+	role, err := m.users.GetRoomRole(ctx, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !role.Can(domain.PermPin) {
+		return nil, domain.ErrPermissionDenied
+	}
+
+	msg, err := m.getMessage(ctx, roomID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.DeletedAt != nil {
+		return nil, domain.ErrMessageNotFound
+	}
+
+	if msg.IsSystem() {
+		return nil, domain.ErrSystemMessage
+	}
+
+	return msg, nil
+}
+
+// AddReaction puts the emoji on the message on behalf of the user.
+// Adding a reaction the user already put is a no-op.
+func (m *MessageOnlineService) AddReaction(ctx context.Context, roomID, messageID, userID, emoji string) error {
+	return m.changeReaction(ctx, domain.EventReactionAdded, roomID, messageID, userID, emoji)
+}
+
+// RemoveReaction takes the emoji of the user off the message.
+// Removing a reaction the user has not put is a no-op.
+func (m *MessageOnlineService) RemoveReaction(ctx context.Context, roomID, messageID, userID, emoji string) error {
+	return m.changeReaction(ctx, domain.EventReactionRemoved, roomID, messageID, userID, emoji)
+}
+
+func (m *MessageOnlineService) changeReaction(ctx context.Context, eventType domain.EventType, roomID, messageID, userID, emoji string) error {
+	if emoji == "" || len(emoji) > maxEmojiLength || strings.ContainsFunc(emoji, unicode.IsSpace) {
+		return domain.ErrInvalidEmoji
+	}
+
+	if err := m.checkMuted(ctx, roomID, userID); err != nil {
+		return fmt.Errorf("service.MessageOnlineService.changeReaction: %w", err)
+	}
+
+	msg, err := m.getMessage(ctx, roomID, messageID)
+	if err != nil {
+		return fmt.Errorf("service.MessageOnlineService.changeReaction: %w", err)
+	}
+
+	if msg.DeletedAt != nil {
+		return domain.ErrMessageNotFound
+	}
+
+	if msg.IsSystem() {
+		return domain.ErrSystemMessage
+	}
+
+	reaction := &domain.Reaction{
+		MessageID:   messageID,
+		UserID:      userID,
+		Emoji:       emoji,
+		TimeCreated: time.Now(),
+		ThreadID:    msg.ThreadID,
+	}
+
+	exists, err := m.reactions.HasReaction(ctx, reaction)
+	if err != nil {
+		return fmt.Errorf("service.MessageOnlineService.changeReaction: %w", err)
+	}
+
+	if exists == (eventType == domain.EventReactionAdded) {
+		return nil
+	}
+
+	err = m.pusher.Produce(&domain.Event{
+		Type:     eventType,
+		RoomID:   roomID,
+		Reaction: reaction,
+	})
+	if err != nil {
+		return fmt.Errorf("service.MessageOnlineService.changeReaction: %w", err)
+	}
+
+	return nil
+}
+
+// MarkRead moves the read position of the user in the room up to the message.
+// Positions only move forward: marking an older message is a no-op.
+func (m *MessageOnlineService) MarkRead(ctx context.Context, roomID, messageID string, user *domain.User) (*domain.ReadReceipt, error) {
+	msg, err := m.getMessage(ctx, roomID, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("service.MessageOnlineService.MarkRead: %w", err)
+	}
+
+	readSeq, err := m.readSeq(ctx, roomID, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("service.MessageOnlineService.MarkRead: %w", err)
+	}
+
+	receipt := &domain.ReadReceipt{
+		RoomID:    roomID,
+		UserID:    user.ID,
+		Nickname:  user.Nickname,
+		MessageID: msg.ID,
+		Seq:       msg.Seq,
+		TimeRead:  time.Now(),
+	}
+
+	if msg.Seq <= readSeq {
+		return receipt, nil
+	}
+
+	err = m.pusher.Produce(&domain.Event{
+		Type:   domain.EventReadUpdated,
+		RoomID: roomID,
+		Read:   receipt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("service.MessageOnlineService.MarkRead: %w", err)
+	}
+
+	return receipt, nil
+}
+
+// getMessage returns the message from persistent storage or, when the consumer has not stored it
+// yet, the copy kept when it was sent, so a message can be acted on right after it is sent.
+func (m *MessageOnlineService) getMessage(ctx context.Context, roomID, messageID string) (*domain.Message, error) {
+	msg, err := m.messages.GetMessage(ctx, roomID, messageID)
+	if !errors.Is(err, domain.ErrMessageNotFound) {
+		return msg, err
+	}
+
+	pending, cacheErr := m.cache.GetPendingMessage(ctx, roomID, messageID)
+	if cacheErr != nil || pending == nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+// readSeq returns the read position of the user in the room, from the cache when it is there.
+func (m *MessageOnlineService) readSeq(ctx context.Context, roomID, userID string) (int64, error) {
+	receipt, err := m.cache.GetReadReceipt(ctx, roomID, userID)
+	if err == nil && receipt != nil {
+		return receipt.Seq, nil
+	}
+
+	return m.messages.GetReadSeq(ctx, roomID, userID)
+}
+
+// checkMuted returns domain.ErrMuted if the user is muted in the room. A muted user
+// reads the room but cannot post, edit, delete or react until the mute expires.
+func (m *MessageOnlineService) checkMuted(ctx context.Context, roomID, userID string) error {
+	mute, err := m.rooms.GetActiveSanction(ctx, roomID, userID, domain.SanctionMute)
+	if err != nil {
+		return err
+	}
+
+	if mute != nil {
+		return domain.ErrMuted
+	}
+
+	return nil
+}
+
+// parseMentions returns the distinct nicknames mentioned in the content.
+// A trailing dot is taken for punctuation rather than a part of the nickname.
+func parseMentions(content string) []string {
+	var nicknames []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		nickname := strings.TrimRight(match[1], ".")
+		if nickname != "" && !slices.Contains(nicknames, nickname) {
+			nicknames = append(nicknames, nickname)
+		}
+	}
+
+	return nicknames
+}
+
+// nextSeq takes the next number from the room counter. When Redis has lost the counter it is
+// seeded from persistent storage first. The seed is only as fresh as what the consumer has stored:
+// while Kafka lags behind, numbers already handed out but not yet persisted can be issued again.
+func (m *MessageOnlineService) nextSeq(ctx context.Context, roomID string) (int64, error) {
+	seq, ok, err := m.sequences.NextSeq(ctx, roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	if ok {
+		return seq, nil
+	}
+
+	last, err := m.messages.GetLastSeq(ctx, roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	return m.sequences.SeedNextSeq(ctx, roomID, last)
+}
+
+func (m *MessageOnlineService) Consume(ctx context.Context, handler domain.EventHandler) error {
 	return m.consumer.Consume(ctx, handler)
 }
 
 func (m *MessageOnlineService) Subscribe(ctx context.Context, client *ws.Client) error {
 	m.hub.AddConnection(client)
 
-	err := m.roomClients.AddRoomClient(ctx, client.RoomID, client.User)
+	first, err := m.presence.Connect(ctx, client)
 	if err != nil {
 		return fmt.Errorf("service.MessageOnlineService.Subscribe: %w", err)
 	}
 
-	return m.pusher.Produce(&domain.Message{
-		Content:     "joined the room",
-		RoomID:      client.RoomID,
-		UserID:      client.User.ID,
-		TimeCreated: time.Now(),
-		Nickname:    client.User.Nickname,
-	})
+	// following a thread and opening the room on another device are not announced to the room
+	if client.ThreadID != "" || !first {
+		return nil
+	}
+
+	err = m.pushSystemMessage(ctx, client, domain.SystemMemberJoined)
+	if err != nil {
+		return fmt.Errorf("service.MessageOnlineService.Subscribe: %w", err)
+	}
+
+	return nil
 }
 
 func (m *MessageOnlineService) Unsubscribe(ctx context.Context, client *ws.Client) error {
 	m.hub.DeleteConnection(client)
 
-	err := m.roomClients.DeleteClient(ctx, client.RoomID, client.User)
+	last, err := m.presence.Disconnect(ctx, client)
+	if err != nil {
+		return fmt.Errorf("service.MessageOnlineService.Unsubscribe: %w", err)
+	}
+
+	// a deleted room is not told who left it, and the user has not left while another device is connected
+	if client.ThreadID != "" || !last || client.RoomDeleted() {
+		return nil
+	}
+
+	err = m.pushSystemMessage(ctx, client, domain.SystemMemberLeft)
 	if err != nil {
 		return fmt.Errorf("service.MessageOnlineService.Unsubscribe: %w", err)
 	}
 
-	return m.pusher.Produce(&domain.Message{
-		Content:     "left the room",
+	return nil
+}
+
+// pushSystemMessage writes the event about the user of the client to the room,
+// unless the room has system messages turned off.
+func (m *MessageOnlineService) pushSystemMessage(ctx context.Context, client *ws.Client, event domain.SystemEvent) error {
+	room, err := m.rooms.GetRoom(ctx, client.RoomID)
+	if err != nil {
+		return err
+	}
+
+	if !room.SystemEvents {
+		return nil
+	}
+
+	return m.PushMessage(ctx, &domain.Message{
+		Kind:        domain.MessageKindSystem,
+		Event:       event,
 		RoomID:      client.RoomID,
 		UserID:      client.User.ID,
 		TimeCreated: time.Now(),
@@ -0,0 +1,361 @@
+package presence
+
+import (
+	"app-websocket/internal/config"
+	"app-websocket/internal/domain"
+	"app-websocket/internal/ports/ws"
+	"app-websocket/pkg/ulid"
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+type PresenceStorage interface {
+	SavePresence(ctx context.Context, instance string, records []domain.Presence, ttl time.Duration) error
+	AddPresence(ctx context.Context, instance string, presence *domain.Presence, ttl time.Duration) (int, error)
+	DeletePresence(ctx context.Context, presence *domain.Presence) (int, error)
+	GetRoomPresence(ctx context.Context, roomID string) ([]domain.Presence, error)
+	GetOnlinePresence(ctx context.Context) ([]domain.Presence, error)
+	CountRoomPresence(ctx context.Context, roomIDs []string) (map[string]int, error)
+	GetExpiredInstances(ctx context.Context) ([]string, error)
+	DeleteInstancePresence(ctx context.Context, instance string) error
+}
+
+// connection is the presence of a connection held by this instance.
+type connection struct {
+	record domain.Presence
+	away   bool // the client said it is away
+}
+
+// PresenceService keeps the presence records of the connections to this instance alive with
+// heartbeats. Every instance has its own ID, so the records of an instance that died without
+// saying goodbye expire with its heartbeats and are then cleaned up by the instances still alive.
+type PresenceService struct {
+	logger    *slog.Logger
+	storage   PresenceStorage
+	instance  string
+	heartbeat time.Duration
+	ttl       time.Duration
+	awayAfter time.Duration
+
+	mu          sync.Mutex
+	connections map[string]*connection // by connection ID
+}
+
+func New(config *config.PresenceConfig, storage PresenceStorage, logger *slog.Logger) *PresenceService {
+	return &PresenceService{
+		logger:      logger,
+		storage:     storage,
+		instance:    ulid.New(),
+		heartbeat:   config.HeartbeatInterval,
+		ttl:         config.TTL,
+		awayAfter:   config.AwayAfter,
+		connections: make(map[string]*connection),
+	}
+}
+
+// Run sends heartbeats until ctx is done and then takes the presence of the instance down.
+func (p *PresenceService) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			err := p.storage.DeleteInstancePresence(context.Background(), p.instance)
+			if err != nil {
+				p.logger.Error("failed to delete presence of the instance", slog.String("error", err.Error()))
+			}
+			return
+
+		case <-ticker.C:
+			err := p.beat(ctx)
+			if err != nil {
+				p.logger.Error("failed to send presence heartbeat", slog.String("error", err.Error()))
+			}
+
+			err = p.reap(ctx)
+			if err != nil {
+				p.logger.Error("failed to clean up presence of expired instances", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+// beat refreshes the records of all connections, turning idle ones away.
+func (p *PresenceService) beat(ctx context.Context) error {
+	now := time.Now()
+
+	p.mu.Lock()
+	records := make([]domain.Presence, 0, len(p.connections))
+	for _, conn := range p.connections {
+		conn.record.Status = p.status(conn, now)
+		records = append(records, conn.record)
+	}
+	p.mu.Unlock()
+
+	// the instance itself is refreshed even without connections, so it is not taken for dead
+	return p.save(ctx, records)
+}
+
+// save writes the records of the connections. The records are taken before the write, so
+// a connection closed in between is written back after Disconnect deleted it; such records
+// are deleted again rather than left in the room until they expire.
+func (p *PresenceService) save(ctx context.Context, records []domain.Presence) error {
+	err := p.storage.SavePresence(ctx, p.instance, records, p.ttl)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	var closed []domain.Presence
+	for i := range records {
+		if _, ok := p.connections[records[i].ConnID]; !ok {
+			closed = append(closed, records[i])
+		}
+	}
+	p.mu.Unlock()
+
+	for i := range closed {
+		_, err = p.storage.DeletePresence(ctx, &closed[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reap removes the presence of the instances that stopped sending heartbeats.
+func (p *PresenceService) reap(ctx context.Context) error {
+	instances, err := p.storage.GetExpiredInstances(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, instance := range instances {
+		if instance == p.instance {
+			// a heartbeat of ours was late, the next one brings everything back
+			continue
+		}
+
+		err = p.storage.DeleteInstancePresence(ctx, instance)
+		if err != nil {
+			return err
+		}
+
+		p.logger.Info("cleaned up presence of expired instance", slog.String("instance", instance))
+	}
+
+	return nil
+}
+
+func (p *PresenceService) status(conn *connection, now time.Time) domain.PresenceStatus {
+	if conn.away || now.Sub(conn.record.LastActive) >= p.awayAfter {
+		return domain.PresenceAway
+	}
+
+	return domain.PresenceOnline
+}
+
+// Connect makes the client present in its room. It reports whether this is the only connection
+// of the user to the room on any instance, that is whether the user has just come.
+func (p *PresenceService) Connect(ctx context.Context, client *ws.Client) (bool, error) {
+	conn := &connection{
+		record: domain.Presence{
+			ConnID:     client.ID,
+			UserID:     client.User.ID,
+			Nickname:   client.User.Nickname,
+			RoomID:     client.RoomID,
+			Instance:   p.instance,
+			Status:     domain.PresenceOnline,
+			LastActive: time.Now(),
+		},
+	}
+
+	// the connection is held back from the heartbeats until it is counted, or a heartbeat could
+	// add it to the room before that and it would not be taken for the first one
+	count, err := p.storage.AddPresence(ctx, p.instance, &conn.record, p.ttl)
+	if err != nil {
+		return false, fmt.Errorf("service.PresenceService.Connect: %w", err)
+	}
+
+	p.mu.Lock()
+	p.connections[client.ID] = conn
+	p.mu.Unlock()
+
+	return count == 1, nil
+}
+
+// Disconnect removes the presence of the closed client. It reports whether the user has no
+// connections to the room left on any instance, that is whether the user has just left.
+func (p *PresenceService) Disconnect(ctx context.Context, client *ws.Client) (bool, error) {
+	p.mu.Lock()
+	conn, ok := p.connections[client.ID]
+	delete(p.connections, client.ID)
+	p.mu.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+
+	count, err := p.storage.DeletePresence(ctx, &conn.record)
+	if err != nil {
+		return false, fmt.Errorf("service.PresenceService.Disconnect: %w", err)
+	}
+
+	return count == 0, nil
+}
+
+// Touch records activity of the client. A client that went away for being idle is online again
+// right away rather than with the next heartbeat.
+func (p *PresenceService) Touch(ctx context.Context, client *ws.Client) {
+	p.update(ctx, client, func(conn *connection) {
+		conn.record.LastActive = time.Now()
+	})
+}
+
+// SetStatus lets the client tell that its user is away, for example when the tab is hidden,
+// or back online.
+func (p *PresenceService) SetStatus(ctx context.Context, client *ws.Client, status domain.PresenceStatus) error {
+	if status != domain.PresenceOnline && status != domain.PresenceAway {
+		return domain.ErrInvalidPresence
+	}
+
+	p.update(ctx, client, func(conn *connection) {
+		conn.away = status == domain.PresenceAway
+		if !conn.away {
+			conn.record.LastActive = time.Now()
+		}
+	})
+
+	return nil
+}
+
+// update applies the change to the connection of the client and saves its record
+// when the status has changed.
+func (p *PresenceService) update(ctx context.Context, client *ws.Client, change func(conn *connection)) {
+	p.mu.Lock()
+	conn, ok := p.connections[client.ID]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+
+	change(conn)
+	status := p.status(conn, time.Now())
+	changed := status != conn.record.Status
+	conn.record.Status = status
+	record := conn.record
+	p.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	err := p.save(ctx, []domain.Presence{record})
+	if err != nil {
+		p.logger.Error("failed to save presence", slog.String("ClientID", client.User.ID), slog.String("error", err.Error()))
+	}
+}
+
+// GetRoomClients returns the users present in the room.
+func (p *PresenceService) GetRoomClients(ctx context.Context, roomID string) ([]domain.UserPresence, error) {
+	records, err := p.storage.GetRoomPresence(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("service.PresenceService.GetRoomClients: %w", err)
+	}
+
+	return aggregate(records), nil
+}
+
+// CountRoomClients returns the number of users present in each of the rooms.
+func (p *PresenceService) CountRoomClients(ctx context.Context, roomIDs []string) (map[string]int, error) {
+	counts, err := p.storage.CountRoomPresence(ctx, roomIDs)
+	if err != nil {
+		return nil, fmt.Errorf("service.PresenceService.CountRoomClients: %w", err)
+	}
+
+	return counts, nil
+}
+
+// GetOnline returns the users connected to any room of any instance.
+func (p *PresenceService) GetOnline(ctx context.Context) ([]domain.UserPresence, error) {
+	records, err := p.storage.GetOnlinePresence(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("service.PresenceService.GetOnline: %w", err)
+	}
+
+	return aggregate(records), nil
+}
+
+// GetUsers returns the presence of the given users, offline ones included.
+func (p *PresenceService) GetUsers(ctx context.Context, userIDs []string) ([]domain.UserPresence, error) {
+	online, err := p.GetOnline(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("service.PresenceService.GetUsers: %w", err)
+	}
+
+	byUser := make(map[string]domain.UserPresence, len(online))
+	for _, user := range online {
+		byUser[user.UserID] = user
+	}
+
+	users := make([]domain.UserPresence, 0, len(userIDs))
+	for _, userID := range userIDs {
+		user, ok := byUser[userID]
+		if !ok {
+			user = domain.UserPresence{
+				UserID: userID,
+				Status: domain.PresenceOffline,
+			}
+		}
+
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// aggregate merges the records of the connections into one entry per user, ordered by nickname.
+func aggregate(records []domain.Presence) []domain.UserPresence {
+	byUser := make(map[string]*domain.UserPresence)
+	for _, record := range records {
+		user, ok := byUser[record.UserID]
+		if !ok {
+			byUser[record.UserID] = &domain.UserPresence{
+				UserID:     record.UserID,
+				Nickname:   record.Nickname,
+				Status:     record.Status,
+				LastActive: record.LastActive,
+			}
+			continue
+		}
+
+		if record.Status == domain.PresenceOnline {
+			user.Status = domain.PresenceOnline
+		}
+
+		if record.LastActive.After(user.LastActive) {
+			user.LastActive = record.LastActive
+		}
+	}
+
+	users := make([]domain.UserPresence, 0, len(byUser))
+	for _, user := range byUser {
+		users = append(users, *user)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Nickname != users[j].Nickname {
+			return users[i].Nickname < users[j].Nickname
+		}
+
+		return users[i].UserID < users[j].UserID
+	})
+
+	return users
+}
@@ -2,33 +2,599 @@ package rooms
 
 import (
 	"app-websocket/internal/domain"
+	"app-websocket/pkg/ulid"
 	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
 )
 
 type RoomStorage interface {
-	GetAllRooms(ctx context.Context) ([]domain.Room, error)
+	GetRooms(ctx context.Context, query *domain.RoomsQuery) ([]domain.Room, *domain.RoomCursor, error)
 	GetRoom(ctx context.Context, roomID string) (*domain.Room, error)
-	CreateRoom(ctx context.Context, name string) (*domain.Room, error)
+	CreateRoom(ctx context.Context, name, visibility, creatorID string) (*domain.Room, error)
+	GetUnreadCounts(ctx context.Context, userID string, roomIDs []string) (map[string]int, error)
+	GetUserByID(ctx context.Context, userID string) (*domain.User, error)
+	CreateDirectRoom(ctx context.Context, userID, peerID string) (*domain.Room, error)
+	GetDirectRooms(ctx context.Context, userID string) ([]domain.Room, error)
+	IsDirectMember(ctx context.Context, roomID, userID string) (bool, error)
+	IsRoomMember(ctx context.Context, roomID, userID string) (bool, error)
+	AddRoomMember(ctx context.Context, roomID, userID string) error
+	CreateInvite(ctx context.Context, invite *domain.Invite) error
+	GetInvite(ctx context.Context, roomID, inviteID string) (*domain.Invite, error)
+	GetRoomInvites(ctx context.Context, roomID string) ([]domain.Invite, error)
+	GetUserInvites(ctx context.Context, userID string) ([]domain.Invite, error)
+	DeleteInvite(ctx context.Context, roomID, inviteID string) error
+	AcceptInvite(ctx context.Context, code, userID string) (*domain.Room, error)
+	GetRoomRole(ctx context.Context, roomID, userID string) (domain.Role, error)
+	GetRoomMembers(ctx context.Context, roomID string) ([]domain.Member, error)
+	SetRoomRole(ctx context.Context, roomID, userID string, role domain.Role) error
+	TransferOwnership(ctx context.Context, roomID, ownerID, userID string) error
+	SaveSanction(ctx context.Context, sanction *domain.Sanction) error
+	DeleteSanction(ctx context.Context, roomID, userID string, kind domain.SanctionKind) error
+	GetActiveSanction(ctx context.Context, roomID, userID string, kind domain.SanctionKind) (*domain.Sanction, error)
+	GetSanctions(ctx context.Context, roomID string, kind domain.SanctionKind) ([]domain.Sanction, error)
+	UpdateRoom(ctx context.Context, roomID string, update *domain.RoomUpdate) (*domain.Room, error)
+	SetRoomArchived(ctx context.Context, roomID string, archived bool) (*domain.Room, error)
+	MarkRoomDeleted(ctx context.Context, roomID string) error
+}
+
+// EventPusher produces room lifecycle events: every instance notifies its clients of the room
+// and app-consumer purges the content of deleted rooms.
+type EventPusher interface {
+	Produce(event *domain.Event) error
+}
+
+// SignalPublisher shares role changes and moderation actions with the clients connected to any instance.
+type SignalPublisher interface {
+	PublishSignal(ctx context.Context, signal *domain.Signal) error
 }
 
 type RoomProvider struct {
 	storage RoomStorage
+	signals SignalPublisher
+	events  EventPusher
 }
 
-func New(storage RoomStorage) *RoomProvider {
+func New(storage RoomStorage, signals SignalPublisher, events EventPusher) *RoomProvider {
 	return &RoomProvider{
 		storage: storage,
+		signals: signals,
+		events:  events,
 	}
 }
 
-func (r *RoomProvider) GetAllRooms(ctx context.Context) ([]domain.Room, error) {
-	return r.storage.GetAllRooms(ctx)
+// GetRooms returns a page of the rooms visible to the user: public ones and private ones the user
+// is a member of. Archived rooms are listed only when asked for. The cursor of the next page is nil
+// on the last one.
+func (r *RoomProvider) GetRooms(ctx context.Context, query *domain.RoomsQuery) ([]domain.Room, *domain.RoomCursor, error) {
+	if query.Sort == "" {
+		query.Sort = domain.RoomSortActivity
+	}
+
+	return r.storage.GetRooms(ctx, query)
+}
+
+// Join makes the user a member of a public room on connecting to it; members of private
+// rooms join by invitation and direct rooms have no members.
+func (r *RoomProvider) Join(ctx context.Context, room *domain.Room, userID string) error {
+	if room.Kind == domain.RoomKindDirect || room.Visibility != domain.RoomPublic {
+		return nil
+	}
+
+	return r.storage.AddRoomMember(ctx, room.ID, userID)
 }
 
 func (r *RoomProvider) GetRoom(ctx context.Context, roomID string) (*domain.Room, error) {
 	return r.storage.GetRoom(ctx, roomID)
 }
 
-func (r *RoomProvider) CreateRoom(ctx context.Context, name string) (*domain.Room, error) {
-	return r.storage.CreateRoom(ctx, name)
+// CreateRoom creates a public room unless visibility says otherwise; the creator becomes a member.
+func (r *RoomProvider) CreateRoom(ctx context.Context, name, visibility, creatorID string) (*domain.Room, error) {
+	if visibility == "" {
+		visibility = domain.RoomPublic
+	}
+
+	return r.storage.CreateRoom(ctx, name, visibility, creatorID)
+}
+
+// GetUnreadCounts returns the number of unread messages of the user in each of the rooms.
+func (r *RoomProvider) GetUnreadCounts(ctx context.Context, userID string, roomIDs []string) (map[string]int, error) {
+	return r.storage.GetUnreadCounts(ctx, userID, roomIDs)
+}
+
+// CreateDirectRoom returns the direct room of the user and the peer, creating it on the first call.
+// The room is named after the peer, as the user sees it.
+func (r *RoomProvider) CreateDirectRoom(ctx context.Context, userID, peerID string) (*domain.Room, error) {
+	if userID == peerID {
+		return nil, domain.ErrDirectWithSelf
+	}
+
+	peer, err := r.storage.GetUserByID(ctx, peerID)
+	if err != nil {
+		return nil, err
+	}
+
+	room, err := r.storage.CreateDirectRoom(ctx, userID, peer.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	room.Name = peer.Nickname
+	return room, nil
+}
+
+func (r *RoomProvider) GetDirectRooms(ctx context.Context, userID string) ([]domain.Room, error) {
+	return r.storage.GetDirectRooms(ctx, userID)
+}
+
+// CanAccess reports whether the user may join the room and read its history.
+// Direct rooms are open to their two members only, private rooms to their members.
+// A user banned from the room gets ErrBanned.
+func (r *RoomProvider) CanAccess(ctx context.Context, room *domain.Room, userID string) (bool, error) {
+	if room.Kind == domain.RoomKindDirect {
+		return r.storage.IsDirectMember(ctx, room.ID, userID)
+	}
+
+	if room.Visibility == domain.RoomPrivate {
+		isMember, err := r.storage.IsRoomMember(ctx, room.ID, userID)
+		if err != nil || !isMember {
+			return false, err
+		}
+	}
+
+	ban, err := r.storage.GetActiveSanction(ctx, room.ID, userID, domain.SanctionBan)
+	if err != nil {
+		return false, err
+	}
+
+	if ban != nil {
+		return false, domain.ErrBanned
+	}
+
+	return true, nil
+}
+
+// CreateInvite creates an invite to the room on behalf of the user. With inviteeID the invite
+// is addressed to that user and can be accepted once, otherwise it is a link anyone can use.
+// Zero ttl and maxUses mean the invite never expires and is not limited in uses.
+func (r *RoomProvider) CreateInvite(ctx context.Context, room *domain.Room, userID, inviteeID string, ttl time.Duration, maxUses int) (*domain.Invite, error) {
+	if room.Kind == domain.RoomKindDirect {
+		return nil, domain.ErrDirectRoomInvite
+	}
+
+	if inviteeID != "" {
+		_, err := r.storage.GetUserByID(ctx, inviteeID)
+		if err != nil {
+			if errors.Is(err, domain.ErrPeerNotFound) {
+				return nil, domain.ErrInviteeNotFound
+			}
+
+			return nil, err
+		}
+
+		maxUses = 1
+	}
+
+	code, err := newInviteCode()
+	if err != nil {
+		return nil, fmt.Errorf("service.rooms.CreateInvite: %w", err)
+	}
+
+	invite := &domain.Invite{
+		ID:          ulid.New(),
+		RoomID:      room.ID,
+		CreatedBy:   userID,
+		InviteeID:   inviteeID,
+		Code:        code,
+		MaxUses:     maxUses,
+		TimeCreated: time.Now(),
+	}
+
+	if ttl > 0 {
+		expiresAt := invite.TimeCreated.Add(ttl)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	err = r.storage.CreateInvite(ctx, invite)
+	if err != nil {
+		return nil, err
+	}
+
+	return invite, nil
+}
+
+// GetRoomInvites lists the invites of the room that can still be accepted.
+func (r *RoomProvider) GetRoomInvites(ctx context.Context, roomID string) ([]domain.Invite, error) {
+	return r.storage.GetRoomInvites(ctx, roomID)
+}
+
+// GetUserInvites lists the pending invites addressed to the user.
+func (r *RoomProvider) GetUserInvites(ctx context.Context, userID string) ([]domain.Invite, error) {
+	return r.storage.GetUserInvites(ctx, userID)
+}
+
+// RevokeInvite deletes the invite; its author and those who manage the room may do it.
+func (r *RoomProvider) RevokeInvite(ctx context.Context, room *domain.Room, inviteID, userID string) error {
+	invite, err := r.storage.GetInvite(ctx, room.ID, inviteID)
+	if err != nil {
+		return err
+	}
+
+	if invite.CreatedBy != userID {
+		role, err := r.GetRole(ctx, room, userID)
+		if err != nil {
+			return err
+		}
+
+		if !role.Can(domain.PermManageRoom) {
+			return domain.ErrNotInviteAuthor
+		}
+	}
+
+	return r.storage.DeleteInvite(ctx, room.ID, inviteID)
+}
+
+// AcceptInvite makes the user a member of the room the invite leads to.
+func (r *RoomProvider) AcceptInvite(ctx context.Context, code, userID string) (*domain.Room, error) {
+	return r.storage.AcceptInvite(ctx, code, userID)
+}
+
+// UpdateRoom changes the settings of the room on behalf of the user and notifies the room.
+func (r *RoomProvider) UpdateRoom(ctx context.Context, room *domain.Room, userID string, update *domain.RoomUpdate) (*domain.Room, error) {
+	err := r.Authorize(ctx, room, userID, domain.PermManageRoom)
+	if err != nil {
+		return nil, err
+	}
+
+	room, err = r.storage.UpdateRoom(ctx, room.ID, update)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.produceRoom(domain.EventRoomUpdated, room)
+	if err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// Archive makes the room read-only and hides it from the default room list.
+func (r *RoomProvider) Archive(ctx context.Context, room *domain.Room, userID string) (*domain.Room, error) {
+	return r.setArchived(ctx, room, userID, true)
+}
+
+func (r *RoomProvider) Unarchive(ctx context.Context, room *domain.Room, userID string) (*domain.Room, error) {
+	return r.setArchived(ctx, room, userID, false)
+}
+
+func (r *RoomProvider) setArchived(ctx context.Context, room *domain.Room, userID string, archived bool) (*domain.Room, error) {
+	err := r.Authorize(ctx, room, userID, domain.PermManageRoom)
+	if err != nil {
+		return nil, err
+	}
+
+	room, err = r.storage.SetRoomArchived(ctx, room.ID, archived)
+	if err != nil {
+		return nil, err
+	}
+
+	eventType := domain.EventRoomUnarchived
+	if archived {
+		eventType = domain.EventRoomArchived
+	}
+
+	err = r.produceRoom(eventType, room)
+	if err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// DeleteRoom hides the room at once and disconnects its clients; app-consumer then purges
+// its messages and cached keys. Only the owner may delete a room.
+func (r *RoomProvider) DeleteRoom(ctx context.Context, room *domain.Room, userID string) error {
+	err := r.Authorize(ctx, room, userID, domain.PermDeleteRoom)
+	if err != nil {
+		return err
+	}
+
+	err = r.storage.MarkRoomDeleted(ctx, room.ID)
+	if err != nil {
+		return err
+	}
+
+	return r.produceRoom(domain.EventRoomDeleted, room)
+}
+
+func (r *RoomProvider) produceRoom(eventType domain.EventType, room *domain.Room) error {
+	err := r.events.Produce(&domain.Event{
+		Type:   eventType,
+		RoomID: room.ID,
+		Room:   room,
+	})
+	if err != nil {
+		return fmt.Errorf("service.rooms.produceRoom: %w", err)
+	}
+
+	return nil
+}
+
+// GetRole returns the role of the user in the room. Both users of a direct room are plain members.
+func (r *RoomProvider) GetRole(ctx context.Context, room *domain.Room, userID string) (domain.Role, error) {
+	if room.Kind == domain.RoomKindDirect {
+		return domain.RoleMember, nil
+	}
+
+	return r.storage.GetRoomRole(ctx, room.ID, userID)
+}
+
+// Authorize returns ErrPermissionDenied unless the role of the user in the room grants perm.
+func (r *RoomProvider) Authorize(ctx context.Context, room *domain.Room, userID string, perm domain.Permission) error {
+	role, err := r.GetRole(ctx, room, userID)
+	if err != nil {
+		return err
+	}
+
+	if !role.Can(perm) {
+		return domain.ErrPermissionDenied
+	}
+
+	return nil
+}
+
+func (r *RoomProvider) GetMembers(ctx context.Context, roomID string) ([]domain.Member, error) {
+	return r.storage.GetRoomMembers(ctx, roomID)
+}
+
+// SetRole changes the role of the user in the room on behalf of the actor, who must outrank
+// both the current and the new role. Making someone the owner is up to the owner only and
+// hands the ownership over: the previous owner becomes an admin.
+func (r *RoomProvider) SetRole(ctx context.Context, room *domain.Room, actorID, userID string, role domain.Role) error {
+	if !role.Valid() {
+		return domain.ErrInvalidRole
+	}
+
+	if room.Kind == domain.RoomKindDirect || actorID == userID {
+		return domain.ErrPermissionDenied
+	}
+
+	actorRole, err := r.GetRole(ctx, room, actorID)
+	if err != nil {
+		return err
+	}
+
+	if !actorRole.Can(domain.PermManageRoles) {
+		return domain.ErrPermissionDenied
+	}
+
+	user, err := r.storage.GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrPeerNotFound) {
+			return domain.ErrMemberNotFound
+		}
+
+		return err
+	}
+
+	if room.Visibility == domain.RoomPrivate {
+		isMember, err := r.storage.IsRoomMember(ctx, room.ID, userID)
+		if err != nil {
+			return err
+		}
+
+		if !isMember {
+			return domain.ErrMemberNotFound
+		}
+	}
+
+	userRole, err := r.storage.GetRoomRole(ctx, room.ID, userID)
+	if err != nil {
+		return err
+	}
+
+	if role == domain.RoleOwner {
+		if actorRole != domain.RoleOwner {
+			return domain.ErrPermissionDenied
+		}
+
+		err = r.storage.TransferOwnership(ctx, room.ID, actorID, userID)
+		if err != nil {
+			return err
+		}
+
+		actor, err := r.storage.GetUserByID(ctx, actorID)
+		if err != nil {
+			return err
+		}
+
+		err = r.publishRole(ctx, room.ID, actor, domain.RoleAdmin)
+		if err != nil {
+			return err
+		}
+
+		return r.publishRole(ctx, room.ID, user, domain.RoleOwner)
+	}
+
+	if !actorRole.Outranks(userRole) || !actorRole.Outranks(role) {
+		return domain.ErrPermissionDenied
+	}
+
+	err = r.storage.SetRoomRole(ctx, room.ID, userID, role)
+	if err != nil {
+		return err
+	}
+
+	return r.publishRole(ctx, room.ID, user, role)
+}
+
+// Kick disconnects the user from the room on whichever instance holds the connection.
+// Unlike a ban it does not stop the user from joining again.
+func (r *RoomProvider) Kick(ctx context.Context, room *domain.Room, actorID, userID, reason string) error {
+	user, err := r.moderate(ctx, room, actorID, userID, domain.PermKick)
+	if err != nil {
+		return err
+	}
+
+	return r.publishSanction(ctx, domain.SignalMemberKicked, room.ID, user, reason, 0)
+}
+
+// Ban disconnects the user and refuses joining the room for ttl, for good when ttl is zero.
+func (r *RoomProvider) Ban(ctx context.Context, room *domain.Room, actorID, userID, reason string, ttl time.Duration) error {
+	user, err := r.moderate(ctx, room, actorID, userID, domain.PermBan)
+	if err != nil {
+		return err
+	}
+
+	err = r.saveSanction(ctx, room.ID, actorID, user.ID, domain.SanctionBan, reason, ttl)
+	if err != nil {
+		return err
+	}
+
+	return r.publishSanction(ctx, domain.SignalMemberBanned, room.ID, user, reason, ttl)
+}
+
+func (r *RoomProvider) Unban(ctx context.Context, room *domain.Room, actorID, userID string) error {
+	user, err := r.moderate(ctx, room, actorID, userID, domain.PermBan)
+	if err != nil {
+		return err
+	}
+
+	return r.storage.DeleteSanction(ctx, room.ID, user.ID, domain.SanctionBan)
+}
+
+// Mute leaves the user able to read the room but not to post for ttl, for good when ttl is zero.
+func (r *RoomProvider) Mute(ctx context.Context, room *domain.Room, actorID, userID, reason string, ttl time.Duration) error {
+	user, err := r.moderate(ctx, room, actorID, userID, domain.PermMute)
+	if err != nil {
+		return err
+	}
+
+	err = r.saveSanction(ctx, room.ID, actorID, user.ID, domain.SanctionMute, reason, ttl)
+	if err != nil {
+		return err
+	}
+
+	return r.publishSanction(ctx, domain.SignalMemberMuted, room.ID, user, reason, ttl)
+}
+
+func (r *RoomProvider) Unmute(ctx context.Context, room *domain.Room, actorID, userID string) error {
+	user, err := r.moderate(ctx, room, actorID, userID, domain.PermMute)
+	if err != nil {
+		return err
+	}
+
+	err = r.storage.DeleteSanction(ctx, room.ID, user.ID, domain.SanctionMute)
+	if err != nil {
+		return err
+	}
+
+	return r.publishSanction(ctx, domain.SignalMemberUnmuted, room.ID, user, "", 0)
+}
+
+// GetSanction returns the ban or mute the user is under in the room, nil if there is none.
+func (r *RoomProvider) GetSanction(ctx context.Context, roomID, userID string, kind domain.SanctionKind) (*domain.Sanction, error) {
+	return r.storage.GetActiveSanction(ctx, roomID, userID, kind)
+}
+
+func (r *RoomProvider) GetSanctions(ctx context.Context, roomID string, kind domain.SanctionKind) ([]domain.Sanction, error) {
+	return r.storage.GetSanctions(ctx, roomID, kind)
+}
+
+// moderate checks that the actor has perm and outranks the user, and returns the user.
+func (r *RoomProvider) moderate(ctx context.Context, room *domain.Room, actorID, userID string, perm domain.Permission) (*domain.User, error) {
+	actorRole, err := r.GetRole(ctx, room, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !actorRole.Can(perm) {
+		return nil, domain.ErrPermissionDenied
+	}
+
+	user, err := r.storage.GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrPeerNotFound) {
+			return nil, domain.ErrMemberNotFound
+		}
+
+		return nil, err
+	}
+
+	userRole, err := r.GetRole(ctx, room, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !actorRole.Outranks(userRole) {
+		return nil, domain.ErrPermissionDenied
+	}
+
+	return user, nil
+}
+
+func (r *RoomProvider) saveSanction(ctx context.Context, roomID, actorID, userID string, kind domain.SanctionKind, reason string, ttl time.Duration) error {
+	sanction := &domain.Sanction{
+		RoomID:      roomID,
+		UserID:      userID,
+		Kind:        kind,
+		Reason:      reason,
+		CreatedBy:   actorID,
+		TimeCreated: time.Now(),
+	}
+
+	if ttl > 0 {
+		expiresAt := sanction.TimeCreated.Add(ttl)
+		sanction.ExpiresAt = &expiresAt
+	}
+
+	return r.storage.SaveSanction(ctx, sanction)
+}
+
+// publishSanction applies a moderation action to the live connections of the user on every instance.
+func (r *RoomProvider) publishSanction(ctx context.Context, signalType domain.SignalType, roomID string, user *domain.User, reason string, ttl time.Duration) error {
+	err := r.signals.PublishSignal(ctx, &domain.Signal{
+		Type:     signalType,
+		RoomID:   roomID,
+		UserID:   user.ID,
+		Nickname: user.Nickname,
+		TTL:      ttl,
+		Reason:   reason,
+	})
+	if err != nil {
+		return fmt.Errorf("service.rooms.publishSanction: %w", err)
+	}
+
+	return nil
+}
+
+// publishRole tells the connected clients of the room that the role of the user changed.
+func (r *RoomProvider) publishRole(ctx context.Context, roomID string, user *domain.User, role domain.Role) error {
+	err := r.signals.PublishSignal(ctx, &domain.Signal{
+		Type:     domain.SignalRoleChanged,
+		RoomID:   roomID,
+		UserID:   user.ID,
+		Nickname: user.Nickname,
+		Role:     role,
+	})
+	if err != nil {
+		return fmt.Errorf("service.rooms.publishRole: %w", err)
+	}
+
+	return nil
+}
+
+// newInviteCode returns a random URL-safe code for invite links.
+func newInviteCode() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
 }
@@ -0,0 +1,138 @@
+package typing
+
+import (
+	"app-websocket/internal/config"
+	"app-websocket/internal/domain"
+	"app-websocket/internal/ports/ws"
+	"context"
+	"fmt"
+	"golang.org/x/time/rate"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// burst is how many typing frames in a row a client may send before it is throttled.
+const burst = 5
+
+type SignalPublisher interface {
+	PublishSignal(ctx context.Context, signal *domain.Signal) error
+}
+
+// state is the typing status of a single connection.
+type state struct {
+	active   bool
+	lastSent time.Time
+	expiry   *time.Timer
+	limiter  *rate.Limiter
+}
+
+// TypingService turns typing frames into signals for the room. Repeated starts are
+// coalesced into one signal per throttle interval, and a client that stops sending
+// them is considered to have stopped typing after ttl.
+type TypingService struct {
+	logger    *slog.Logger
+	publisher SignalPublisher
+	ttl       time.Duration
+	throttle  time.Duration
+
+	mu     sync.Mutex
+	states map[*ws.Client]*state
+}
+
+func New(config *config.ChatConfig, publisher SignalPublisher, logger *slog.Logger) *TypingService {
+	return &TypingService{
+		logger:    logger,
+		publisher: publisher,
+		ttl:       config.TypingTTL,
+		throttle:  config.TypingThrottle,
+		states:    make(map[*ws.Client]*state),
+	}
+}
+
+// Start marks the client as typing. It returns domain.ErrTooManyRequests
+// when the client sends typing frames faster than allowed.
+func (t *TypingService) Start(ctx context.Context, client *ws.Client) error {
+	t.mu.Lock()
+	st, ok := t.states[client]
+	if !ok {
+		st = &state{limiter: rate.NewLimiter(rate.Every(t.throttle/burst), burst)}
+		t.states[client] = st
+	}
+
+	if !st.limiter.Allow() {
+		t.mu.Unlock()
+		return domain.ErrTooManyRequests
+	}
+
+	if st.expiry != nil {
+		st.expiry.Stop()
+	}
+	st.expiry = time.AfterFunc(t.ttl, func() {
+		err := t.Stop(context.Background(), client)
+		if err != nil {
+			t.logger.Error("failed to expire typing", slog.String("ClientID", client.User.ID), slog.String("error", err.Error()))
+		}
+	})
+
+	now := time.Now()
+	publish := !st.active || now.Sub(st.lastSent) >= t.throttle
+	st.active = true
+	if publish {
+		st.lastSent = now
+	}
+	t.mu.Unlock()
+
+	if !publish {
+		return nil
+	}
+
+	return t.publish(ctx, domain.SignalTypingStarted, client)
+}
+
+// Stop marks the client as not typing; it is a no-op for a client that is not typing.
+func (t *TypingService) Stop(ctx context.Context, client *ws.Client) error {
+	t.mu.Lock()
+	st, ok := t.states[client]
+	if !ok || !st.active {
+		t.mu.Unlock()
+		return nil
+	}
+
+	st.active = false
+	if st.expiry != nil {
+		st.expiry.Stop()
+		st.expiry = nil
+	}
+	t.mu.Unlock()
+
+	return t.publish(ctx, domain.SignalTypingStopped, client)
+}
+
+// Forget stops typing of a disconnected client and drops its state.
+func (t *TypingService) Forget(ctx context.Context, client *ws.Client) {
+	err := t.Stop(ctx, client)
+	if err != nil {
+		t.logger.Error("failed to stop typing", slog.String("ClientID", client.User.ID), slog.String("error", err.Error()))
+	}
+
+	t.mu.Lock()
+	delete(t.states, client)
+	t.mu.Unlock()
+}
+
+func (t *TypingService) publish(ctx context.Context, signalType domain.SignalType, client *ws.Client) error {
+	err := t.publisher.PublishSignal(ctx, &domain.Signal{
+		Type:     signalType,
+		RoomID:   client.RoomID,
+		ThreadID: client.ThreadID,
+		UserID:   client.User.ID,
+		Nickname: client.User.Nickname,
+		TTL:      t.ttl,
+	})
+	if err != nil {
+		return fmt.Errorf("service.TypingService.publish: %w", err)
+	}
+
+	return nil
+}
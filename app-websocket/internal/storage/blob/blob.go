@@ -0,0 +1,33 @@
+package blob
+
+import (
+	"app-websocket/internal/config"
+	"context"
+	"fmt"
+	"io"
+)
+
+const (
+	DriverLocal = "local"
+	DriverS3    = "s3"
+)
+
+// Store keeps the content of uploaded files by key. Keys are slash-separated paths made of
+// letters, digits and '.', chosen by the apps, so drivers use them as they are.
+// Get reports a missing key with domain.ErrAttachmentNotFound.
+type Store interface {
+	Put(ctx context.Context, key, contentType string, r io.Reader, size int64) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+func New(config *config.BlobConfig) (Store, error) {
+	switch config.Driver {
+	case DriverLocal:
+		return NewLocal(config.Dir)
+	case DriverS3:
+		return NewS3(&config.S3)
+	default:
+		return nil, fmt.Errorf("storage.blob.New: unknown driver %q", config.Driver)
+	}
+}
@@ -0,0 +1,107 @@
+package blob
+
+import (
+	"app-websocket/internal/domain"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Local keeps files in a directory, one file per key.
+type Local struct {
+	dir string
+}
+
+func NewLocal(dir string) (*Local, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("storage.blob.NewLocal: %w", err)
+	}
+
+	return &Local{
+		dir: dir,
+	}, nil
+}
+
+func (l *Local) path(key string) (string, error) {
+	if !filepath.IsLocal(key) {
+		return "", fmt.Errorf("key %q leaves the directory", key)
+	}
+
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the file next to its final place first, so readers never see a partial file.
+func (l *Local) Put(_ context.Context, key, _ string, r io.Reader, size int64) error {
+	path, err := l.path(key)
+	if err != nil {
+		return fmt.Errorf("storage.blob.Local.Put: %w", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return fmt.Errorf("storage.blob.Local.Put: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("storage.blob.Local.Put: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	written, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("storage.blob.Local.Put: %w", err)
+	}
+
+	if written != size {
+		return fmt.Errorf("storage.blob.Local.Put: wrote %d bytes of %d", written, size)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("storage.blob.Local.Put: %w", err)
+	}
+
+	return nil
+}
+
+func (l *Local) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, fmt.Errorf("storage.blob.Local.Get: %w", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, domain.ErrAttachmentNotFound
+		}
+
+		return nil, fmt.Errorf("storage.blob.Local.Get: %w", err)
+	}
+
+	return file, nil
+}
+
+// Delete removes the file; deleting a missing key is a no-op.
+func (l *Local) Delete(_ context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return fmt.Errorf("storage.blob.Local.Delete: %w", err)
+	}
+
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("storage.blob.Local.Delete: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package blob
+
+import (
+	"app-websocket/internal/config"
+	"app-websocket/internal/domain"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// unsignedPayload lets uploads stream without hashing the body up front; the body is still
+// protected by TLS and its length is part of the request.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3 keeps files in a bucket of an S3-compatible storage such as MinIO.
+// Requests are signed with AWS Signature Version 4.
+type S3 struct {
+	client    *http.Client
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	pathStyle bool
+	accessKey string
+	secretKey string
+}
+
+func NewS3(config *config.S3Config) (*S3, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, fmt.Errorf("storage.blob.NewS3: endpoint and bucket are required")
+	}
+
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("storage.blob.NewS3: %w", err)
+	}
+
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("storage.blob.NewS3: endpoint must be an http or https URL")
+	}
+
+	return &S3{
+		client:    &http.Client{},
+		endpoint:  endpoint,
+		region:    config.Region,
+		bucket:    config.Bucket,
+		pathStyle: config.PathStyle,
+		accessKey: config.AccessKey,
+		secretKey: config.SecretKey,
+	}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key, contentType string, r io.Reader, size int64) error {
+	body := r
+	if size == 0 {
+		body = http.NoBody
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, key, body, size, contentType)
+	if err != nil {
+		return fmt.Errorf("storage.blob.S3.Put: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("storage.blob.S3.Put: %w", responseError(resp))
+	}
+
+	return nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0, "")
+	if err != nil {
+		return nil, fmt.Errorf("storage.blob.S3.Get: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, domain.ErrAttachmentNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("storage.blob.S3.Get: %w", responseError(resp))
+	}
+}
+
+// Delete removes the object; S3 reports success for missing keys as well.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0, "")
+	if err != nil {
+		return fmt.Errorf("storage.blob.S3.Delete: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("storage.blob.S3.Delete: %w", responseError(resp))
+	}
+
+	return nil
+}
+
+func (s *S3) do(ctx context.Context, method, key string, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	objectURL := *s.endpoint
+	if s.pathStyle {
+		objectURL.Path = "/" + s.bucket + "/" + key
+	} else {
+		objectURL.Host = s.bucket + "." + objectURL.Host
+		objectURL.Path = "/" + key
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, objectURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	s.sign(req, time.Now())
+
+	return s.client.Do(req)
+}
+
+// sign adds the Authorization header of Signature Version 4 to the request.
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html.
+func (s *S3) sign(req *http.Request, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + unsignedPayload,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	for _, part := range []string{s.region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// responseError keeps the start of the XML error S3 answers with for the logs.
+func responseError(resp *http.Response) error {
+	buf, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(buf)))
+}
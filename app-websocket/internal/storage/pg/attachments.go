@@ -0,0 +1,75 @@
+package pg
+
+import (
+	"app-websocket/internal/domain"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+)
+
+const selectAttachments = `SELECT id, room_id::text, COALESCE(message_id, ''), uploaded_by::text, name, content_type, size, blob_key,
+		COALESCE(thumbnail_key, ''), COALESCE(width, 0), COALESCE(height, 0), time_created
+	FROM attachments`
+
+func scanAttachment(row pgx.Row, attachment *domain.Attachment) error {
+	return row.Scan(&attachment.ID, &attachment.RoomID, &attachment.MessageID, &attachment.UploadedBy, &attachment.Name,
+		&attachment.ContentType, &attachment.Size, &attachment.BlobKey, &attachment.ThumbnailKey,
+		&attachment.Width, &attachment.Height, &attachment.TimeCreated)
+}
+
+func (pg *Postgres) CreateAttachment(ctx context.Context, attachment *domain.Attachment) error {
+	_, err := pg.pool.Exec(ctx,
+		`INSERT INTO attachments(id, room_id, uploaded_by, name, content_type, size, blob_key, time_created)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		attachment.ID, attachment.RoomID, attachment.UploadedBy, attachment.Name, attachment.ContentType,
+		attachment.Size, attachment.BlobKey, attachment.TimeCreated)
+	if err != nil {
+		return fmt.Errorf("storage.pg.CreateAttachment: %w", err)
+	}
+
+	return nil
+}
+
+func (pg *Postgres) GetAttachment(ctx context.Context, roomID, attachmentID string) (*domain.Attachment, error) {
+	var attachment domain.Attachment
+	err := scanAttachment(pg.pool.QueryRow(ctx, selectAttachments+" WHERE room_id = $1 AND id = $2", roomID, attachmentID), &attachment)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, domain.ErrAttachmentNotFound
+		}
+
+		return nil, fmt.Errorf("storage.pg.GetAttachment: %w", err)
+	}
+
+	return &attachment, nil
+}
+
+// GetUnsentAttachments returns the attachments of the room the user has uploaded and not sent yet,
+// out of the given ones, in the order of upload.
+func (pg *Postgres) GetUnsentAttachments(ctx context.Context, roomID, userID string, attachmentIDs []string) ([]domain.Attachment, error) {
+	rows, err := pg.pool.Query(ctx, selectAttachments+`
+		WHERE room_id = $1 AND uploaded_by = $2 AND id = ANY($3) AND message_id IS NULL
+		ORDER BY time_created, id`, roomID, userID, attachmentIDs)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetUnsentAttachments: %w", err)
+	}
+	defer rows.Close()
+
+	attachments := make([]domain.Attachment, 0)
+	for rows.Next() {
+		var attachment domain.Attachment
+		err = scanAttachment(rows, &attachment)
+		if err != nil {
+			return nil, fmt.Errorf("storage.pg.GetUnsentAttachments: %w", err)
+		}
+
+		attachments = append(attachments, attachment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("storage.pg.GetUnsentAttachments: %w", err)
+	}
+
+	return attachments, nil
+}
@@ -0,0 +1,131 @@
+package pg
+
+import (
+	"app-websocket/internal/domain"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+// directPair orders the two users the way direct_rooms stores them, so that a pair
+// has a single row whoever starts the conversation.
+func directPair(userID, peerID string) (string, string) {
+	if len(userID) < len(peerID) || len(userID) == len(peerID) && userID < peerID {
+		return userID, peerID
+	}
+
+	return peerID, userID
+}
+
+// CreateDirectRoom returns the direct room of the two users, creating it on the first call.
+func (pg *Postgres) CreateDirectRoom(ctx context.Context, userID, peerID string) (*domain.Room, error) {
+	low, high := directPair(userID, peerID)
+
+	room, err := pg.getDirectRoom(ctx, low, high)
+	if err == nil || !errors.Is(err, domain.ErrRoomNotFound) {
+		return room, err
+	}
+
+	tx, err := pg.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.CreateDirectRoom: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// the two users of a direct room know when the other one is there without join messages
+	room = &domain.Room{
+		TimeCreated:  time.Now(),
+		Kind:         domain.RoomKindDirect,
+		Visibility:   domain.RoomPrivate,
+		SystemEvents: false,
+	}
+
+	err = tx.QueryRow(ctx, "INSERT INTO rooms(name, time_created, kind, visibility, system_events) VALUES ('', $1, $2, $3, $4) RETURNING id",
+		room.TimeCreated, room.Kind, room.Visibility, room.SystemEvents).Scan(&room.ID)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.CreateDirectRoom: %w", err)
+	}
+
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO direct_rooms(room_id, user_low, user_high) VALUES ($1, $2, $3)
+			ON CONFLICT (user_low, user_high) DO NOTHING`, room.ID, low, high)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.CreateDirectRoom: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		// the other user created the room concurrently: drop ours and use theirs
+		_ = tx.Rollback(ctx)
+		return pg.getDirectRoom(ctx, low, high)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.CreateDirectRoom: %w", err)
+	}
+
+	return room, nil
+}
+
+func (pg *Postgres) getDirectRoom(ctx context.Context, low, high string) (*domain.Room, error) {
+	room, err := scanRoom(pg.pool.QueryRow(ctx,
+		`SELECT `+roomColumns+` FROM rooms AS r
+			JOIN direct_rooms AS d ON d.room_id = r.id
+			WHERE d.user_low = $1 AND d.user_high = $2 AND r.deleted_at IS NULL`, low, high))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, domain.ErrRoomNotFound
+		}
+
+		return nil, fmt.Errorf("storage.pg.getDirectRoom: %w", err)
+	}
+
+	return room, nil
+}
+
+// GetDirectRooms lists the direct rooms of the user, named after the other member.
+func (pg *Postgres) GetDirectRooms(ctx context.Context, userID string) ([]domain.Room, error) {
+	rows, err := pg.pool.Query(ctx,
+		`SELECT `+roomColumns+`, u.nickname FROM rooms AS r
+			JOIN direct_rooms AS d ON d.room_id = r.id
+			JOIN users AS u ON u.id = CASE WHEN d.user_low = $1 THEN d.user_high ELSE d.user_low END
+			WHERE (d.user_low = $1 OR d.user_high = $1) AND r.deleted_at IS NULL
+			ORDER BY r.time_created DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetDirectRooms: %w", err)
+	}
+	defer rows.Close()
+
+	rooms := make([]domain.Room, 0)
+	for rows.Next() {
+		var peer string
+		room, err := scanRoom(rows, &peer)
+		if err != nil {
+			return nil, fmt.Errorf("storage.pg.GetDirectRooms: %w", err)
+		}
+
+		room.Name = peer
+		rooms = append(rooms, *room)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("storage.pg.GetDirectRooms: %w", err)
+	}
+
+	return rooms, nil
+}
+
+func (pg *Postgres) IsDirectMember(ctx context.Context, roomID, userID string) (bool, error) {
+	row := pg.pool.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM direct_rooms WHERE room_id = $1 AND $2 IN (user_low, user_high))", roomID, userID)
+
+	var isMember bool
+	err := row.Scan(&isMember)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.IsDirectMember: %w", err)
+	}
+
+	return isMember, nil
+}
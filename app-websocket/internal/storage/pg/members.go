@@ -0,0 +1,289 @@
+package pg
+
+import (
+	"app-websocket/internal/domain"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+func (pg *Postgres) IsRoomMember(ctx context.Context, roomID, userID string) (bool, error) {
+	row := pg.pool.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)", roomID, userID)
+
+	var isMember bool
+	err := row.Scan(&isMember)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.IsRoomMember: %w", err)
+	}
+
+	return isMember, nil
+}
+
+// AddRoomMember makes the user a member of the room unless the user is one already.
+func (pg *Postgres) AddRoomMember(ctx context.Context, roomID, userID string) error {
+	_, err := pg.pool.Exec(ctx,
+		`INSERT INTO room_members(room_id, user_id, time_joined) VALUES ($1, $2, $3)
+			ON CONFLICT (room_id, user_id) DO NOTHING`, roomID, userID, time.Now())
+	if err != nil {
+		return fmt.Errorf("storage.pg.AddRoomMember: %w", err)
+	}
+
+	return nil
+}
+
+// GetRoomRole returns the role of the user in the room; users without a stored role are members.
+func (pg *Postgres) GetRoomRole(ctx context.Context, roomID, userID string) (domain.Role, error) {
+	row := pg.pool.QueryRow(ctx, "SELECT role FROM room_members WHERE room_id = $1 AND user_id = $2", roomID, userID)
+
+	var role domain.Role
+	err := row.Scan(&role)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.RoleMember, nil
+		}
+
+		return "", fmt.Errorf("storage.pg.GetRoomRole: %w", err)
+	}
+
+	return role, nil
+}
+
+// GetRoomMembers lists the members of the room, higher roles first.
+func (pg *Postgres) GetRoomMembers(ctx context.Context, roomID string) ([]domain.Member, error) {
+	rows, err := pg.pool.Query(ctx,
+		`SELECT m.user_id, u.nickname, m.role, m.time_joined FROM room_members AS m
+			JOIN users AS u ON u.id = m.user_id
+			WHERE m.room_id = $1
+			ORDER BY CASE m.role WHEN 'owner' THEN 0 WHEN 'admin' THEN 1 WHEN 'moderator' THEN 2 ELSE 3 END, m.time_joined`,
+		roomID)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetRoomMembers: %w", err)
+	}
+	defer rows.Close()
+
+	members := make([]domain.Member, 0)
+	for rows.Next() {
+		var member domain.Member
+		err = rows.Scan(&member.UserID, &member.Nickname, &member.Role, &member.TimeJoined)
+		if err != nil {
+			return nil, fmt.Errorf("storage.pg.GetRoomMembers: %w", err)
+		}
+
+		members = append(members, member)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("storage.pg.GetRoomMembers: %w", err)
+	}
+
+	return members, nil
+}
+
+// SetRoomRole stores the role of the user, making the user a member of the room if needed.
+func (pg *Postgres) SetRoomRole(ctx context.Context, roomID, userID string, role domain.Role) error {
+	_, err := pg.pool.Exec(ctx,
+		`INSERT INTO room_members(room_id, user_id, time_joined, role) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (room_id, user_id) DO UPDATE SET role = EXCLUDED.role`, roomID, userID, time.Now(), role)
+	if err != nil {
+		return fmt.Errorf("storage.pg.SetRoomRole: %w", err)
+	}
+
+	return nil
+}
+
+// TransferOwnership makes the user the owner of the room and the previous owner an admin.
+func (pg *Postgres) TransferOwnership(ctx context.Context, roomID, ownerID, userID string) error {
+	tx, err := pg.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("storage.pg.TransferOwnership: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	_, err = tx.Exec(ctx, "UPDATE room_members SET role = $3 WHERE room_id = $1 AND user_id = $2", roomID, ownerID, domain.RoleAdmin)
+	if err != nil {
+		return fmt.Errorf("storage.pg.TransferOwnership: %w", err)
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO room_members(room_id, user_id, time_joined, role) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (room_id, user_id) DO UPDATE SET role = EXCLUDED.role`, roomID, userID, time.Now(), domain.RoleOwner)
+	if err != nil {
+		return fmt.Errorf("storage.pg.TransferOwnership: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("storage.pg.TransferOwnership: %w", err)
+	}
+
+	return nil
+}
+
+const selectInvites = `SELECT id, room_id, created_by, COALESCE(invitee_id::text, ''), code, expires_at, max_uses, uses, time_created
+	FROM room_invites`
+
+func scanInvite(row pgx.Row) (*domain.Invite, error) {
+	var invite domain.Invite
+	err := row.Scan(&invite.ID, &invite.RoomID, &invite.CreatedBy, &invite.InviteeID, &invite.Code,
+		&invite.ExpiresAt, &invite.MaxUses, &invite.Uses, &invite.TimeCreated)
+	if err != nil {
+		return nil, err
+	}
+
+	return &invite, nil
+}
+
+func (pg *Postgres) CreateInvite(ctx context.Context, invite *domain.Invite) error {
+	var inviteeID *string
+	if invite.InviteeID != "" {
+		inviteeID = &invite.InviteeID
+	}
+
+	_, err := pg.pool.Exec(ctx,
+		`INSERT INTO room_invites(id, room_id, created_by, invitee_id, code, expires_at, max_uses, uses, time_created)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, 0, $8)`,
+		invite.ID, invite.RoomID, invite.CreatedBy, inviteeID, invite.Code, invite.ExpiresAt, invite.MaxUses, invite.TimeCreated)
+	if err != nil {
+		return fmt.Errorf("storage.pg.CreateInvite: %w", err)
+	}
+
+	return nil
+}
+
+func (pg *Postgres) GetInvite(ctx context.Context, roomID, inviteID string) (*domain.Invite, error) {
+	invite, err := scanInvite(pg.pool.QueryRow(ctx, selectInvites+" WHERE room_id = $1 AND id = $2", roomID, inviteID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, domain.ErrInviteNotFound
+		}
+
+		return nil, fmt.Errorf("storage.pg.GetInvite: %w", err)
+	}
+
+	return invite, nil
+}
+
+// GetRoomInvites lists the invites of the room that can still be accepted.
+func (pg *Postgres) GetRoomInvites(ctx context.Context, roomID string) ([]domain.Invite, error) {
+	return pg.queryInvites(ctx, "storage.pg.GetRoomInvites", selectInvites+
+		` WHERE room_id = $1 AND (expires_at IS NULL OR expires_at > $2) AND (max_uses = 0 OR uses < max_uses)
+			ORDER BY time_created DESC`, roomID, time.Now())
+}
+
+// GetUserInvites lists the invites addressed to the user that can still be accepted.
+func (pg *Postgres) GetUserInvites(ctx context.Context, userID string) ([]domain.Invite, error) {
+	return pg.queryInvites(ctx, "storage.pg.GetUserInvites", selectInvites+
+		` WHERE invitee_id = $1 AND (expires_at IS NULL OR expires_at > $2) AND (max_uses = 0 OR uses < max_uses)
+			ORDER BY time_created DESC`, userID, time.Now())
+}
+
+func (pg *Postgres) queryInvites(ctx context.Context, op, query string, args ...any) ([]domain.Invite, error) {
+	rows, err := pg.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	invites := make([]domain.Invite, 0)
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		invites = append(invites, *invite)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return invites, nil
+}
+
+func (pg *Postgres) DeleteInvite(ctx context.Context, roomID, inviteID string) error {
+	tag, err := pg.pool.Exec(ctx, "DELETE FROM room_invites WHERE room_id = $1 AND id = $2", roomID, inviteID)
+	if err != nil {
+		return fmt.Errorf("storage.pg.DeleteInvite: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return domain.ErrInviteNotFound
+	}
+
+	return nil
+}
+
+// AcceptInvite makes the user a member of the invite's room. The invite row is locked,
+// so concurrent acceptances can not use it more than MaxUses times. Accepting again
+// as an existing member does not spend a use.
+func (pg *Postgres) AcceptInvite(ctx context.Context, code, userID string) (*domain.Room, error) {
+	tx, err := pg.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.AcceptInvite: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	invite, err := scanInvite(tx.QueryRow(ctx, selectInvites+" WHERE code = $1 FOR UPDATE", code))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, domain.ErrInviteNotFound
+		}
+
+		return nil, fmt.Errorf("storage.pg.AcceptInvite: %w", err)
+	}
+
+	if invite.InviteeID != "" && invite.InviteeID != userID {
+		return nil, domain.ErrInviteNotFound
+	}
+
+	now := time.Now()
+
+	// expires_at keeps the wall clock without zone, so compare it the way it is stored
+	var expired bool
+	err = tx.QueryRow(ctx, "SELECT COALESCE(expires_at <= $2, FALSE) FROM room_invites WHERE id = $1", invite.ID, now).Scan(&expired)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.AcceptInvite: %w", err)
+	}
+
+	if expired {
+		return nil, domain.ErrInviteExpired
+	}
+
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO room_members(room_id, user_id, time_joined) VALUES ($1, $2, $3)
+			ON CONFLICT (room_id, user_id) DO NOTHING`, invite.RoomID, userID, now)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.AcceptInvite: %w", err)
+	}
+
+	if tag.RowsAffected() > 0 {
+		if invite.MaxUses > 0 && invite.Uses >= invite.MaxUses {
+			return nil, domain.ErrInviteUsedUp
+		}
+
+		_, err = tx.Exec(ctx, "UPDATE room_invites SET uses = uses + 1 WHERE id = $1", invite.ID)
+		if err != nil {
+			return nil, fmt.Errorf("storage.pg.AcceptInvite: %w", err)
+		}
+	}
+
+	room, err := scanRoom(tx.QueryRow(ctx, "SELECT "+roomColumns+" FROM rooms AS r WHERE r.id = $1 AND r.deleted_at IS NULL", invite.RoomID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, domain.ErrInviteNotFound
+		}
+
+		return nil, fmt.Errorf("storage.pg.AcceptInvite: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.AcceptInvite: %w", err)
+	}
+
+	return room, nil
+}
@@ -0,0 +1,28 @@
+package pg
+
+import (
+	"app-websocket/internal/domain"
+	"context"
+	"fmt"
+)
+
+// GetUnreadMentions returns up to count messages mentioning the user that are past
+// the user's read position in their rooms, newest first.
+func (pg *Postgres) GetUnreadMentions(ctx context.Context, userID string, count int) ([]domain.Message, error) {
+	rows, err := pg.pool.Query(ctx, selectMessages+`
+		JOIN message_mentions AS mm ON mm.message_id = m.message_id
+		LEFT JOIN room_reads AS rr ON rr.room_id = m.room_id AND rr.user_id = mm.user_id
+		WHERE mm.user_id = $1 AND m.seq > COALESCE(rr.seq, 0) AND m.deleted_at IS NULL
+		ORDER BY m.time_created DESC, m.message_id DESC
+		LIMIT $2`, userID, count)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetUnreadMentions: %w", err)
+	}
+
+	messages, err := collectMessages(rows)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetUnreadMentions: %w", err)
+	}
+
+	return messages, nil
+}
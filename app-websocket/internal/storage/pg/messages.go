@@ -0,0 +1,199 @@
+package pg
+
+import (
+	"app-websocket/internal/domain"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"slices"
+)
+
+const selectMessages = `SELECT m.message_id, m.seq, m.kind, COALESCE(m.system_event, ''), m.content, u.nickname, m.user_id, m.room_id, m.time_created, m.edited_at,
+		COALESCE(m.deleted_by::text, ''), m.deleted_at, COALESCE(m.reply_to, ''), COALESCE(m.thread_id, ''), m.reply_count, m.last_reply_at,
+		(SELECT json_object_agg(r.emoji, r.count) FROM (
+			SELECT emoji, COUNT(*) AS count FROM message_reactions WHERE message_id = m.message_id GROUP BY emoji
+		) AS r),
+		ARRAY(SELECT user_id::text FROM message_mentions WHERE message_id = m.message_id),
+		COALESCE(m.pinned_by::text, ''), m.pinned_at,
+		(SELECT json_agg(json_build_object('ID', a.id, 'RoomID', a.room_id::text, 'MessageID', a.message_id,
+			'UploadedBy', a.uploaded_by::text, 'Name', a.name, 'ContentType', a.content_type, 'Size', a.size, 'BlobKey', a.blob_key,
+			'ThumbnailKey', COALESCE(a.thumbnail_key, ''), 'Width', COALESCE(a.width, 0), 'Height', COALESCE(a.height, 0)
+		) ORDER BY a.time_created, a.id) FROM attachments AS a WHERE a.message_id = m.message_id)
+	FROM messages AS m
+	JOIN users AS u ON m.user_id = u.id`
+
+// scanMessage reads the columns of selectMessages followed by extra columns into extra.
+func scanMessage(row pgx.Row, msg *domain.Message, extra ...any) error {
+	dest := append([]any{&msg.ID, &msg.Seq, &msg.Kind, &msg.Event, &msg.Content, &msg.Nickname, &msg.UserID, &msg.RoomID, &msg.TimeCreated, &msg.EditedAt,
+		&msg.DeletedBy, &msg.DeletedAt, &msg.ReplyTo, &msg.ThreadID, &msg.ReplyCount, &msg.LastReplyAt,
+		&msg.Reactions, &msg.Mentions, &msg.PinnedBy, &msg.PinnedAt, &msg.Attachments}, extra...)
+
+	return row.Scan(dest...)
+}
+
+func collectMessages(rows pgx.Rows) ([]domain.Message, error) {
+	defer rows.Close()
+
+	messages := make([]domain.Message, 0)
+	for rows.Next() {
+		var msg domain.Message
+		err := scanMessage(rows, &msg)
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
+
+func (pg *Postgres) GetLastMessagesFromRoom(ctx context.Context, roomID string, count int) ([]domain.Message, error) {
+	rows, err := pg.pool.Query(ctx, selectMessages+`
+		WHERE m.room_id = $1
+		ORDER BY m.time_created DESC, m.message_id DESC
+		LIMIT $2`, roomID, count)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetLastMessagesFromRoom: %w", err)
+	}
+
+	messages, err := collectMessages(rows)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetLastMessagesFromRoom: %w", err)
+	}
+
+	return messages, nil
+}
+
+// GetMessagesPage runs a keyset query over (room_id, time_created, message_id) and returns
+// up to page.Limit messages newest first. When only After is set the window is filled
+// from the oldest side, so it continues right after the cursor.
+func (pg *Postgres) GetMessagesPage(ctx context.Context, roomID string, page *domain.MessagesPage) ([]domain.Message, error) {
+	query := selectMessages + " WHERE m.room_id = $1"
+	args := []any{roomID}
+
+	if page.ThreadID != "" {
+		args = append(args, page.ThreadID)
+		query += fmt.Sprintf(" AND m.thread_id = $%d", len(args))
+	}
+
+	if page.ExcludeSystem {
+		query += " AND m.kind = 'user'"
+	}
+
+	if page.Before != nil {
+		args = append(args, page.Before.TimeCreated, page.Before.ID)
+		query += fmt.Sprintf(" AND (m.time_created, m.message_id) < ($%d, $%d)", len(args)-1, len(args))
+	}
+
+	if page.After != nil {
+		args = append(args, page.After.TimeCreated, page.After.ID)
+		query += fmt.Sprintf(" AND (m.time_created, m.message_id) > ($%d, $%d)", len(args)-1, len(args))
+	}
+
+	ascending := page.After != nil && page.Before == nil
+	if ascending {
+		query += " ORDER BY m.time_created, m.message_id"
+	} else {
+		query += " ORDER BY m.time_created DESC, m.message_id DESC"
+	}
+
+	args = append(args, page.Limit)
+	query += fmt.Sprintf(" LIMIT $%d", len(args))
+
+	rows, err := pg.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetMessagesPage: %w", err)
+	}
+
+	messages, err := collectMessages(rows)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetMessagesPage: %w", err)
+	}
+
+	if ascending {
+		slices.Reverse(messages)
+	}
+
+	return messages, nil
+}
+
+// GetMessagesSince returns up to count messages with sequence numbers greater than since, newest first.
+func (pg *Postgres) GetMessagesSince(ctx context.Context, roomID string, since int64, count int) ([]domain.Message, error) {
+	rows, err := pg.pool.Query(ctx, selectMessages+`
+		WHERE m.room_id = $1 AND m.seq > $2
+		ORDER BY m.seq DESC
+		LIMIT $3`, roomID, since, count)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetMessagesSince: %w", err)
+	}
+
+	messages, err := collectMessages(rows)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetMessagesSince: %w", err)
+	}
+
+	return messages, nil
+}
+
+// GetThreadMessagesSince returns up to count messages of the thread, the root included,
+// with sequence numbers greater than since, newest first.
+func (pg *Postgres) GetThreadMessagesSince(ctx context.Context, roomID, rootID string, since int64, count int) ([]domain.Message, error) {
+	rows, err := pg.pool.Query(ctx, selectMessages+`
+		WHERE m.room_id = $1 AND (m.message_id = $2 OR m.thread_id = $2) AND m.seq > $3
+		ORDER BY m.seq DESC
+		LIMIT $4`, roomID, rootID, since, count)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetThreadMessagesSince: %w", err)
+	}
+
+	messages, err := collectMessages(rows)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetThreadMessagesSince: %w", err)
+	}
+
+	return messages, nil
+}
+
+func (pg *Postgres) GetMessage(ctx context.Context, roomID, messageID string) (*domain.Message, error) {
+	row := pg.pool.QueryRow(ctx, selectMessages+" WHERE m.room_id = $1 AND m.message_id = $2", roomID, messageID)
+
+	var msg domain.Message
+	err := scanMessage(row, &msg)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, domain.ErrMessageNotFound
+		}
+
+		return nil, fmt.Errorf("storage.pg.GetMessage: %w", err)
+	}
+
+	return &msg, nil
+}
+
+func (pg *Postgres) GetLastSeq(ctx context.Context, roomID string) (int64, error) {
+	row := pg.pool.QueryRow(ctx, "SELECT COALESCE(MAX(seq), 0) FROM messages WHERE room_id = $1", roomID)
+
+	var seq int64
+	err := row.Scan(&seq)
+	if err != nil {
+		return 0, fmt.Errorf("storage.pg.GetLastSeq: %w", err)
+	}
+
+	return seq, nil
+}
+
+func (pg *Postgres) HasReaction(ctx context.Context, reaction *domain.Reaction) (bool, error) {
+	row := pg.pool.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3)",
+		reaction.MessageID, reaction.UserID, reaction.Emoji)
+
+	var exists bool
+	err := row.Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("storage.pg.HasReaction: %w", err)
+	}
+
+	return exists, nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"strings"
 	"time"
 )
 
@@ -40,36 +41,6 @@ func (pg *Postgres) CloseConnection() {
 	pg.pool.Close()
 }
 
-func (pg *Postgres) GetLastMessagesFromRoom(ctx context.Context, roomID string, count int) ([]domain.Message, error) {
-	rows, err := pg.pool.Query(ctx,
-		`SELECT m.content, u.nickname, m.user_id, m.time_created FROM messages AS m 
-    		JOIN users AS u ON m.user_id = u.id 
-            WHERE m.room_id = $1
-            ORDER BY time_created
-            LIMIT $2`, roomID, count)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return []domain.Message{}, nil
-		}
-
-		return nil, fmt.Errorf("storage.pg.GetLastMessagesFromRoom: %w", err)
-	}
-	defer rows.Close()
-
-	var messages []domain.Message
-	for rows.Next() {
-		msg := domain.Message{}
-		err = rows.Scan(&msg.Content, &msg.Nickname, &msg.UserID, &msg.TimeCreated)
-		if err != nil {
-			return nil, fmt.Errorf("storage.pg.GetLastMessagesFromRoom: %w", err)
-		}
-
-		messages = append(messages, msg)
-	}
-
-	return messages, nil
-}
-
 func (pg *Postgres) SaveUser(ctx context.Context, user *domain.User) (string, error) {
 	row := pg.pool.QueryRow(ctx, "INSERT INTO users(nickname, password_hash) VALUES ($1, $2) RETURNING id", user.Nickname, user.PasswordHash)
 
@@ -127,53 +98,204 @@ func (pg *Postgres) GetBySession(ctx context.Context, refreshToken string) (*dom
 	return &user, nil
 }
 
-func (pg *Postgres) GetAllRooms(ctx context.Context) ([]domain.Room, error) {
-	rows, err := pg.pool.Query(ctx, "SELECT id, name, time_created FROM rooms")
+// IsModerator reports whether the user moderates the whole service.
+func (pg *Postgres) IsModerator(ctx context.Context, userID string) (bool, error) {
+	row := pg.pool.QueryRow(ctx, "SELECT is_moderator FROM users WHERE id = $1", userID)
+
+	var isModerator bool
+	err := row.Scan(&isModerator)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return []domain.Room{}, nil
+			return false, nil
 		}
 
-		return nil, fmt.Errorf("storage.pg.GetRooms: %w", err)
+		return false, fmt.Errorf("storage.pg.IsModerator: %w", err)
+	}
+
+	return isModerator, nil
+}
+
+// GetRoomUsersByNicknames returns the users with the given nicknames who have access to the room.
+func (pg *Postgres) GetRoomUsersByNicknames(ctx context.Context, roomID string, nicknames []string) ([]domain.User, error) {
+	rows, err := pg.pool.Query(ctx,
+		`SELECT u.id, u.nickname FROM users AS u
+			JOIN rooms AS r ON r.id = $1
+			WHERE u.nickname = ANY($2) AND CASE
+				WHEN r.kind = $3 THEN EXISTS (SELECT 1 FROM direct_rooms AS d WHERE d.room_id = r.id AND u.id IN (d.user_low, d.user_high))
+				WHEN r.visibility = $4 THEN EXISTS (SELECT 1 FROM room_members AS m WHERE m.room_id = r.id AND m.user_id = u.id)
+				ELSE TRUE END`,
+		roomID, nicknames, domain.RoomKindDirect, domain.RoomPrivate)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetRoomUsersByNicknames: %w", err)
 	}
 	defer rows.Close()
 
-	var rooms []domain.Room
+	var users []domain.User
 	for rows.Next() {
-		var room domain.Room
-		err = rows.Scan(&room.ID, &room.Name, &room.TimeCreated)
+		var user domain.User
+		err = rows.Scan(&user.ID, &user.Nickname)
 		if err != nil {
-			return nil, fmt.Errorf("storage.pg.GetRooms: %w", err)
+			return nil, fmt.Errorf("storage.pg.GetRoomUsersByNicknames: %w", err)
 		}
 
-		rooms = append(rooms, room)
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("storage.pg.GetRoomUsersByNicknames: %w", err)
 	}
 
-	return rooms, nil
+	return users, nil
 }
 
-func (pg *Postgres) CreateRoom(ctx context.Context, name string) (*domain.Room, error) {
-	timeCreated := time.Now()
-	row := pg.pool.QueryRow(ctx, "INSERT INTO rooms(name, time_created) VALUES ($1, $2) RETURNING id", name, timeCreated)
+// roomSort is how a room list of some order is sorted: by key, then by ID in the same direction.
+type roomSort struct {
+	key  string // SQL expression over rooms AS r and the member count mc.members
+	cast string // type of the key, the cursor keeps it as text
+	desc bool
+}
 
-	var id string
-	err := row.Scan(&id)
+var roomSorts = map[domain.RoomSort]roomSort{
+	domain.RoomSortActivity: {key: "COALESCE(r.last_message_at, r.time_created)", cast: "timestamp", desc: true},
+	domain.RoomSortCreated:  {key: "r.time_created", cast: "timestamp", desc: true},
+	domain.RoomSortMembers:  {key: "mc.members", cast: "bigint", desc: true},
+	domain.RoomSortName:     {key: "lower(r.name)", cast: "text"},
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// GetRooms returns a page of the public rooms and the private rooms the user is a member of,
+// either the active or the archived ones, with their member counts. It runs a keyset query
+// in the order of query.Sort and returns the cursor of the next page, nil on the last one.
+func (pg *Postgres) GetRooms(ctx context.Context, query *domain.RoomsQuery) ([]domain.Room, *domain.RoomCursor, error) {
+	order, ok := roomSorts[query.Sort]
+	if !ok {
+		return nil, nil, fmt.Errorf("storage.pg.GetRooms: unknown sort %q", query.Sort)
+	}
+
+	sql := `SELECT ` + roomColumns + `, mc.members, (` + order.key + `)::text FROM rooms AS r
+		CROSS JOIN LATERAL (SELECT COUNT(*) AS members FROM room_members AS m WHERE m.room_id = r.id) AS mc
+		WHERE r.kind = $1 AND r.deleted_at IS NULL AND (r.archived_at IS NOT NULL) = $2`
+	args := []any{domain.RoomKindRoom, query.Archived, query.UserID}
+
+	member := "EXISTS (SELECT 1 FROM room_members AS m WHERE m.room_id = r.id AND m.user_id = $3)"
+	if query.Member {
+		sql += " AND " + member
+	} else {
+		args = append(args, domain.RoomPublic)
+		sql += fmt.Sprintf(" AND (r.visibility = $%d OR %s)", len(args), member)
+	}
+
+	if query.Search != "" {
+		args = append(args, "%"+likeEscaper.Replace(query.Search)+"%")
+		sql += fmt.Sprintf(" AND r.name ILIKE $%d", len(args))
+	}
+
+	direction, compare := "", ">"
+	if order.desc {
+		direction, compare = " DESC", "<"
+	}
+
+	if query.After != nil {
+		args = append(args, query.After.Key, query.After.ID)
+		sql += fmt.Sprintf(" AND (%s, r.id) %s ($%d::text::%s, $%d::text::integer)",
+			order.key, compare, len(args)-1, order.cast, len(args))
+	}
+
+	// ask for one extra room to learn whether there is a next page
+	args = append(args, query.Limit+1)
+	sql += fmt.Sprintf(" ORDER BY %s%s, r.id%s LIMIT $%d", order.key, direction, direction, len(args))
+
+	rows, err := pg.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("storage.pg.GetRooms: %w", err)
+	}
+	defer rows.Close()
+
+	rooms := make([]domain.Room, 0, query.Limit+1)
+	keys := make([]string, 0, query.Limit+1)
+	for rows.Next() {
+		var members int
+		var key string
+		room, err := scanRoom(rows, &members, &key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("storage.pg.GetRooms: %w", err)
+		}
+
+		room.Members = members
+		rooms = append(rooms, *room)
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("storage.pg.GetRooms: %w", err)
+	}
+
+	if len(rooms) <= query.Limit {
+		return rooms, nil, nil
+	}
+
+	rooms = rooms[:query.Limit]
+	next := &domain.RoomCursor{Sort: query.Sort, Key: keys[query.Limit-1], ID: rooms[query.Limit-1].ID}
+
+	return rooms, next, nil
+}
+
+// CreateRoom creates the room and makes its creator the owner.
+func (pg *Postgres) CreateRoom(ctx context.Context, name, visibility, creatorID string) (*domain.Room, error) {
+	tx, err := pg.pool.Begin(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("storage.pg.CreateRoom: %w", err)
 	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	room := &domain.Room{
+		Name:         name,
+		TimeCreated:  time.Now(),
+		Kind:         domain.RoomKindRoom,
+		Visibility:   visibility,
+		SystemEvents: true,
+	}
 
-	return &domain.Room{
-		ID:          id,
-		Name:        name,
-		TimeCreated: timeCreated,
-	}, nil
+	err = tx.QueryRow(ctx, "INSERT INTO rooms(name, time_created, kind, visibility, system_events) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		room.Name, room.TimeCreated, room.Kind, room.Visibility, room.SystemEvents).Scan(&room.ID)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.CreateRoom: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, "INSERT INTO room_members(room_id, user_id, time_joined, role) VALUES ($1, $2, $3, $4)",
+		room.ID, creatorID, room.TimeCreated, domain.RoleOwner)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.CreateRoom: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.CreateRoom: %w", err)
+	}
+
+	return room, nil
 }
 
-func (pg *Postgres) GetRoom(ctx context.Context, roomID string) (*domain.Room, error) {
-	row := pg.pool.QueryRow(ctx, "SELECT id, name, time_created FROM rooms WHERE id = $1", roomID)
+// GetUserByID returns the user without the password hash.
+func (pg *Postgres) GetUserByID(ctx context.Context, userID string) (*domain.User, error) {
+	row := pg.pool.QueryRow(ctx, "SELECT id, nickname FROM users WHERE id = $1", userID)
 
-	var room domain.Room
-	err := row.Scan(&room.ID, &room.Name, &room.TimeCreated)
+	var user domain.User
+	err := row.Scan(&user.ID, &user.Nickname)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, domain.ErrPeerNotFound
+		}
+
+		return nil, fmt.Errorf("storage.pg.GetUserByID: %w", err)
+	}
+
+	return &user, nil
+}
+
+func (pg *Postgres) GetRoom(ctx context.Context, roomID string) (*domain.Room, error) {
+	room, err := scanRoom(pg.pool.QueryRow(ctx, "SELECT "+roomColumns+" FROM rooms AS r WHERE r.id = $1 AND r.deleted_at IS NULL", roomID))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, domain.ErrRoomNotFound
@@ -181,5 +303,5 @@ func (pg *Postgres) GetRoom(ctx context.Context, roomID string) (*domain.Room, e
 		return nil, fmt.Errorf("storage.pg.GetRoom: %w", err)
 	}
 
-	return &room, nil
+	return room, nil
 }
@@ -0,0 +1,37 @@
+package pg
+
+import (
+	"app-websocket/internal/domain"
+	"context"
+	"fmt"
+)
+
+// GetPinnedMessages returns the pinned messages of the room, the latest pinned first.
+func (pg *Postgres) GetPinnedMessages(ctx context.Context, roomID string) ([]domain.Message, error) {
+	rows, err := pg.pool.Query(ctx, selectMessages+`
+		WHERE m.room_id = $1 AND m.pinned_at IS NOT NULL AND m.deleted_at IS NULL
+		ORDER BY m.pinned_at DESC`, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetPinnedMessages: %w", err)
+	}
+
+	messages, err := collectMessages(rows)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetPinnedMessages: %w", err)
+	}
+
+	return messages, nil
+}
+
+func (pg *Postgres) CountPinnedMessages(ctx context.Context, roomID string) (int, error) {
+	row := pg.pool.QueryRow(ctx,
+		"SELECT COUNT(*) FROM messages WHERE room_id = $1 AND pinned_at IS NOT NULL AND deleted_at IS NULL", roomID)
+
+	var count int
+	err := row.Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("storage.pg.CountPinnedMessages: %w", err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,88 @@
+package pg
+
+import (
+	"app-websocket/internal/domain"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+)
+
+func (pg *Postgres) GetReadReceipts(ctx context.Context, roomID string) ([]domain.ReadReceipt, error) {
+	rows, err := pg.pool.Query(ctx,
+		`SELECT r.room_id, r.user_id, u.nickname, r.message_id, r.seq, r.time_read
+			FROM room_reads AS r
+			JOIN users AS u ON r.user_id = u.id
+			WHERE r.room_id = $1`, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetReadReceipts: %w", err)
+	}
+	defer rows.Close()
+
+	receipts := make([]domain.ReadReceipt, 0)
+	for rows.Next() {
+		var receipt domain.ReadReceipt
+		err = rows.Scan(&receipt.RoomID, &receipt.UserID, &receipt.Nickname, &receipt.MessageID, &receipt.Seq, &receipt.TimeRead)
+		if err != nil {
+			return nil, fmt.Errorf("storage.pg.GetReadReceipts: %w", err)
+		}
+
+		receipts = append(receipts, receipt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("storage.pg.GetReadReceipts: %w", err)
+	}
+
+	return receipts, nil
+}
+
+// GetReadSeq returns the sequence number of the last message of the room the user has read, 0 if none.
+func (pg *Postgres) GetReadSeq(ctx context.Context, roomID, userID string) (int64, error) {
+	row := pg.pool.QueryRow(ctx, "SELECT seq FROM room_reads WHERE room_id = $1 AND user_id = $2", roomID, userID)
+
+	var seq int64
+	err := row.Scan(&seq)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("storage.pg.GetReadSeq: %w", err)
+	}
+
+	return seq, nil
+}
+
+// GetUnreadCounts returns the number of messages by other users the user has not read yet
+// in each of the rooms. System messages are not counted.
+// Rooms without unread messages are left out.
+func (pg *Postgres) GetUnreadCounts(ctx context.Context, userID string, roomIDs []string) (map[string]int, error) {
+	rows, err := pg.pool.Query(ctx,
+		`SELECT m.room_id, COUNT(*) FROM messages AS m
+			LEFT JOIN room_reads AS r ON r.room_id = m.room_id AND r.user_id = $1
+			WHERE m.room_id = ANY($2) AND m.seq > COALESCE(r.seq, 0) AND m.user_id <> $1 AND m.kind = 'user' AND m.deleted_at IS NULL
+			GROUP BY m.room_id`, userID, roomIDs)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetUnreadCounts: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var roomID string
+		var count int
+		err = rows.Scan(&roomID, &count)
+		if err != nil {
+			return nil, fmt.Errorf("storage.pg.GetUnreadCounts: %w", err)
+		}
+
+		counts[roomID] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("storage.pg.GetUnreadCounts: %w", err)
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,103 @@
+package pg
+
+import (
+	"app-websocket/internal/domain"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+// roomColumns are the columns of rooms AS r that scanRoom reads.
+const roomColumns = "r.id, r.name, r.time_created, r.kind, r.visibility, r.topic, r.description, r.avatar_url, r.archived_at, r.last_message_at, r.system_events"
+
+// scanRoom reads roomColumns followed by extra columns into extra.
+func scanRoom(row pgx.Row, extra ...any) (*domain.Room, error) {
+	var room domain.Room
+	dest := append([]any{&room.ID, &room.Name, &room.TimeCreated, &room.Kind, &room.Visibility,
+		&room.Topic, &room.Description, &room.AvatarURL, &room.ArchivedAt, &room.LastMessage, &room.SystemEvents}, extra...)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &room, nil
+}
+
+// UpdateRoom changes the fields of the room set in update and returns the room as it is now.
+func (pg *Postgres) UpdateRoom(ctx context.Context, roomID string, update *domain.RoomUpdate) (*domain.Room, error) {
+	room, err := scanRoom(pg.pool.QueryRow(ctx,
+		`UPDATE rooms AS r SET name = COALESCE($2, r.name), topic = COALESCE($3, r.topic),
+				description = COALESCE($4, r.description), avatar_url = COALESCE($5, r.avatar_url),
+				system_events = COALESCE($6, r.system_events)
+			WHERE r.id = $1 AND r.deleted_at IS NULL
+			RETURNING `+roomColumns,
+		roomID, update.Name, update.Topic, update.Description, update.AvatarURL, update.SystemEvents))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, domain.ErrRoomNotFound
+		}
+
+		return nil, fmt.Errorf("storage.pg.UpdateRoom: %w", err)
+	}
+
+	return room, nil
+}
+
+// SetRoomArchived archives or restores the room and returns it as it is now.
+// Archiving an archived room keeps the original time.
+func (pg *Postgres) SetRoomArchived(ctx context.Context, roomID string, archived bool) (*domain.Room, error) {
+	var archivedAt *time.Time
+	if archived {
+		now := time.Now()
+		archivedAt = &now
+	}
+
+	room, err := scanRoom(pg.pool.QueryRow(ctx,
+		`UPDATE rooms AS r SET archived_at = CASE WHEN $2::timestamp IS NULL THEN NULL ELSE COALESCE(r.archived_at, $2) END
+			WHERE r.id = $1 AND r.deleted_at IS NULL
+			RETURNING `+roomColumns, roomID, archivedAt))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, domain.ErrRoomNotFound
+		}
+
+		return nil, fmt.Errorf("storage.pg.SetRoomArchived: %w", err)
+	}
+
+	return room, nil
+}
+
+// MarkRoomDeleted hides the room at once. Its content is purged later by app-consumer,
+// the row stays so that events still in flight for the room are recognised and dropped.
+// A deleted direct room lets go of its pair of users, so their next conversation gets a new room.
+func (pg *Postgres) MarkRoomDeleted(ctx context.Context, roomID string) error {
+	tx, err := pg.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("storage.pg.MarkRoomDeleted: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	tag, err := tx.Exec(ctx, "UPDATE rooms SET deleted_at = $2 WHERE id = $1 AND deleted_at IS NULL", roomID, time.Now())
+	if err != nil {
+		return fmt.Errorf("storage.pg.MarkRoomDeleted: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return domain.ErrRoomNotFound
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM direct_rooms WHERE room_id = $1", roomID)
+	if err != nil {
+		return fmt.Errorf("storage.pg.MarkRoomDeleted: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("storage.pg.MarkRoomDeleted: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package pg
+
+import (
+	"app-websocket/internal/domain"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+// SaveSanction bans or mutes the user in the room, replacing the previous sanction of the same kind.
+func (pg *Postgres) SaveSanction(ctx context.Context, sanction *domain.Sanction) error {
+	_, err := pg.pool.Exec(ctx,
+		`INSERT INTO room_sanctions(room_id, user_id, kind, reason, created_by, expires_at, time_created)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (room_id, user_id, kind) DO UPDATE SET reason = EXCLUDED.reason, created_by = EXCLUDED.created_by,
+				expires_at = EXCLUDED.expires_at, time_created = EXCLUDED.time_created`,
+		sanction.RoomID, sanction.UserID, sanction.Kind, sanction.Reason, sanction.CreatedBy, sanction.ExpiresAt, sanction.TimeCreated)
+	if err != nil {
+		return fmt.Errorf("storage.pg.SaveSanction: %w", err)
+	}
+
+	return nil
+}
+
+func (pg *Postgres) DeleteSanction(ctx context.Context, roomID, userID string, kind domain.SanctionKind) error {
+	_, err := pg.pool.Exec(ctx, "DELETE FROM room_sanctions WHERE room_id = $1 AND user_id = $2 AND kind = $3", roomID, userID, kind)
+	if err != nil {
+		return fmt.Errorf("storage.pg.DeleteSanction: %w", err)
+	}
+
+	return nil
+}
+
+// GetActiveSanction returns the sanction of the kind the user is under in the room, nil if there is none.
+func (pg *Postgres) GetActiveSanction(ctx context.Context, roomID, userID string, kind domain.SanctionKind) (*domain.Sanction, error) {
+	row := pg.pool.QueryRow(ctx,
+		`SELECT s.room_id, s.user_id, u.nickname, s.kind, s.reason, s.created_by, s.expires_at, s.time_created
+			FROM room_sanctions AS s
+			JOIN users AS u ON u.id = s.user_id
+			WHERE s.room_id = $1 AND s.user_id = $2 AND s.kind = $3 AND (s.expires_at IS NULL OR s.expires_at > $4)`,
+		roomID, userID, kind, time.Now())
+
+	var sanction domain.Sanction
+	err := row.Scan(&sanction.RoomID, &sanction.UserID, &sanction.Nickname, &sanction.Kind, &sanction.Reason,
+		&sanction.CreatedBy, &sanction.ExpiresAt, &sanction.TimeCreated)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("storage.pg.GetActiveSanction: %w", err)
+	}
+
+	return &sanction, nil
+}
+
+// GetSanctions lists the sanctions of the kind in force in the room, latest first.
+func (pg *Postgres) GetSanctions(ctx context.Context, roomID string, kind domain.SanctionKind) ([]domain.Sanction, error) {
+	rows, err := pg.pool.Query(ctx,
+		`SELECT s.room_id, s.user_id, u.nickname, s.kind, s.reason, s.created_by, s.expires_at, s.time_created
+			FROM room_sanctions AS s
+			JOIN users AS u ON u.id = s.user_id
+			WHERE s.room_id = $1 AND s.kind = $2 AND (s.expires_at IS NULL OR s.expires_at > $3)
+			ORDER BY s.time_created DESC`,
+		roomID, kind, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.GetSanctions: %w", err)
+	}
+	defer rows.Close()
+
+	sanctions := make([]domain.Sanction, 0)
+	for rows.Next() {
+		var sanction domain.Sanction
+		err = rows.Scan(&sanction.RoomID, &sanction.UserID, &sanction.Nickname, &sanction.Kind, &sanction.Reason,
+			&sanction.CreatedBy, &sanction.ExpiresAt, &sanction.TimeCreated)
+		if err != nil {
+			return nil, fmt.Errorf("storage.pg.GetSanctions: %w", err)
+		}
+
+		sanctions = append(sanctions, sanction)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("storage.pg.GetSanctions: %w", err)
+	}
+
+	return sanctions, nil
+}
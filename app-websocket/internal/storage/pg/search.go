@@ -0,0 +1,100 @@
+package pg
+
+import (
+	"app-websocket/internal/domain"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// headlineOptions make ts_headline mark the matched words and cut long messages down to a few fragments.
+var headlineOptions = fmt.Sprintf("StartSel=%s, StopSel=%s, MaxFragments=2, MaxWords=20, MinWords=8",
+	domain.HighlightStart, domain.HighlightStop)
+
+// SearchMessages runs a full-text query over messages.search_vector, which app-consumer fills in,
+// in the rooms the user may read: public rooms unless banned there, private rooms of the user
+// and direct rooms with the user. The page is a keyset window over (time_created, message_id)
+// like in GetMessagesPage; snippets are built for the messages of the page only.
+func (pg *Postgres) SearchMessages(ctx context.Context, search *domain.MessageSearch) ([]domain.SearchHit, error) {
+	query := selectMessages + `
+		JOIN rooms AS rm ON rm.id = m.room_id
+		WHERE m.search_vector @@ websearch_to_tsquery('simple', $1) AND m.deleted_at IS NULL AND rm.deleted_at IS NULL
+			AND CASE
+				WHEN rm.kind = $3 THEN EXISTS (SELECT 1 FROM direct_rooms AS d WHERE d.room_id = rm.id AND $4 IN (d.user_low, d.user_high))
+				WHEN rm.visibility = $5 THEN EXISTS (SELECT 1 FROM room_members AS mb WHERE mb.room_id = rm.id AND mb.user_id = $4)
+				ELSE TRUE END
+			AND NOT EXISTS (SELECT 1 FROM room_sanctions AS s
+				WHERE s.room_id = rm.id AND s.user_id = $4 AND s.kind = $6 AND (s.expires_at IS NULL OR s.expires_at > $7))`
+	args := []any{search.Query, headlineOptions, domain.RoomKindDirect, search.UserID, domain.RoomPrivate, domain.SanctionBan, time.Now()}
+
+	if search.RoomID != "" {
+		args = append(args, search.RoomID)
+		query += fmt.Sprintf(" AND m.room_id = $%d", len(args))
+	}
+
+	if search.Author != "" {
+		args = append(args, search.Author)
+		query += fmt.Sprintf(" AND u.nickname = $%d", len(args))
+	}
+
+	if search.Since != nil {
+		args = append(args, *search.Since)
+		query += fmt.Sprintf(" AND m.time_created >= $%d", len(args))
+	}
+
+	if search.Until != nil {
+		args = append(args, *search.Until)
+		query += fmt.Sprintf(" AND m.time_created < $%d", len(args))
+	}
+
+	page := &search.Page
+	if page.Before != nil {
+		args = append(args, page.Before.TimeCreated, page.Before.ID)
+		query += fmt.Sprintf(" AND (m.time_created, m.message_id) < ($%d, $%d)", len(args)-1, len(args))
+	}
+
+	if page.After != nil {
+		args = append(args, page.After.TimeCreated, page.After.ID)
+		query += fmt.Sprintf(" AND (m.time_created, m.message_id) > ($%d, $%d)", len(args)-1, len(args))
+	}
+
+	direction := " DESC"
+	ascending := page.After != nil && page.Before == nil
+	if ascending {
+		direction = ""
+	}
+
+	args = append(args, page.Limit)
+	query += fmt.Sprintf(" ORDER BY m.time_created%s, m.message_id%s LIMIT $%d", direction, direction, len(args))
+
+	rows, err := pg.pool.Query(ctx, `SELECT sm.*, ts_headline('simple', sm.content, websearch_to_tsquery('simple', $1), $2), rm.name
+		FROM (`+query+`) AS sm
+		JOIN rooms AS rm ON rm.id = sm.room_id
+		ORDER BY sm.time_created`+direction+`, sm.message_id`+direction, args...)
+	if err != nil {
+		return nil, fmt.Errorf("storage.pg.SearchMessages: %w", err)
+	}
+	defer rows.Close()
+
+	hits := make([]domain.SearchHit, 0)
+	for rows.Next() {
+		var hit domain.SearchHit
+		err = scanMessage(rows, &hit.Message, &hit.Snippet, &hit.RoomName)
+		if err != nil {
+			return nil, fmt.Errorf("storage.pg.SearchMessages: %w", err)
+		}
+
+		hits = append(hits, hit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("storage.pg.SearchMessages: %w", err)
+	}
+
+	if ascending {
+		slices.Reverse(hits)
+	}
+
+	return hits, nil
+}
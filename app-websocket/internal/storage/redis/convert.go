@@ -2,18 +2,36 @@ package redis
 
 import (
 	"app-websocket/internal/domain"
+	"encoding/json"
+	"fmt"
 )
 
-func mapToUsers(m map[string]string) []domain.User {
-	var users []domain.User
-	for key, value := range m {
-		user := domain.User{
-			ID:       key,
-			Nickname: value,
+func decodeMessages(jsonMsgs []string) ([]domain.Message, error) {
+	var messages []domain.Message
+	for _, jsonMsg := range jsonMsgs {
+		var msg domain.Message
+		err := json.Unmarshal([]byte(jsonMsg), &msg)
+		if err != nil {
+			return nil, fmt.Errorf("storage.redis.decodeMessages: %w", err)
 		}
 
-		users = append(users, user)
+		messages = append(messages, msg)
 	}
 
-	return users
+	return messages, nil
+}
+
+func decodeReadReceipts(m map[string]string) ([]domain.ReadReceipt, error) {
+	receipts := make([]domain.ReadReceipt, 0, len(m))
+	for _, value := range m {
+		var receipt domain.ReadReceipt
+		err := json.Unmarshal([]byte(value), &receipt)
+		if err != nil {
+			return nil, fmt.Errorf("storage.redis.decodeReadReceipts: %w", err)
+		}
+
+		receipts = append(receipts, receipt)
+	}
+
+	return receipts, nil
 }
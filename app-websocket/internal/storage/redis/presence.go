@@ -0,0 +1,310 @@
+package redis
+
+import (
+	"app-websocket/internal/domain"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Presence is kept in these keys:
+//
+//	presence:conn:{connID}        the record of a connection, expires unless refreshed
+//	presence:room:{roomID}        sorted set of the connections in a room
+//	presence:online               sorted set of all connections
+//	presence:instance:{instance}  set of the connections an instance holds
+//	presence:instances            sorted set of the instances
+//
+// The sorted sets are scored by the time their members expire, so readers skip the members of
+// an instance that stopped sending heartbeats even before anyone cleans them up. Only single-key
+// commands are used, which keeps presence working on a Redis cluster.
+const (
+	onlineKey    = "presence:online"
+	instancesKey = "presence:instances"
+)
+
+// SavePresence stores the records of the connections the instance holds and extends their
+// life and the life of the instance by ttl.
+func (r *Redis) SavePresence(ctx context.Context, instance string, records []domain.Presence, ttl time.Duration) error {
+	expiresAt := expiryScore(time.Now().Add(ttl))
+
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range records {
+			buf, err := json.Marshal(&records[i])
+			if err != nil {
+				return err
+			}
+
+			member := presenceMember(&records[i])
+			pipe.Set(ctx, presenceKey(records[i].ConnID), buf, ttl)
+			pipe.ZAdd(ctx, roomPresenceKey(records[i].RoomID), redis.Z{Score: expiresAt, Member: member})
+			pipe.ZAdd(ctx, onlineKey, redis.Z{Score: expiresAt, Member: member})
+			pipe.SAdd(ctx, instancePresenceKey(instance), records[i].RoomID+"/"+member)
+		}
+
+		pipe.ZAdd(ctx, instancesKey, redis.Z{Score: expiresAt, Member: instance})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("storage.redis.SavePresence: %w", err)
+	}
+
+	return nil
+}
+
+// userPresence is the tail of the scripts that add a connection to the room or remove it:
+// it counts the live connections of the user right after the change, so of concurrent
+// connections of a user only one sees itself as the first or the last. KEYS[1] is the room
+// sorted set, ARGV[1] the member, ARGV[2] the member prefix of the user, ARGV[3] the time now.
+const userPresence = `
+local count = 0
+for _, member in ipairs(redis.call('ZRANGEBYSCORE', KEYS[1], ARGV[3], '+inf')) do
+	if string.sub(member, 1, #ARGV[2]) == ARGV[2] then
+		count = count + 1
+	end
+end
+
+return count
+`
+
+// addRoomPresence adds the member to the room with the expiry score ARGV[4].
+var addRoomPresence = redis.NewScript(`
+redis.call('ZADD', KEYS[1], ARGV[4], ARGV[1])
+` + userPresence)
+
+// removeRoomPresence removes the member from the room.
+var removeRoomPresence = redis.NewScript(`
+redis.call('ZREM', KEYS[1], ARGV[1])
+` + userPresence)
+
+// AddPresence stores the record of a new connection of the instance and returns the number of
+// live connections of the user to the room on all instances, this one included.
+func (r *Redis) AddPresence(ctx context.Context, instance string, presence *domain.Presence, ttl time.Duration) (int, error) {
+	now := time.Now()
+	expiresAt := expiryScore(now.Add(ttl))
+	member := presenceMember(presence)
+
+	buf, err := json.Marshal(presence)
+	if err != nil {
+		return 0, fmt.Errorf("storage.redis.AddPresence: %w", err)
+	}
+
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, presenceKey(presence.ConnID), buf, ttl)
+		pipe.ZAdd(ctx, onlineKey, redis.Z{Score: expiresAt, Member: member})
+		pipe.SAdd(ctx, instancePresenceKey(instance), presence.RoomID+"/"+member)
+		pipe.ZAdd(ctx, instancesKey, redis.Z{Score: expiresAt, Member: instance})
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("storage.redis.AddPresence: %w", err)
+	}
+
+	count, err := addRoomPresence.Run(ctx, r.client, []string{roomPresenceKey(presence.RoomID)},
+		member, presence.UserID+":", expiryScore(now), expiresAt).Int()
+	if err != nil {
+		return 0, fmt.Errorf("storage.redis.AddPresence: %w", err)
+	}
+
+	return count, nil
+}
+
+// DeletePresence removes the record of a closed connection and returns the number of live
+// connections of the user to the room left on all instances.
+func (r *Redis) DeletePresence(ctx context.Context, presence *domain.Presence) (int, error) {
+	member := presenceMember(presence)
+
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, presenceKey(presence.ConnID))
+		pipe.ZRem(ctx, onlineKey, member)
+		pipe.SRem(ctx, instancePresenceKey(presence.Instance), presence.RoomID+"/"+member)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("storage.redis.DeletePresence: %w", err)
+	}
+
+	count, err := removeRoomPresence.Run(ctx, r.client, []string{roomPresenceKey(presence.RoomID)},
+		member, presence.UserID+":", expiryScore(time.Now())).Int()
+	if err != nil {
+		return 0, fmt.Errorf("storage.redis.DeletePresence: %w", err)
+	}
+
+	return count, nil
+}
+
+// GetRoomPresence returns the live records of the connections in the room.
+func (r *Redis) GetRoomPresence(ctx context.Context, roomID string) ([]domain.Presence, error) {
+	records, err := r.getPresence(ctx, roomPresenceKey(roomID))
+	if err != nil {
+		return nil, fmt.Errorf("storage.redis.GetRoomPresence: %w", err)
+	}
+
+	return records, nil
+}
+
+// GetOnlinePresence returns the live records of all connections.
+func (r *Redis) GetOnlinePresence(ctx context.Context) ([]domain.Presence, error) {
+	records, err := r.getPresence(ctx, onlineKey)
+	if err != nil {
+		return nil, fmt.Errorf("storage.redis.GetOnlinePresence: %w", err)
+	}
+
+	return records, nil
+}
+
+func (r *Redis) getPresence(ctx context.Context, key string) ([]domain.Presence, error) {
+	members, err := r.livePresenceMembers(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := make([]*redis.StringCmd, len(members))
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, member := range members {
+			_, connID, _ := strings.Cut(member, ":")
+			cmds[i] = pipe.Get(ctx, presenceKey(connID))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	records := make([]domain.Presence, 0, len(members))
+	for _, cmd := range cmds {
+		buf, err := cmd.Bytes()
+		if errors.Is(err, redis.Nil) {
+			// the record has just expired, its member is left for the next cleanup
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var record domain.Presence
+		err = json.Unmarshal(buf, &record)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// livePresenceMembers drops the expired members of the sorted set and returns the others.
+func (r *Redis) livePresenceMembers(ctx context.Context, key string) ([]string, error) {
+	now := strconv.FormatFloat(expiryScore(time.Now()), 'f', -1, 64)
+
+	var members *redis.StringSliceCmd
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", "("+now)
+		members = pipe.ZRangeByScore(ctx, key, &redis.ZRangeBy{Min: now, Max: "+inf"})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return members.Val(), nil
+}
+
+// CountRoomPresence returns the number of users online in each of the rooms,
+// a user connected several times is counted once.
+func (r *Redis) CountRoomPresence(ctx context.Context, roomIDs []string) (map[string]int, error) {
+	now := strconv.FormatFloat(expiryScore(time.Now()), 'f', -1, 64)
+
+	cmds := make([]*redis.StringSliceCmd, len(roomIDs))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, roomID := range roomIDs {
+			cmds[i] = pipe.ZRangeByScore(ctx, roomPresenceKey(roomID), &redis.ZRangeBy{Min: now, Max: "+inf"})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("storage.redis.CountRoomPresence: %w", err)
+	}
+
+	counts := make(map[string]int, len(roomIDs))
+	for i, roomID := range roomIDs {
+		users := make(map[string]struct{})
+		for _, member := range cmds[i].Val() {
+			userID, _, _ := strings.Cut(member, ":")
+			users[userID] = struct{}{}
+		}
+
+		counts[roomID] = len(users)
+	}
+
+	return counts, nil
+}
+
+// GetExpiredInstances returns the instances that stopped sending heartbeats.
+func (r *Redis) GetExpiredInstances(ctx context.Context) ([]string, error) {
+	now := strconv.FormatFloat(expiryScore(time.Now()), 'f', -1, 64)
+
+	instances, err := r.client.ZRangeByScore(ctx, instancesKey, &redis.ZRangeBy{Min: "-inf", Max: "(" + now}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("storage.redis.GetExpiredInstances: %w", err)
+	}
+
+	return instances, nil
+}
+
+// DeleteInstancePresence removes every connection of the instance from the presence.
+// Any instance may call it for a dead one: removing what is already gone is a no-op.
+func (r *Redis) DeleteInstancePresence(ctx context.Context, instance string) error {
+	entries, err := r.client.SMembers(ctx, instancePresenceKey(instance)).Result()
+	if err != nil {
+		return fmt.Errorf("storage.redis.DeleteInstancePresence: %w", err)
+	}
+
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, entry := range entries {
+			roomID, member, _ := strings.Cut(entry, "/")
+			_, connID, _ := strings.Cut(member, ":")
+
+			pipe.Del(ctx, presenceKey(connID))
+			pipe.ZRem(ctx, roomPresenceKey(roomID), member)
+			pipe.ZRem(ctx, onlineKey, member)
+		}
+
+		pipe.Del(ctx, instancePresenceKey(instance))
+		pipe.ZRem(ctx, instancesKey, instance)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("storage.redis.DeleteInstancePresence: %w", err)
+	}
+
+	return nil
+}
+
+// presenceMember is how a connection appears in the sorted sets: the user goes first so the
+// users can be counted without reading the records.
+func presenceMember(presence *domain.Presence) string {
+	return presence.UserID + ":" + presence.ConnID
+}
+
+func expiryScore(t time.Time) float64 {
+	return float64(t.UnixMilli())
+}
+
+func presenceKey(connID string) string {
+	return "presence:conn:" + connID
+}
+
+func roomPresenceKey(roomID string) string {
+	return "presence:room:" + roomID
+}
+
+func instancePresenceKey(instance string) string {
+	return "presence:instance:" + instance
+}
@@ -5,11 +5,19 @@ import (
 	"app-websocket/internal/domain"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/redis/go-redis/v9"
 	"log/slog"
+	"time"
 )
 
+// signalsChannel carries ephemeral signals of all rooms between the instances.
+const signalsChannel = "signals"
+
+// pendingTTL is how long a sent message is kept for lookups; by then the consumer has long stored it.
+const pendingTTL = 10 * time.Minute
+
 type Redis struct {
 	client redis.UniversalClient
 	logger *slog.Logger
@@ -33,57 +41,199 @@ func New(config *config.RedisConfig, logger *slog.Logger) (*Redis, error) {
 }
 
 func (r *Redis) GetLastMessagesFromRoom(ctx context.Context, roomID string, count int) ([]domain.Message, error) {
-	jsonMsgs, err := r.client.LRange(ctx, roomID, 0, int64(count)).Result()
+	jsonMsgs, err := r.client.LRange(ctx, roomID, 0, int64(count)-1).Result()
 	if err != nil {
 		return nil, fmt.Errorf("storage.redis.GetList: %w", err)
 	}
 
-	var messages []domain.Message
-	for _, jsonMsg := range jsonMsgs {
-		var msg domain.Message
-		err = json.Unmarshal([]byte(jsonMsg), &msg)
-		if err != nil {
-			return nil, fmt.Errorf("storage.redis.GetList: %w", err)
-		}
+	return decodeMessages(jsonMsgs)
+}
 
-		messages = append(messages, msg)
+// GetCachedMessages returns the whole cached history of the room, newest first.
+func (r *Redis) GetCachedMessages(ctx context.Context, roomID string) ([]domain.Message, error) {
+	jsonMsgs, err := r.client.LRange(ctx, roomID, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("storage.redis.GetCachedMessages: %w", err)
 	}
 
-	return messages, nil
+	return decodeMessages(jsonMsgs)
 }
 
-func (r *Redis) GetRoomClients(ctx context.Context, roomID string) ([]domain.User, error) {
-	key := "room:" + roomID
-	hashTable, err := r.client.HGetAll(ctx, key).Result()
+// GetReadReceipts returns the cached read positions of the room, one per user.
+func (r *Redis) GetReadReceipts(ctx context.Context, roomID string) ([]domain.ReadReceipt, error) {
+	hashTable, err := r.client.HGetAll(ctx, readsKey(roomID)).Result()
 	if err != nil {
-		return nil, fmt.Errorf("storage.redis.GetRoomClients: %w", err)
+		return nil, fmt.Errorf("storage.redis.GetReadReceipts: %w", err)
 	}
 
-	users := mapToUsers(hashTable)
+	receipts, err := decodeReadReceipts(hashTable)
+	if err != nil {
+		return nil, fmt.Errorf("storage.redis.GetReadReceipts: %w", err)
+	}
 
-	return users, nil
+	return receipts, nil
 }
 
-func (r *Redis) AddRoomClient(ctx context.Context, roomID string, user *domain.User) error {
-	key := "room:" + roomID
-	err := r.client.HSet(ctx, key, user.ID, user.Nickname).Err()
+// SetPendingMessage keeps the sent message until the consumer stores it, so it can be found
+// right after it is sent.
+func (r *Redis) SetPendingMessage(ctx context.Context, msg *domain.Message) error {
+	jsonMsg, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("storage.redis.SetPendingMessage: %w", err)
+	}
+
+	err = r.client.Set(ctx, pendingKey(msg.RoomID, msg.ID), jsonMsg, pendingTTL).Err()
 	if err != nil {
-		return fmt.Errorf("storage.redis.AddRoomClient: %w", err)
+		return fmt.Errorf("storage.redis.SetPendingMessage: %w", err)
 	}
 
 	return nil
 }
 
-func (r *Redis) DeleteClient(ctx context.Context, roomID string, user *domain.User) error {
-	key := "room:" + roomID
-	err := r.client.HDel(ctx, key, user.ID).Err()
+// GetPendingMessage returns the message kept when it was sent, nil if there is none.
+func (r *Redis) GetPendingMessage(ctx context.Context, roomID, messageID string) (*domain.Message, error) {
+	buf, err := r.client.Get(ctx, pendingKey(roomID, messageID)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("storage.redis.GetPendingMessage: %w", err)
+	}
+
+	var msg domain.Message
+	err = json.Unmarshal(buf, &msg)
+	if err != nil {
+		return nil, fmt.Errorf("storage.redis.GetPendingMessage: %w", err)
+	}
+
+	return &msg, nil
+}
+
+// GetReadReceipt returns the cached read position of the user in the room, nil if none is cached.
+func (r *Redis) GetReadReceipt(ctx context.Context, roomID, userID string) (*domain.ReadReceipt, error) {
+	buf, err := r.client.HGet(ctx, readsKey(roomID), userID).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("storage.redis.GetReadReceipt: %w", err)
+	}
+
+	var receipt domain.ReadReceipt
+	err = json.Unmarshal(buf, &receipt)
+	if err != nil {
+		return nil, fmt.Errorf("storage.redis.GetReadReceipt: %w", err)
+	}
+
+	return &receipt, nil
+}
+
+// nextSeq increments the room sequence counter: KEYS[1] is the counter. A missing counter is
+// first set to ARGV[1], the last sequence number stored; without ARGV[1] nil is returned instead.
+var nextSeq = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	if #ARGV == 0 then
+		return false
+	end
+
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+
+return redis.call('INCR', KEYS[1])
+`)
+
+// NextSeq returns the next sequence number of the room. It reports false when the counter
+// is missing and has to be seeded with SeedNextSeq.
+func (r *Redis) NextSeq(ctx context.Context, roomID string) (int64, bool, error) {
+	seq, err := nextSeq.Run(ctx, r.client, []string{seqKey(roomID)}).Int64()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return 0, false, nil
+		}
+
+		return 0, false, fmt.Errorf("storage.redis.NextSeq: %w", err)
+	}
+
+	return seq, true, nil
+}
+
+// SeedNextSeq returns the next sequence number of the room. last is the highest number stored
+// in persistent storage, which the counter continues from if it is still missing.
+func (r *Redis) SeedNextSeq(ctx context.Context, roomID string, last int64) (int64, error) {
+	seq, err := nextSeq.Run(ctx, r.client, []string{seqKey(roomID)}, last).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("storage.redis.SeedNextSeq: %w", err)
+	}
+
+	return seq, nil
+}
+
+// PublishSignal shares the signal with every instance subscribed to the signals channel.
+func (r *Redis) PublishSignal(ctx context.Context, signal *domain.Signal) error {
+	buf, err := json.Marshal(signal)
 	if err != nil {
-		return fmt.Errorf("storage.redis.DeleteClient: %w", err)
+		return fmt.Errorf("storage.redis.PublishSignal: %w", err)
+	}
+
+	err = r.client.Publish(ctx, signalsChannel, buf).Err()
+	if err != nil {
+		return fmt.Errorf("storage.redis.PublishSignal: %w", err)
 	}
 
 	return nil
 }
 
+// ConsumeSignals passes the signals published by any instance to handler until ctx is done.
+// A signal the handler fails on is logged and skipped: nobody can receive it again anyway.
+func (r *Redis) ConsumeSignals(ctx context.Context, handler domain.SignalHandler) error {
+	pubSub := r.client.Subscribe(ctx, signalsChannel)
+	defer pubSub.Close()
+
+	_, err := pubSub.Receive(ctx)
+	if err != nil {
+		return fmt.Errorf("storage.redis.ConsumeSignals: %w", err)
+	}
+
+	messages := pubSub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case msg, ok := <-messages:
+			if !ok {
+				return errors.New("storage.redis.ConsumeSignals: subscription is closed")
+			}
+
+			var signal domain.Signal
+			err = json.Unmarshal([]byte(msg.Payload), &signal)
+			if err != nil {
+				r.logger.Error("can not decode signal", slog.String("error", err.Error()))
+				continue
+			}
+
+			err = handler(signal)
+			if err != nil {
+				r.logger.Error("failed to handle signal", slog.String("type", string(signal.Type)), slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+func seqKey(roomID string) string {
+	return "seq:" + roomID
+}
+
+func pendingKey(roomID, messageID string) string {
+	return "pending:" + roomID + ":" + messageID
+}
+
+func readsKey(roomID string) string {
+	return "reads:" + roomID
+}
+
 func (r *Redis) Close() {
 	err := r.client.Close()
 	if err != nil {
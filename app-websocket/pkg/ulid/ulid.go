@@ -0,0 +1,83 @@
+package ulid
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// encoding is Crockford's base32 alphabet, which keeps the string form sortable.
+const encoding = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// Generator produces ULIDs: 48 bits of millisecond timestamp followed by 80 random bits.
+// IDs generated within the same millisecond increment the random part, so they stay monotonic.
+type Generator struct {
+	mu       sync.Mutex
+	lastMs   uint64
+	lastRand [10]byte
+}
+
+var defaultGenerator = &Generator{}
+
+// New returns a new ULID for the current time from the default generator.
+func New() string {
+	return defaultGenerator.New(time.Now())
+}
+
+func (g *Generator) New(t time.Time) string {
+	ms := uint64(t.UnixMilli())
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if ms <= g.lastMs {
+		ms = g.lastMs
+		if !increment(g.lastRand[:]) {
+			// random part overflowed: borrow the next millisecond
+			ms++
+			_, _ = rand.Read(g.lastRand[:])
+		}
+	} else {
+		_, _ = rand.Read(g.lastRand[:])
+	}
+	g.lastMs = ms
+
+	var id [16]byte
+	for i := 0; i < 6; i++ {
+		id[i] = byte(ms >> (40 - 8*i))
+	}
+	copy(id[6:], g.lastRand[:])
+
+	return encode(id)
+}
+
+// increment adds one to a big-endian number and reports false on overflow.
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// encode renders 128 bits as 26 base32 characters, the first one carrying only 3 bits.
+func encode(id [16]byte) string {
+	var out [26]byte
+	for i := range out {
+		var v byte
+		for b := 0; b < 5; b++ {
+			pos := i*5 + b - 2
+			v <<= 1
+			if pos >= 0 && id[pos/8]&(0x80>>(pos%8)) != 0 {
+				v |= 1
+			}
+		}
+
+		out[i] = encoding[v]
+	}
+
+	return string(out[:])
+}
@@ -1,33 +1,81 @@
 import React from 'react';
-import { Message } from '../pages/app';
+import { Attachment, Message } from '../pages/app';
+import { API_URL } from '../constants';
+
+// Attachments are downloaded with the token in the query, so images and links work without headers
+const Attachments = ({ attachments, token }: { attachments?: Array<Attachment>; token?: string }) => {
+    if (!attachments?.length) {
+        return null;
+    }
+
+    const link = (url: string) => `${API_URL}${url}?access_token=${encodeURIComponent(token ?? '')}`;
 
-const ChatBody = ({ data }: { data: Array<Message> }) => {
+    return (
+        <div className='flex flex-wrap gap-2 mt-1'>
+            {attachments.map((attachment) =>
+                attachment.content_type.startsWith('image/') ? (
+                    <a href={link(attachment.url)} target='_blank' rel='noreferrer' key={attachment.id}>
+                        <img
+                            src={link(attachment.thumbnail_url ?? attachment.url)}
+                            alt={attachment.name}
+                            className='rounded-md max-h-48'
+                        />
+                    </a>
+                ) : (
+                    <a href={link(attachment.url)} className='underline text-sm' key={attachment.id}>
+                        {attachment.name} ({Math.ceil(attachment.size / 1024)} KB)
+                    </a>
+                )
+            )}
+        </div>
+    );
+};
+
+const ChatBody = ({ data, token }: { data: Array<Message>; token?: string }) => {
     return (
         <>
             {data.map((message: Message, index: number) => {
                 const messageDate = new Date(message.time_created).toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' }); // Convert time_created to time string
 
+                if (message.kind === 'system') {
+                    return (
+                        <div className='mt-2 w-full text-center text-xs text-gray-500' key={message.id || index}>
+                            {message.nickname} {message.event === 'member.joined' ? 'joined the room' : 'left the room'} · {messageDate}
+                        </div>
+                    );
+                }
+
                 if (message.type === 'self') {
                     return (
-                        <div className='flex flex-col mt-2 w-full text-right justify-end' key={index}>
+                        <div className='flex flex-col mt-2 w-full text-right justify-end' key={message.id || index}>
                             <div className='text-sm'>{message.nickname}</div>
                             <div>
                                 <div className='bg-blue text-white px-4 py-1 rounded-md inline-block mt-1'>
-                                    {message.content}
+                                    {message.deleted_at ? <i>message deleted</i> : message.content}
+                                </div>
+                                {!message.deleted_at && <Attachments attachments={message.attachments} token={token} />}
+                                <div className='text-xs text-gray-500 mt-1'>
+                                    {messageDate} {/* Display message time */}
+                                    {message.reply_count ? ` · ${message.reply_count} replies` : ''}
+                                    {Object.entries(message.reactions ?? {}).map(([emoji, count]) => ` ${emoji} ${count}`)}
                                 </div>
-                                <div className='text-xs text-gray-500 mt-1'>{messageDate}</div> {/* Display message time */}
                             </div>
                         </div>
                     );
                 } else {
                     return (
-                        <div className='mt-2' key={index}>
+                        <div className='mt-2' key={message.id || index}>
                             <div className='text-sm'>{message.nickname}</div>
                             <div>
                                 <div className='bg-grey text-dark-secondary px-4 py-1 rounded-md inline-block mt-1'>
-                                    {message.content}
+                                    {message.deleted_at ? <i>message deleted</i> : message.content}
+                                </div>
+                                {!message.deleted_at && <Attachments attachments={message.attachments} token={token} />}
+                                <div className='text-xs text-gray-500 mt-1'>
+                                    {messageDate} {/* Display message time */}
+                                    {message.reply_count ? ` · ${message.reply_count} replies` : ''}
+                                    {Object.entries(message.reactions ?? {}).map(([emoji, count]) => ` ${emoji} ${count}`)}
                                 </div>
-                                <div className='text-xs text-gray-500 mt-1'>{messageDate}</div> {/* Display message time */}
                             </div>
                         </div>
                     );
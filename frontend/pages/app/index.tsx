@@ -7,20 +7,59 @@ import autosize from 'autosize';
 import { AuthContext } from '../../modules/auth_provider';
 
 export type Message = {
+    id: string;
+    seq: number;
+    kind?: 'user' | 'system';
+    event?: 'member.joined' | 'member.left';
     content: string;
     user_id: string;
     nickname: string;
     room_id: string;
     time_created: string;
+    edited_at?: string;
+    deleted_at?: string;
+    reply_to?: string;
+    thread_id?: string;
+    reply_count?: number;
+    last_reply_at?: string;
+    reactions?: Record<string, number>;
+    attachments?: Array<Attachment>;
     type: 'recv' | 'self';
 };
 
+export type Attachment = {
+    id: string;
+    name: string;
+    content_type: string;
+    size: number;
+    width?: number;
+    height?: number;
+    url: string;
+    thumbnail_url?: string;
+};
+
+export const PROTOCOL_VERSION = 1;
+
+export type Envelope<T = any> = {
+    v: number;
+    type: 'message' | 'edit' | 'delete' | 'typing' | 'ack' | 'ping' | 'pong' | 'error' | 'history' | 'reaction' | 'read' | 'mention' | 'presence' | 'system' | 'gap';
+    id?: string;
+    payload?: T;
+};
+
 const Index = () => {
     const [messages, setMessage] = useState<Array<Message>>([]);
     const textarea = useRef<HTMLTextAreaElement>(null);
     const bottomRef = useRef<HTMLDivElement>(null); // Ref for the bottom of the page
     const { conn } = useContext(WebsocketContext);
     const [users, setUsers] = useState<Array<{ nickname: string }>>([]);
+    const [typing, setTyping] = useState<Record<string, number>>({}); // nickname -> time the indicator expires
+    const lastTypingSent = useRef(0);
+    const lastReadSent = useRef('');
+    const [mention, setMention] = useState('');
+    const [reads, setReads] = useState<Record<string, number>>({}); // nickname -> seq of the last read message
+    const [pending, setPending] = useState<Array<Attachment>>([]); // uploaded files to send with the next message
+    const fileInput = useRef<HTMLInputElement>(null);
     const { user } = useContext(AuthContext); // Assuming you have a logout function in your AuthContext
     const router = useRouter();
     const { roomName, roomId } = router.query; // Accessing roomName query parameter
@@ -58,24 +97,109 @@ const Index = () => {
         }
 
         conn.onmessage = (message) => {
-            const m = JSON.parse(message.data);
+            const frame: Envelope = JSON.parse(message.data);
 
-            if (!Array.isArray(m)) {
-                if (m.content === 'joined the room') {
-                    setUsers([...users, { nickname: m.nickname }]);
+            switch (frame.type) {
+                case 'history': {
+                    const history: Array<Message> = frame.payload?.messages ?? [];
+                    history.forEach((m) => (user?.nickname === m.nickname ? (m.type = 'self') : (m.type = 'recv')));
+                    setMessage([...history.reverse()]);
+                    return;
                 }
+                case 'system': {
+                    const m: Message = frame.payload;
+                    if (m.event === 'member.joined') {
+                        setUsers([...users.filter((u) => u.nickname !== m.nickname), { nickname: m.nickname }]);
+                    }
+
+                    if (m.event === 'member.left') {
+                        setUsers(users.filter((u) => u.nickname !== m.nickname));
+                    }
 
-                if (m.content === 'left the room') {
-                    const deleteUser = users.filter((user) => user.nickname !== m.nickname);
-                    setUsers([...deleteUser]);
                     setMessage([...messages, m]);
                     return;
                 }
+                case 'message': {
+                    const m: Message = frame.payload;
+                    user?.nickname === m.nickname ? (m.type = 'self') : (m.type = 'recv');
+                    const updated = m.thread_id
+                        ? messages.map((root) =>
+                              root.id === m.thread_id ? { ...root, reply_count: (root.reply_count ?? 0) + 1, last_reply_at: m.time_created } : root,
+                          )
+                        : messages;
+                    setMessage([...updated, m]);
+                    return;
+                }
+                case 'edit': {
+                    const edited: Message = frame.payload;
+                    setMessage(messages.map((m) => (m.id === edited.id ? { ...m, content: edited.content, edited_at: edited.edited_at } : m)));
+                    return;
+                }
+                case 'delete': {
+                    const deleted: Message = frame.payload;
+                    setMessage(messages.map((m) => (m.id === deleted.id ? { ...m, content: '', deleted_at: deleted.deleted_at } : m)));
+                    return;
+                }
+                case 'reaction': {
+                    const { message_id, emoji, action } = frame.payload;
+                    setMessage(
+                        messages.map((m) => {
+                            if (m.id !== message_id) {
+                                return m;
+                            }
+
+                            const reactions = { ...(m.reactions ?? {}) };
+                            reactions[emoji] = (reactions[emoji] ?? 0) + (action === 'add' ? 1 : -1);
+                            if (reactions[emoji] <= 0) {
+                                delete reactions[emoji];
+                            }
 
-                user?.nickname === m.nickname ? (m.type = 'self') : (m.type = 'recv');
-                setMessage([...messages, m]);
-            } else {
-                setMessage([...m.reverse()]);
+                            return { ...m, reactions };
+                        }),
+                    );
+                    return;
+                }
+                case 'typing': {
+                    const { nickname, typing: isTyping, expires_in_ms } = frame.payload;
+                    setTyping((current) => {
+                        const next = { ...current };
+                        if (isTyping) {
+                            next[nickname] = Date.now() + (expires_in_ms ?? 5000);
+                        } else {
+                            delete next[nickname];
+                        }
+                        return next;
+                    });
+                    return;
+                }
+                case 'read': {
+                    const { nickname, seq } = frame.payload;
+                    setReads((current) => ({ ...current, [nickname]: seq }));
+                    return;
+                }
+                case 'mention': {
+                    const mention: Message = frame.payload;
+                    if (mention.room_id !== roomId) {
+                        setMention(`${mention.nickname} mentioned you: ${mention.content}`);
+                    }
+                    return;
+                }
+                case 'gap': {
+                    // the server dropped frames the tab did not read in time, the latest history replaces what we have
+                    (async () => {
+                        const res = await fetch(`${API_URL}/chat/rooms/${roomId}/messages`, {
+                            headers: { Authorization: `Bearer ${user.access_token}` },
+                        });
+                        const page = await res.json();
+                        const history: Array<Message> = page.messages ?? [];
+                        history.forEach((m) => (user?.nickname === m.nickname ? (m.type = 'self') : (m.type = 'recv')));
+                        setMessage([...history.reverse()]);
+                    })().catch((e) => console.error(e));
+                    return;
+                }
+                case 'error':
+                    console.error(frame.payload);
+                    return;
             }
         };
 
@@ -90,16 +214,95 @@ const Index = () => {
     }, [textarea, messages, conn, users]);
 
     const sendMessage = () => {
-        if (!textarea.current?.value) return;
+        if (!textarea.current?.value && pending.length === 0) return;
         if (conn === null) {
             router.push('/');
             return;
         }
 
-        conn.send(textarea.current.value);
-        textarea.current.value = '';
+        const frame: Envelope = {
+            v: PROTOCOL_VERSION,
+            type: 'message',
+            id: `${Date.now()}`,
+            payload: { content: textarea.current?.value ?? '', attachments: pending.map((a) => a.id) },
+        };
+        conn.send(JSON.stringify(frame));
+        if (textarea.current) {
+            textarea.current.value = '';
+        }
+        setPending([]);
+        lastTypingSent.current = 0;
     };
 
+    const uploadFile = async (file: File) => {
+        const form = new FormData();
+        form.append('file', file);
+
+        try {
+            const res = await fetch(`${API_URL}/chat/rooms/${roomId}/attachments`, {
+                method: 'POST',
+                headers: { Authorization: `Bearer ${user.access_token}` },
+                body: form,
+            });
+            const data = await res.json();
+            if (!res.ok) {
+                alert(data.message);
+                return;
+            }
+
+            setPending((current) => [...current, data]);
+        } catch (e) {
+            console.log(e);
+        }
+    };
+
+    useEffect(() => {
+        // everything on the screen counts as read
+        const last = messages[messages.length - 1];
+        if (conn === null || !last?.id || last.type === 'self' || last.id === lastReadSent.current) {
+            return;
+        }
+
+        lastReadSent.current = last.id;
+
+        const frame: Envelope = { v: PROTOCOL_VERSION, type: 'read', payload: { message_id: last.id } };
+        conn.send(JSON.stringify(frame));
+    }, [messages, conn]);
+
+    const handleTyping = () => {
+        if (conn === null || Date.now() - lastTypingSent.current < 2000) {
+            return;
+        }
+
+        lastTypingSent.current = Date.now();
+        const frame: Envelope = { v: PROTOCOL_VERSION, type: 'typing', payload: { typing: true } };
+        conn.send(JSON.stringify(frame));
+    };
+
+    useEffect(() => {
+        // a hidden tab makes the user away for the others
+        const sendPresence = () => {
+            if (conn === null || conn.readyState !== WebSocket.OPEN) {
+                return;
+            }
+
+            const status = document.visibilityState === 'hidden' ? 'away' : 'online';
+            const frame: Envelope = { v: PROTOCOL_VERSION, type: 'presence', payload: { status } };
+            conn.send(JSON.stringify(frame));
+        };
+
+        document.addEventListener('visibilitychange', sendPresence);
+        return () => document.removeEventListener('visibilitychange', sendPresence);
+    }, [conn]);
+
+    useEffect(() => {
+        // drop indicators whose stop frame never arrived
+        const timer = setInterval(() => {
+            setTyping((current) => Object.fromEntries(Object.entries(current).filter(([, expiresAt]) => expiresAt > Date.now())));
+        }, 1000);
+        return () => clearInterval(timer);
+    }, []);
+
     const handleMenu = () => {
         router.push('/'); // Redirect to the home page
     };
@@ -108,11 +311,27 @@ const Index = () => {
         <div className="flex flex-col w-full">
             <div className="bg-grey p-4 rounded-md mb-4 sticky top-0 z-10">
                 <h2 className="text-lg text-center">{roomName}</h2>
+                {mention && (
+                    <div className="text-xs text-center text-blue cursor-pointer" onClick={() => setMention('')}>
+                        {mention}
+                    </div>
+                )}
             </div>
             <div className="flex-grow overflow-y-auto p-4 md:mx-6 mb-14">
-                <ChatBody data={messages} />
+                <ChatBody data={messages} token={user?.access_token} />
                 {/* Ref for scrolling to bottom */}
                 <div ref={bottomRef}></div>
+                {messages.length > 0 && (
+                    <div className="text-xs text-gray-500 text-right">
+                        {Object.entries(reads)
+                            .filter(([nickname, seq]) => nickname !== user?.nickname && seq >= messages[messages.length - 1].seq)
+                            .map(([nickname]) => nickname)
+                            .join(', ')}
+                    </div>
+                )}
+                {Object.keys(typing).length > 0 && (
+                    <div className="text-xs text-gray-500">{Object.keys(typing).join(', ')} typing...</div>
+                )}
             </div>
             <div className="fixed bottom-0 mt-4 w-full">
                 <div className="flex md:flex-row px-4 py-2 bg-grey md:mx-4 rounded-md">
@@ -121,12 +340,30 @@ const Index = () => {
                             Menu
                         </button>
                     </div>
+                    <div className="flex items-center" style={{ marginLeft: '8px' }}>
+                        <input
+                            ref={fileInput}
+                            type="file"
+                            className="hidden"
+                            onChange={(e) => {
+                                const file = e.target.files?.[0];
+                                if (file) {
+                                    uploadFile(file);
+                                }
+                                e.target.value = '';
+                            }}
+                        />
+                        <button className="p-2 rounded-md bg-blue text-white" onClick={() => fileInput.current?.click()}>
+                            Attach{pending.length > 0 ? ` (${pending.length})` : ''}
+                        </button>
+                    </div>
                     <div className="flex w-full mr-4 rounded-md border border-blue" style={{ marginLeft: '8px' }}> {/* Added style for margin top */}
                         <textarea
                             ref={textarea}
                             placeholder="type your message here"
                             className="w-full h-10 p-2 rounded-md focus:outline-none"
                             style={{ resize: 'none' }}
+                            onInput={handleTyping}
                         />
                     </div>
                     <div className="flex items-center">
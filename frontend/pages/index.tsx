@@ -6,7 +6,7 @@ import { WebsocketContext } from '../modules/websocket_provider';
 import { useRouter } from 'next/router';
 
 const index = () => {
-  const [rooms, setRooms] = useState<{ id: string; name: string }[]>([])
+  const [rooms, setRooms] = useState<{ id: string; name: string; unread: number; members: number; online: number }[]>([])
   const [roomName, setRoomName] = useState('')
   const { user, setUser } = useContext(AuthContext);
   const { setConn } = useContext(WebsocketContext);
@@ -32,7 +32,7 @@ const index = () => {
       headers: { 'Authorization': `Bearer ${user.access_token}` }
     })
         .then(response => {
-          setRooms(response.data);
+          setRooms(response.data.rooms);
         })
         .catch(async error => {
           if (error.response && error.response.status === 401) {
@@ -149,6 +149,8 @@ const index = () => {
                     <div className='w-full'>
                       <div className='text-sm'>room</div>
                       <div className='text-blue font-bold text-lg'>{room.name}</div>
+                      <div className='text-xs text-gray-500'>{room.members} members, {room.online} online</div>
+                      {room.unread > 0 && <div className='text-xs text-gray-500'>{room.unread} unread</div>}
                     </div>
                     <div className=''>
                       <button
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_room_seq;
+
+DROP INDEX IF EXISTS idx_message_id;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS seq;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS message_id;
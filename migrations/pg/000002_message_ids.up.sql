@@ -0,0 +1,18 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS message_id VARCHAR (26);
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS seq BIGINT;
+
+-- legacy rows get zero-padded ids, which sort before any generated ULID
+UPDATE messages SET message_id = LPAD(id::text, 26, '0') WHERE message_id IS NULL;
+
+UPDATE messages AS m SET seq = s.seq
+FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY room_id ORDER BY time_created, id) AS seq FROM messages) AS s
+WHERE m.id = s.id AND m.seq IS NULL;
+
+ALTER TABLE messages ALTER COLUMN message_id SET NOT NULL;
+
+ALTER TABLE messages ALTER COLUMN seq SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_message_id ON messages (message_id);
+
+CREATE INDEX IF NOT EXISTS idx_room_seq ON messages (room_id, seq);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_room_time_message_id;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_room_time_message_id ON messages (room_id, time_created, message_id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_message_edits_message_id;
+
+DROP TABLE IF EXISTS message_edits;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS edited_at;
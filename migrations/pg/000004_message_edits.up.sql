@@ -0,0 +1,11 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS message_edits(
+   id serial PRIMARY KEY,
+   message_id VARCHAR (26) NOT NULL REFERENCES messages(message_id) ON DELETE CASCADE,
+   content VARCHAR (300) NOT NULL,
+   version_created TIMESTAMP NOT NULL,
+   replaced_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_edits_message_id ON message_edits (message_id);
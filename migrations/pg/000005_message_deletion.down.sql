@@ -0,0 +1,4 @@
+ALTER TABLE users DROP COLUMN IF EXISTS is_moderator;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE messages DROP COLUMN IF EXISTS deleted_by;
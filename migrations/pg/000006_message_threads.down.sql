@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_thread_time_message_id;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS last_reply_at;
+ALTER TABLE messages DROP COLUMN IF EXISTS reply_count;
+ALTER TABLE messages DROP COLUMN IF EXISTS thread_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS reply_to;
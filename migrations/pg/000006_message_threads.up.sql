@@ -0,0 +1,6 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_to VARCHAR (26) REFERENCES messages(message_id);
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS thread_id VARCHAR (26) REFERENCES messages(message_id);
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS last_reply_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_thread_time_message_id ON messages (thread_id, time_created, message_id);
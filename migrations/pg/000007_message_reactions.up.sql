@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS message_reactions(
+   message_id VARCHAR (26) NOT NULL REFERENCES messages(message_id) ON DELETE CASCADE,
+   user_id INTEGER NOT NULL REFERENCES users(id),
+   emoji VARCHAR (32) NOT NULL,
+   time_created TIMESTAMP NOT NULL,
+   PRIMARY KEY (message_id, user_id, emoji)
+);
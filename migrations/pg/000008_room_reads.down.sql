@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_room_reads_user_id;
+
+DROP TABLE IF EXISTS room_reads;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS room_reads(
+   room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+   user_id INTEGER NOT NULL REFERENCES users(id),
+   message_id VARCHAR (26) NOT NULL,
+   seq BIGINT NOT NULL,
+   time_read TIMESTAMP NOT NULL,
+   PRIMARY KEY (room_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_reads_user_id ON room_reads (user_id);
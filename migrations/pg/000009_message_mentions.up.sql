@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS message_mentions(
+   message_id VARCHAR (26) NOT NULL REFERENCES messages(message_id) ON DELETE CASCADE,
+   user_id INTEGER NOT NULL REFERENCES users(id),
+   PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_mentions_user_id ON message_mentions (user_id);
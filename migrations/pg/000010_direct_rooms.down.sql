@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_direct_rooms_user_high;
+
+DROP TABLE IF EXISTS direct_rooms;
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS kind;
@@ -0,0 +1,11 @@
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS kind VARCHAR (10) NOT NULL DEFAULT 'room';
+
+CREATE TABLE IF NOT EXISTS direct_rooms(
+   room_id INTEGER PRIMARY KEY REFERENCES rooms(id) ON DELETE CASCADE,
+   user_low INTEGER NOT NULL REFERENCES users(id),
+   user_high INTEGER NOT NULL REFERENCES users(id),
+   CHECK (user_low < user_high),
+   UNIQUE (user_low, user_high)
+);
+
+CREATE INDEX IF NOT EXISTS idx_direct_rooms_user_high ON direct_rooms (user_high);
@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS idx_room_invites_invitee_id;
+
+DROP INDEX IF EXISTS idx_room_invites_room_id;
+
+DROP TABLE IF EXISTS room_invites;
+
+DROP INDEX IF EXISTS idx_room_members_user_id;
+
+DROP TABLE IF EXISTS room_members;
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS visibility;
@@ -0,0 +1,26 @@
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS visibility VARCHAR (10) NOT NULL DEFAULT 'public';
+
+CREATE TABLE IF NOT EXISTS room_members(
+   room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+   user_id INTEGER NOT NULL REFERENCES users(id),
+   time_joined TIMESTAMP NOT NULL,
+   PRIMARY KEY (room_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_members_user_id ON room_members (user_id);
+
+CREATE TABLE IF NOT EXISTS room_invites(
+   id VARCHAR (26) PRIMARY KEY,
+   room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+   created_by INTEGER NOT NULL REFERENCES users(id),
+   invitee_id INTEGER REFERENCES users(id),
+   code VARCHAR (32) UNIQUE NOT NULL,
+   expires_at TIMESTAMP,
+   max_uses INTEGER NOT NULL DEFAULT 0,
+   uses INTEGER NOT NULL DEFAULT 0,
+   time_created TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_invites_room_id ON room_invites (room_id);
+
+CREATE INDEX IF NOT EXISTS idx_room_invites_invitee_id ON room_invites (invitee_id);
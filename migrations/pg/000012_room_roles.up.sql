@@ -0,0 +1,16 @@
+ALTER TABLE room_members ADD COLUMN IF NOT EXISTS role VARCHAR (10) NOT NULL DEFAULT 'member';
+
+-- rooms created before roles have no owner: the member who joined first becomes the owner,
+-- and in a room nobody has joined yet it is the author of the first message
+UPDATE room_members AS m SET role = 'owner'
+FROM (SELECT DISTINCT ON (room_id) room_id, user_id FROM room_members ORDER BY room_id, time_joined, user_id) AS f
+JOIN rooms AS r ON r.id = f.room_id
+WHERE m.room_id = f.room_id AND m.user_id = f.user_id AND r.kind <> 'direct'
+    AND NOT EXISTS (SELECT 1 FROM room_members AS o WHERE o.room_id = f.room_id AND o.role = 'owner');
+
+INSERT INTO room_members(room_id, user_id, time_joined, role)
+SELECT DISTINCT ON (msg.room_id) msg.room_id, msg.user_id, COALESCE(msg.time_created, now()), 'owner'
+FROM messages AS msg
+JOIN rooms AS r ON r.id = msg.room_id
+WHERE r.kind <> 'direct' AND NOT EXISTS (SELECT 1 FROM room_members AS m WHERE m.room_id = msg.room_id)
+ORDER BY msg.room_id, msg.seq;
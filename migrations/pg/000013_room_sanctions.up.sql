@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS room_sanctions(
+   room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+   user_id INTEGER NOT NULL REFERENCES users(id),
+   kind VARCHAR (10) NOT NULL,
+   reason VARCHAR (300) NOT NULL DEFAULT '',
+   created_by INTEGER NOT NULL REFERENCES users(id),
+   expires_at TIMESTAMP,
+   time_created TIMESTAMP NOT NULL,
+   PRIMARY KEY (room_id, user_id, kind)
+);
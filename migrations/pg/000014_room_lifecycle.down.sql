@@ -0,0 +1,9 @@
+ALTER TABLE rooms DROP COLUMN IF EXISTS deleted_at;
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS archived_at;
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS avatar_url;
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS description;
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS topic;
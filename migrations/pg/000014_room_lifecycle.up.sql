@@ -0,0 +1,9 @@
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS topic VARCHAR (250) NOT NULL DEFAULT '';
+
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS description VARCHAR (1000) NOT NULL DEFAULT '';
+
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS avatar_url VARCHAR (500) NOT NULL DEFAULT '';
+
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
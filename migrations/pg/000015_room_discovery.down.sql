@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_rooms_time_created;
+
+DROP INDEX IF EXISTS idx_rooms_activity;
+
+DROP INDEX IF EXISTS idx_rooms_name_trgm;
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS last_message_at;
@@ -0,0 +1,11 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS last_message_at TIMESTAMP;
+
+UPDATE rooms SET last_message_at = (SELECT MAX(m.time_created) FROM messages AS m WHERE m.room_id = rooms.id);
+
+CREATE INDEX IF NOT EXISTS idx_rooms_name_trgm ON rooms USING gin (name gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS idx_rooms_activity ON rooms ((COALESCE(last_message_at, time_created)) DESC, id DESC) WHERE deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_rooms_time_created ON rooms (time_created DESC, id DESC) WHERE deleted_at IS NULL;
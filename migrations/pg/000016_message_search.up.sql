@@ -0,0 +1,5 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector TSVECTOR;
+
+UPDATE messages SET search_vector = to_tsvector('simple', content) WHERE deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_messages_search_vector ON messages USING gin (search_vector);
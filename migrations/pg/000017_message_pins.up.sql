@@ -0,0 +1,5 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS pinned_by INTEGER REFERENCES users(id);
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS pinned_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_messages_pinned ON messages (room_id, pinned_at DESC) WHERE pinned_at IS NOT NULL;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_attachments_room_id;
+
+DROP INDEX IF EXISTS idx_attachments_message_id;
+
+DROP TABLE IF EXISTS attachments;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS attachments(
+   id VARCHAR (26) PRIMARY KEY,
+   room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+   message_id VARCHAR (26) REFERENCES messages(message_id) ON DELETE CASCADE,
+   uploaded_by INTEGER NOT NULL REFERENCES users(id),
+   name VARCHAR (255) NOT NULL,
+   content_type VARCHAR (100) NOT NULL,
+   size BIGINT NOT NULL,
+   blob_key VARCHAR (255) NOT NULL,
+   thumbnail_key VARCHAR (255),
+   width INTEGER,
+   height INTEGER,
+   time_created TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_message_id ON attachments (message_id);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_room_id ON attachments (room_id);
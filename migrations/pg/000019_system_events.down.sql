@@ -0,0 +1,9 @@
+ALTER TABLE rooms DROP COLUMN IF EXISTS system_events;
+
+UPDATE messages SET content = CASE system_event WHEN 'member.joined' THEN 'joined the room' ELSE 'left the room' END,
+    search_vector = to_tsvector('simple', CASE system_event WHEN 'member.joined' THEN 'joined the room' ELSE 'left the room' END)
+WHERE kind = 'system' AND system_event IN ('member.joined', 'member.left');
+
+ALTER TABLE messages DROP COLUMN IF EXISTS system_event;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS kind;
@@ -0,0 +1,18 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS kind VARCHAR (16) NOT NULL DEFAULT 'user';
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS system_event VARCHAR (32);
+
+-- join and leave used to be ordinary messages with exactly this content and nothing else,
+-- written by Subscribe and Unsubscribe; a message somebody replied to, edited, pinned, reacted to
+-- or attached files to is taken for typed by a user and stays as it is
+UPDATE messages AS m SET kind = 'system', content = '',
+    system_event = CASE m.content WHEN 'joined the room' THEN 'member.joined' ELSE 'member.left' END,
+    search_vector = NULL
+WHERE m.content IN ('joined the room', 'left the room') AND m.reply_to IS NULL AND m.reply_count = 0
+    AND m.edited_at IS NULL AND m.deleted_at IS NULL AND m.pinned_at IS NULL
+    AND NOT EXISTS (SELECT 1 FROM message_reactions AS r WHERE r.message_id = m.message_id)
+    AND NOT EXISTS (SELECT 1 FROM attachments AS a WHERE a.message_id = m.message_id);
+
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS system_events BOOLEAN NOT NULL DEFAULT TRUE;
+
+UPDATE rooms SET system_events = FALSE WHERE kind = 'direct';